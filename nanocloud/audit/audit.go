@@ -0,0 +1,355 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+// Package audit records the administrative actions: who did what to which
+// resource, with the state of the resource before and after. The events are
+// stored in the append-only audit_events table, chained by their hash.
+package audit
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/chain"
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+const (
+	UserActor           = "user"
+	ServiceAccountActor = "service-account"
+)
+
+type Event struct {
+	Id             int64            `json:"-"`
+	ActorType      string           `json:"actor-type"`
+	ActorId        string           `json:"actor-id"`
+	ImpersonatorId string           `json:"impersonator-id"`
+	Action         string           `json:"action"`
+	TargetType     string           `json:"target-type"`
+	TargetId       string           `json:"target-id"`
+	Before         *json.RawMessage `json:"before"`
+	After          *json.RawMessage `json:"after"`
+	Ip             string           `json:"ip"`
+	UserAgent      string           `json:"user-agent"`
+	CreatedAt      time.Time        `json:"created-at"`
+}
+
+func (e *Event) GetID() string {
+	return strconv.FormatInt(e.Id, 10)
+}
+
+func (e *Event) SetID(id string) error {
+	var err error
+	e.Id, err = strconv.ParseInt(id, 10, 64)
+	return err
+}
+
+// Serialize the state of a resource. The passwords of the users never reach
+// the log.
+func encode(state interface{}) (*json.RawMessage, error) {
+	if state == nil {
+		return nil, nil
+	}
+
+	switch user := state.(type) {
+	case *users.User:
+		if user == nil {
+			return nil, nil
+		}
+		clean := *user
+		clean.Password = ""
+		state = &clean
+	case users.User:
+		user.Password = ""
+		state = user
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage(b)
+	return &raw, nil
+}
+
+// Fill the actor of the event with the identity the middlewares have set
+func setActor(e *Event, c *echo.Context) {
+	if user, ok := c.Get("user").(*users.User); ok {
+		e.ActorType = UserActor
+		e.ActorId = user.Id
+	} else if account, ok := c.Get("service-account").(*oauth.ServiceAccount); ok {
+		e.ActorType = ServiceAccountActor
+		e.ActorId = strconv.Itoa(account.ClientId)
+	}
+
+	if impersonator, ok := c.Get("impersonator").(string); ok {
+		e.ImpersonatorId = impersonator
+	}
+}
+
+func nullableJSON(raw *json.RawMessage) interface{} {
+	if raw == nil {
+		return nil
+	}
+	return string(*raw)
+}
+
+func rawBytes(raw *json.RawMessage) []byte {
+	if raw == nil {
+		return nil
+	}
+	return []byte(*raw)
+}
+
+// Fields of the event covered by its hash
+func chainFields(e *Event, before, after []byte) ([]string, error) {
+	canonicalBefore, err := chain.CanonicalJSON(before)
+	if err != nil {
+		return nil, err
+	}
+
+	canonicalAfter, err := chain.CanonicalJSON(after)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{
+		e.ActorType, e.ActorId, e.ImpersonatorId,
+		e.Action, e.TargetType, e.TargetId,
+		canonicalBefore, canonicalAfter,
+		e.Ip, e.UserAgent,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}, nil
+}
+
+// Append the event to the chain of the audit events
+func insert(e *Event) error {
+	// the database keeps microseconds, the hash must cover the stored date
+	e.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+
+	fields, err := chainFields(e, rawBytes(e.Before), rawBytes(e.After))
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	prev, err := kChain.Head(tx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(
+		`INSERT INTO audit_events
+		(actor_type, actor_id, impersonator_id,
+		 action, target_type, target_id,
+		 before, after, ip, user_agent,
+		 created_at, hash)
+		VALUES
+		($1::varchar, $2::varchar, $3::varchar,
+		 $4::varchar, $5::varchar, $6::varchar,
+		 $7::jsonb, $8::jsonb, $9::varchar, $10::varchar,
+		 $11::timestamp with time zone, $12::varchar)
+		RETURNING id`,
+		e.ActorType, e.ActorId, e.ImpersonatorId,
+		e.Action, e.TargetType, e.TargetId,
+		nullableJSON(e.Before), nullableJSON(e.After), e.Ip, e.UserAgent,
+		e.CreatedAt, chain.Link(prev, fields...),
+	).Scan(&e.Id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The events in the order they have been chained
+func chainRows(after int64, limit int) ([]chain.Row, error) {
+	rows, err := db.Query(
+		`SELECT id, hash, actor_type, actor_id, impersonator_id,
+		action, target_type, target_id,
+		before::text, after::text, ip, user_agent, created_at
+		FROM audit_events
+		WHERE id > $1::bigint
+		ORDER BY id
+		LIMIT $2::integer`,
+		after, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]chain.Row, 0)
+	for rows.Next() {
+		e := Event{}
+		row := chain.Row{}
+		var before, after []byte
+		err = rows.Scan(
+			&row.Seq, &row.Hash, &e.ActorType, &e.ActorId, &e.ImpersonatorId,
+			&e.Action, &e.TargetType, &e.TargetId,
+			&before, &after, &e.Ip, &e.UserAgent, &e.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		row.Fields, err = chainFields(&e, before, after)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+var kChain = &chain.Table{
+	Name: "audit_events",
+	Seq:  "id",
+	Rows: chainRows,
+}
+
+func init() {
+	chain.Register(kChain)
+}
+
+/*
+ * Record the action done by the author of the request on the target. before
+ * and after are the states of the target, nil when it didn't or doesn't exist
+ * anymore. The action has already been done when it is recorded so a failure
+ * is logged rather than returned.
+ */
+func Record(c *echo.Context, action, targetType, targetId string, before, after interface{}) {
+	r := c.Request()
+	e := Event{
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   targetId,
+		Ip:         oauth.ClientIP(r),
+		UserAgent:  r.UserAgent(),
+	}
+	setActor(&e, c)
+
+	var err error
+	e.Before, err = encode(before)
+	if err == nil {
+		e.After, err = encode(after)
+	}
+	if err == nil {
+		err = insert(&e)
+	}
+	if err != nil {
+		log.Errorf("Unable to record the audit event %s on %s %s: %s", action, targetType, targetId, err)
+	}
+}
+
+// Criteria of the events to list. The empty fields match every event.
+type Filter struct {
+	ActorId    string
+	Action     string
+	TargetType string
+	TargetId   string
+	Since      time.Time
+	Until      time.Time
+	// Only the events older than this one, to get the next page
+	Before int64
+	Limit  int
+}
+
+// Return the events matching the filter, the most recent first
+func Find(filter Filter) ([]*Event, error) {
+	clauses := make([]string, 0)
+	args := make([]interface{}, 0)
+	where := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		clauses = append(clauses, strings.Replace(clause, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+
+	if filter.ActorId != "" {
+		where("actor_id = ?::varchar", filter.ActorId)
+	}
+	if filter.Action != "" {
+		where("action = ?::varchar", filter.Action)
+	}
+	if filter.TargetType != "" {
+		where("target_type = ?::varchar", filter.TargetType)
+	}
+	if filter.TargetId != "" {
+		where("target_id = ?::varchar", filter.TargetId)
+	}
+	if !filter.Since.IsZero() {
+		where("created_at >= ?::timestamp with time zone", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		where("created_at < ?::timestamp with time zone", filter.Until)
+	}
+	if filter.Before > 0 {
+		where("id < ?::bigint", filter.Before)
+	}
+
+	query := `SELECT id, actor_type, actor_id, impersonator_id,
+		action, target_type, target_id,
+		before, after, ip, user_agent, created_at
+		FROM audit_events`
+	if len(clauses) > 0 {
+		query += " WHERE " + strings.Join(clauses, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args)) + "::integer"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*Event, 0)
+	for rows.Next() {
+		e := Event{}
+		var before, after []byte
+		err = rows.Scan(
+			&e.Id, &e.ActorType, &e.ActorId, &e.ImpersonatorId,
+			&e.Action, &e.TargetType, &e.TargetId,
+			&before, &after, &e.Ip, &e.UserAgent, &e.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if before != nil {
+			raw := json.RawMessage(before)
+			e.Before = &raw
+		}
+		if after != nil {
+			raw := json.RawMessage(after)
+			e.After = &raw
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
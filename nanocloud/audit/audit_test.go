@@ -0,0 +1,86 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package audit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+func TestEncodeNil(t *testing.T) {
+	raw, err := encode(nil)
+	if err != nil || raw != nil {
+		t.Error("a missing state should be stored as NULL")
+	}
+
+	var user *users.User
+	raw, err = encode(user)
+	if err != nil || raw != nil {
+		t.Error("a nil user should be stored as NULL")
+	}
+}
+
+func TestEncodeHidesPasswords(t *testing.T) {
+	user := &users.User{Id: "1", Email: "jane@nanocloud.com", Password: "s3cr3t-P4ss"}
+
+	for _, state := range []interface{}{user, *user} {
+		raw, err := encode(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(*raw), "s3cr3t-P4ss") {
+			t.Errorf("the password has been recorded: %s", *raw)
+		}
+		if !strings.Contains(string(*raw), "jane@nanocloud.com") {
+			t.Errorf("the user hasn't been recorded: %s", *raw)
+		}
+	}
+
+	if user.Password != "s3cr3t-P4ss" {
+		t.Error("the user of the handler shouldn't be modified")
+	}
+}
+
+// The hash computed at insertion must match the one computed from the row
+// read back, whatever the formatting of jsonb and the time zone
+func TestChainFields(t *testing.T) {
+	createdAt := time.Date(2016, 6, 1, 12, 0, 0, 123456000, time.UTC)
+	inserted := Event{Action: "user.delete", TargetType: "user", CreatedAt: createdAt}
+	read := inserted
+	read.CreatedAt = createdAt.In(time.FixedZone("CEST", 2*3600))
+
+	a, err := chainFields(&inserted, []byte(`{"last-name":"Doe","email":"jane@nanocloud.com"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := chainFields(&read, []byte(`{"email": "jane@nanocloud.com", "last-name": "Doe"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(a, "\n") != strings.Join(b, "\n") {
+		t.Errorf("the fields differ:\n%v\n%v", a, b)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package authenticators
+
+import (
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/config"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Name of the config key holding the comma separated list of the
+// authenticators to try, in order, when a user logs in.
+const ConfigKey = "AUTHENTICATORS"
+
+const DefaultChain = "local"
+
+// An Authenticator checks the credentials of a user.
+type Authenticator interface {
+	// Authenticate returns the Nanocloud user matching the credentials.
+	// users.UserNotFound is returned if the authenticator doesn't know the
+	// user and users.InvalidCredentials if the password is wrong.
+	Authenticate(username, password string) (*users.User, error)
+}
+
+var authenticators map[string]Authenticator
+
+func Register(name string, authenticator Authenticator) {
+	if authenticators == nil {
+		authenticators = make(map[string]Authenticator, 0)
+	}
+	authenticators[name] = authenticator
+}
+
+// Return the names of the authenticators set in the config table.
+func Chain() []string {
+	value := config.Get(ConfigKey)[ConfigKey]
+	if value == "" {
+		value = DefaultChain
+	}
+
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func authenticate(chain []string, username, password string) (*users.User, error) {
+	failure := users.UserNotFound
+
+	for _, name := range chain {
+		authenticator, exists := authenticators[name]
+		if !exists {
+			log.Warnf("Unknown authenticator \"%s\"", name)
+			continue
+		}
+
+		user, err := authenticator.Authenticate(username, password)
+		switch err {
+		case nil:
+			return user, nil
+		case users.UserDisabled, users.PasswordExpired:
+			return nil, err
+		case users.InvalidCredentials:
+			failure = err
+		case users.UserNotFound:
+		default:
+			log.Errorf("Authenticator \"%s\" failed: %s", name, err.Error())
+		}
+	}
+	return nil, failure
+}
+
+// Authenticate the user with the first authenticator of the configured chain
+// accepting the credentials.
+func Authenticate(username, password string) (*users.User, error) {
+	return authenticate(Chain(), username, password)
+}
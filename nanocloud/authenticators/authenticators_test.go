@@ -0,0 +1,93 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package authenticators
+
+import (
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+// Accepts a single username with any password
+type fake struct {
+	username string
+	err      error
+}
+
+func (f fake) Authenticate(username, password string) (*users.User, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if username != f.username {
+		return nil, users.UserNotFound
+	}
+	return &users.User{Email: username}, nil
+}
+
+func init() {
+	Register("alice", fake{username: "alice"})
+	Register("bob", fake{username: "bob"})
+	Register("wrong-password", fake{err: users.InvalidCredentials})
+	Register("disabled", fake{err: users.UserDisabled})
+}
+
+func TestChain(t *testing.T) {
+	user, err := authenticate([]string{"alice", "bob"}, "bob", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "bob" {
+		t.Errorf("Expected bob, got %s", user.Email)
+	}
+
+	_, err = authenticate([]string{"alice", "bob"}, "carol", "secret")
+	if err != users.UserNotFound {
+		t.Errorf("Unknown users should get UserNotFound, got %v", err)
+	}
+}
+
+func TestChainInvalidCredentials(t *testing.T) {
+	_, err := authenticate([]string{"wrong-password", "alice"}, "carol", "secret")
+	if err != users.InvalidCredentials {
+		t.Errorf("InvalidCredentials should be returned if no authenticator knows the user, got %v", err)
+	}
+
+	user, err := authenticate([]string{"wrong-password", "alice"}, "alice", "secret")
+	if err != nil || user == nil {
+		t.Errorf("The next authenticators should be tried after InvalidCredentials")
+	}
+}
+
+func TestChainDisabled(t *testing.T) {
+	_, err := authenticate([]string{"disabled", "alice"}, "alice", "secret")
+	if err != users.UserDisabled {
+		t.Errorf("A disabled user should stop the chain, got %v", err)
+	}
+}
+
+func TestChainUnknownAuthenticator(t *testing.T) {
+	user, err := authenticate([]string{"missing", "alice"}, "alice", "secret")
+	if err != nil || user == nil {
+		t.Errorf("Unknown authenticators should be skipped")
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package authenticators
+
+import (
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	log "github.com/Sirupsen/logrus"
+)
+
+/*
+ * Authenticate the users with their Active Directory account. The Nanocloud
+ * user is created on the first login and the AD account is used as its
+ * windows account. Only the users provisioned from the directory can log in
+ * this way.
+ */
+type activeDirectory struct{}
+
+func (a activeDirectory) Authenticate(username, password string) (*users.User, error) {
+	account, err := ldap.Authenticate(username, password)
+	switch err {
+	case nil:
+	case ldap.InvalidCredentials:
+		return nil, users.InvalidCredentials
+	case ldap.UnknownUser:
+		return nil, users.UserNotFound
+	default:
+		return nil, err
+	}
+
+	user, err := users.ProvisionLDAPUser(account.DN, account.Email, account.FirstName, account.LastName)
+	if err == users.NotFromDirectory {
+		// the account is left to the other authenticators
+		log.Warnf("%s can't log in with the directory entry %s: the account isn't provisioned from it", account.Email, account.DN)
+		return nil, users.UserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Activated {
+		return nil, users.UserDisabled
+	}
+
+	err = users.LinkWindowsUser(user.Id, account.Sam, password, account.Domain)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func init() {
+	Register("ldap", activeDirectory{})
+}
@@ -0,0 +1,51 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package authenticators
+
+import (
+	"github.com/Nanocloud/community/nanocloud/models/passwords"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+// Check the password against the hash stored in the users table
+type local struct{}
+
+func (l local) Authenticate(username, password string) (*users.User, error) {
+	user, err := users.GetUserFromEmailPassword(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	expired, err := passwords.Expired(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, users.PasswordExpired
+	}
+	return user, nil
+}
+
+func init() {
+	Register("local", local{})
+}
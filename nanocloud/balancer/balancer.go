@@ -0,0 +1,80 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package balancer
+
+import (
+	"errors"
+
+	"github.com/Nanocloud/community/nanocloud/config"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Name of the config key holding the strategy used to spread sessions over
+// the execution servers.
+const ConfigKey = "LOAD_BALANCER"
+
+const DefaultStrategy = "round-robin"
+
+var NoServerAvailable = errors.New("No execution server available")
+
+// A Balancer chooses which execution server hosts the sessions of a user.
+type Balancer interface {
+	// Pick returns one of the non empty servers list for the specified user.
+	Pick(userId string, servers []string) (string, error)
+}
+
+var balancers map[string]Balancer
+
+func Register(name string, balancer Balancer) {
+	if balancers == nil {
+		balancers = make(map[string]Balancer, 0)
+	}
+	balancers[name] = balancer
+}
+
+// Return the balancer selected in the config table. The default strategy is
+// returned if none is set or if the configured one doesn't exist.
+func Get() Balancer {
+	name := config.Get(ConfigKey)[ConfigKey]
+
+	balancer, exists := balancers[name]
+	if !exists {
+		if name != "" {
+			log.Warnf("Unknown load balancer \"%s\", using %s", name, DefaultStrategy)
+		}
+		balancer = balancers[DefaultStrategy]
+	}
+	return balancer
+}
+
+// Pick the execution server to use for all the connections of a user with the
+// configured strategy.
+func Pick(userId string, servers []string) (string, error) {
+	switch len(servers) {
+	case 0:
+		return "", NoServerAvailable
+	case 1:
+		return servers[0], nil
+	}
+	return Get().Pick(userId, servers)
+}
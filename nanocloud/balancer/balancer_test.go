@@ -0,0 +1,85 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package balancer
+
+import "testing"
+
+var servers = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+
+func TestRoundRobin(t *testing.T) {
+	b := &roundRobin{}
+
+	for i := 0; i < 2*len(servers); i++ {
+		server, err := b.Pick("user", servers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if server != servers[i%len(servers)] {
+			t.Errorf("Pick #%d should return %s, got %s", i, servers[i%len(servers)], server)
+		}
+	}
+}
+
+func TestSticky(t *testing.T) {
+	b := &sticky{}
+
+	first, _ := b.Pick("user", servers)
+	for i := 0; i < 10; i++ {
+		server, _ := b.Pick("user", servers)
+		if server != first {
+			t.Fatalf("A user should always be sent to the same server")
+		}
+	}
+
+	for _, removed := range servers {
+		if removed == first {
+			continue
+		}
+
+		remaining := make([]string, 0)
+		for _, server := range servers {
+			if server != removed {
+				remaining = append(remaining, server)
+			}
+		}
+
+		server, _ := b.Pick("user", remaining)
+		if server != first {
+			t.Errorf("Removing %s should not move a user assigned to %s", removed, first)
+		}
+	}
+}
+
+func TestStickySpread(t *testing.T) {
+	b := &sticky{}
+
+	used := make(map[string]bool)
+	for _, user := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		server, _ := b.Pick(user, servers)
+		used[server] = true
+	}
+
+	if len(used) < 2 {
+		t.Errorf("Users should be spread over several servers")
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package balancer
+
+import (
+	"github.com/Nanocloud/community/nanocloud/models/sessions"
+	log "github.com/Sirupsen/logrus"
+)
+
+type leastSessions struct{}
+
+type sessionCount struct {
+	server string
+	count  int
+	err    error
+}
+
+// Pick the server with the fewest opened sessions. Servers whose plaza cannot
+// be queried are ignored.
+func (b *leastSessions) Pick(userId string, servers []string) (string, error) {
+	results := make(chan sessionCount, len(servers))
+	for _, server := range servers {
+		go func(server string) {
+			count, err := sessions.Count(server)
+			results <- sessionCount{server, count, err}
+		}(server)
+	}
+
+	counts := make(map[string]int, len(servers))
+	for range servers {
+		res := <-results
+		if res.err != nil {
+			log.Errorf("Unable to count the sessions of %s: %s", res.server, res.err)
+			continue
+		}
+		counts[res.server] = res.count
+	}
+
+	best := ""
+	bestCount := -1
+	// iterate over servers rather than counts to get a deterministic result on ties
+	for _, server := range servers {
+		count, ok := counts[server]
+		if ok && (bestCount < 0 || count < bestCount) {
+			best = server
+			bestCount = count
+		}
+	}
+
+	if best == "" {
+		return "", NoServerAvailable
+	}
+	return best, nil
+}
+
+func init() {
+	Register("least-sessions", &leastSessions{})
+}
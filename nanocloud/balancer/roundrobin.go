@@ -0,0 +1,38 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package balancer
+
+import "sync/atomic"
+
+type roundRobin struct {
+	next uint32
+}
+
+func (b *roundRobin) Pick(userId string, servers []string) (string, error) {
+	n := atomic.AddUint32(&b.next, 1) - 1
+	return servers[n%uint32(len(servers))], nil
+}
+
+func init() {
+	Register("round-robin", &roundRobin{})
+}
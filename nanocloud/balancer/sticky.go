@@ -0,0 +1,59 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package balancer
+
+import (
+	"hash/fnv"
+)
+
+// sticky always sends a user to the same server using rendezvous hashing:
+// each server gets a score computed from the user id and the server name, and
+// the highest score wins. Adding or removing a server only moves the users of
+// that server.
+type sticky struct{}
+
+func score(userId, server string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(userId))
+	h.Write([]byte{0})
+	h.Write([]byte(server))
+	return h.Sum64()
+}
+
+func (b *sticky) Pick(userId string, servers []string) (string, error) {
+	best := servers[0]
+	bestScore := score(userId, best)
+
+	for _, server := range servers[1:] {
+		s := score(userId, server)
+		if s > bestScore {
+			best = server
+			bestScore = s
+		}
+	}
+	return best, nil
+}
+
+func init() {
+	Register("sticky", &sticky{})
+}
@@ -0,0 +1,265 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+// Package chain makes tables tamper-evident. Every row inserted in a chained
+// table carries a hash over its content and the hash of the previous row, so
+// that changing or removing a row breaks the chain from this row on. Signed
+// checkpoints of the last hashes, kept outside of the database, reveal the
+// rows removed at the end of a chain.
+package chain
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+)
+
+// Number of rows read at once when a chain is verified
+const pageSize = 1000
+
+// A chained row, with the fields its hash covers
+type Row struct {
+	Seq    int64
+	Hash   string
+	Fields []string
+}
+
+type Table struct {
+	Name string
+	// Column ordering the rows, increasing with the insertions
+	Seq string
+	// Return the rows following the after sequence, in order
+	Rows func(after int64, limit int) ([]Row, error)
+}
+
+var kTables = make(map[string]*Table)
+
+func Register(table *Table) {
+	kTables[table.Name] = table
+}
+
+// The registered tables, sorted by name
+func Tables() []*Table {
+	names := make([]string, 0, len(kTables))
+	for name := range kTables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tables := make([]*Table, len(names))
+	for i, name := range names {
+		tables[i] = kTables[name]
+	}
+	return tables
+}
+
+// Hash of a row whose previous row has the prev hash. The fields are encoded
+// as a JSON array so that they can't be shifted from one to the other.
+func Link(prev string, fields ...string) string {
+	b, _ := json.Marshal(fields)
+
+	h := sha256.New()
+	h.Write([]byte(prev))
+	h.Write([]byte{'\n'})
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Re-encode a JSON document the same way whatever its spacing and key order,
+// for the jsonb columns. NULL is encoded as an empty string.
+func CanonicalJSON(raw []byte) (string, error) {
+	if raw == nil {
+		return "", nil
+	}
+
+	var value interface{}
+	err := json.Unmarshal(raw, &value)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Lock the chain of the table until the end of the transaction and return
+// the hash of its last row. The row inserted in the transaction comes next.
+func (t *Table) Head(tx *sql.Tx) (string, error) {
+	_, err := tx.Exec(
+		`SELECT pg_advisory_xact_lock(hashtext($1::varchar))`,
+		"chain:"+t.Name,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	rows, err := tx.Query(
+		`SELECT hash FROM ` + t.Name + `
+		WHERE hash <> ''
+		ORDER BY ` + t.Seq + ` DESC
+		LIMIT 1`,
+	)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var hash string
+	if rows.Next() {
+		err = rows.Scan(&hash)
+		if err != nil {
+			return "", err
+		}
+	}
+	return hash, rows.Err()
+}
+
+// The last row of the chain
+type Head struct {
+	Table string `json:"table"`
+	Seq   int64  `json:"seq"`
+	Hash  string `json:"hash"`
+}
+
+func (t *Table) last() (*Head, error) {
+	rows, err := db.Query(
+		`SELECT ` + t.Seq + `, hash FROM ` + t.Name + `
+		WHERE hash <> ''
+		ORDER BY ` + t.Seq + ` DESC
+		LIMIT 1`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	head := Head{Table: t.Name}
+	if rows.Next() {
+		err = rows.Scan(&head.Seq, &head.Hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &head, rows.Err()
+}
+
+// Result of the verification of a table
+type Report struct {
+	Table string
+	// Rows inserted before the table was chained
+	Unchained int64
+	// Rows whose hash is valid
+	Verified int64
+	Head     Head
+	// First broken link, nil if the chain is intact
+	Broken *Break
+}
+
+type Break struct {
+	Seq    int64
+	Reason string
+}
+
+func (b *Break) Error() string {
+	return "row " + strconv.FormatInt(b.Seq, 10) + ": " + b.Reason
+}
+
+/*
+ * Walk the chain of the table and report the first broken link. The heads
+ * recorded by the checkpoints must still be in the chain: a checkpoint whose
+ * row has disappeared reveals rows removed at the end of the chain.
+ */
+func (t *Table) Verify(checkpoints []*Checkpoint) (*Report, error) {
+	report := Report{Table: t.Name, Head: Head{Table: t.Name}}
+
+	expected := make(map[int64]string)
+	for _, checkpoint := range checkpoints {
+		for _, head := range checkpoint.Heads {
+			if head.Table == t.Name && head.Seq > 0 {
+				expected[head.Seq] = head.Hash
+			}
+		}
+	}
+
+	prev := ""
+	var after int64
+	for report.Broken == nil {
+		rows, err := t.Rows(after, pageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range rows {
+			after = row.Seq
+
+			if row.Hash == "" {
+				if _, ok := expected[row.Seq]; ok {
+					report.Broken = &Break{row.Seq, "the hash recorded by a checkpoint has been removed"}
+					break
+				}
+				if report.Verified == 0 {
+					report.Unchained++
+					continue
+				}
+				report.Broken = &Break{row.Seq, "the row has no hash, it has been inserted outside of the chain"}
+				break
+			}
+
+			if Link(prev, row.Fields...) != row.Hash {
+				report.Broken = &Break{row.Seq, "the hash doesn't match, the row or the one before has been modified or removed"}
+				break
+			}
+
+			if hash, ok := expected[row.Seq]; ok {
+				if hash != row.Hash {
+					report.Broken = &Break{row.Seq, "the hash differs from the one of the checkpoint, the chain has been rewritten"}
+					break
+				}
+				delete(expected, row.Seq)
+			}
+
+			prev = row.Hash
+			report.Verified++
+			report.Head = Head{t.Name, row.Seq, row.Hash}
+		}
+
+		if len(rows) < pageSize {
+			break
+		}
+	}
+
+	if report.Broken == nil {
+		for seq := range expected {
+			if report.Broken == nil || seq < report.Broken.Seq {
+				report.Broken = &Break{seq, "the row of a checkpoint is missing, rows have been removed"}
+			}
+		}
+	}
+	return &report, nil
+}
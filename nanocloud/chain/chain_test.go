@@ -0,0 +1,237 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package chain
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+// A table held in memory, chained with Link
+func memoryTable(rows []Row) *Table {
+	return &Table{
+		Name: "memory",
+		Seq:  "seq",
+		Rows: func(after int64, limit int) ([]Row, error) {
+			page := make([]Row, 0)
+			for _, row := range rows {
+				if row.Seq > after && len(page) < limit {
+					page = append(page, row)
+				}
+			}
+			return page, nil
+		},
+	}
+}
+
+func chainedRows(n int) []Row {
+	rows := make([]Row, n)
+	prev := ""
+	for i := range rows {
+		fields := []string{"row", string(rune('a' + i%26))}
+		prev = Link(prev, fields...)
+		rows[i] = Row{int64(i + 1), prev, fields}
+	}
+	return rows
+}
+
+func TestLink(t *testing.T) {
+	if Link("", "a", "b") != Link("", "a", "b") {
+		t.Error("the hash should be deterministic")
+	}
+	if Link("", "ab", "") == Link("", "a", "b") {
+		t.Error("moving characters from a field to the next should change the hash")
+	}
+	if Link("x", "a") == Link("y", "a") {
+		t.Error("the previous hash should be covered")
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	a, err := CanonicalJSON([]byte(`{"b": 1, "a": [true, null]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := CanonicalJSON([]byte(`{"a":[true,null],"b":1.0}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Errorf("%s and %s should be equal", a, b)
+	}
+
+	empty, err := CanonicalJSON(nil)
+	if err != nil || empty != "" {
+		t.Error("NULL should be encoded as an empty string")
+	}
+}
+
+func TestVerifyIntact(t *testing.T) {
+	rows := append([]Row{{1, "", []string{"legacy"}}}, chainedRows(2500)...)
+	for i := range rows[1:] {
+		rows[i+1].Seq++
+	}
+
+	report, err := memoryTable(rows).Verify(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Broken != nil {
+		t.Fatalf("unexpected break: %s", report.Broken.Error())
+	}
+	if report.Unchained != 1 || report.Verified != 2500 || report.Head.Seq != 2501 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+func TestVerifyModified(t *testing.T) {
+	rows := chainedRows(10)
+	rows[4].Fields = []string{"row", "tampered"}
+
+	report, err := memoryTable(rows).Verify(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Broken == nil || report.Broken.Seq != 5 {
+		t.Errorf("the break should be reported at row 5: %+v", report.Broken)
+	}
+}
+
+func TestVerifyRemoved(t *testing.T) {
+	rows := chainedRows(10)
+	rows = append(rows[:6], rows[7:]...)
+
+	report, err := memoryTable(rows).Verify(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Broken == nil || report.Broken.Seq != 8 {
+		t.Errorf("the break should be reported at row 8: %+v", report.Broken)
+	}
+
+	rows = chainedRows(10)
+	rows[3].Hash = ""
+
+	report, err = memoryTable(rows).Verify(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Broken == nil || report.Broken.Seq != 4 {
+		t.Errorf("the unchained row should be reported: %+v", report.Broken)
+	}
+}
+
+func TestVerifyTruncated(t *testing.T) {
+	rows := chainedRows(10)
+	checkpoint := &Checkpoint{Heads: []Head{{"memory", 10, rows[9].Hash}}}
+
+	report, err := memoryTable(rows[:8]).Verify([]*Checkpoint{checkpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Broken == nil || report.Broken.Seq != 10 {
+		t.Errorf("the missing rows should be reported: %+v", report.Broken)
+	}
+
+	report, err = memoryTable(rows).Verify([]*Checkpoint{checkpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Broken != nil {
+		t.Errorf("unexpected break: %s", report.Broken.Error())
+	}
+}
+
+func TestVerifyRewritten(t *testing.T) {
+	rows := chainedRows(10)
+	checkpoint := &Checkpoint{Heads: []Head{{"memory", 5, rows[4].Hash}}}
+
+	// the chain is recomputed from a modified row
+	rows[2].Fields = []string{"row", "tampered"}
+	prev := rows[1].Hash
+	for i := 2; i < len(rows); i++ {
+		rows[i].Hash = Link(prev, rows[i].Fields...)
+		prev = rows[i].Hash
+	}
+
+	report, err := memoryTable(rows).Verify([]*Checkpoint{checkpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Broken == nil || report.Broken.Seq != 5 {
+		t.Errorf("the rewritten chain should be reported: %+v", report.Broken)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	_, err := parseKey("")
+	if err != NoSigningKey {
+		t.Errorf("expected NoSigningKey, got %v", err)
+	}
+
+	_, err = parseKey(base64.StdEncoding.EncodeToString([]byte("short")))
+	if err != InvalidKey {
+		t.Errorf("expected InvalidKey, got %v", err)
+	}
+
+	key, err := parseKey(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)) + "\n")
+	if err != nil || len(key) != 32 {
+		t.Errorf("unexpected key: %v %v", key, err)
+	}
+}
+
+func TestCheckpointSignature(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	checkpoint := &Checkpoint{
+		CreatedAt: time.Date(2016, 6, 1, 12, 0, 0, 123456000, time.UTC),
+		Heads:     []Head{{"audit_events", 42, Link("", "a")}},
+	}
+	checkpoint.sign(key)
+
+	var b bytes.Buffer
+	err := WriteCheckpoints(&b, []*Checkpoint{checkpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadCheckpoints(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 1 {
+		t.Fatalf("expected one checkpoint, got %d", len(read))
+	}
+	if read[0].verify(key) != nil {
+		t.Error("the signature should survive the export")
+	}
+
+	read[0].Heads[0].Seq = 41
+	if read[0].verify(key) != InvalidSignature {
+		t.Error("a modified checkpoint should be rejected")
+	}
+
+	if checkpoint.verify(bytes.Repeat([]byte{8}, 32)) != InvalidSignature {
+		t.Error("a checkpoint signed with another key should be rejected")
+	}
+}
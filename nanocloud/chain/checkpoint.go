@@ -0,0 +1,287 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package chain
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	NoSigningKey     = errors.New("no checkpoint signing key configured")
+	InvalidKey       = errors.New("invalid checkpoint signing key")
+	InvalidSignature = errors.New("invalid checkpoint signature")
+)
+
+var (
+	kKey     []byte
+	kKeyErr  error
+	kKeyOnce sync.Once
+)
+
+// Parse a signing key, the base64 encoding of at least 32 random bytes
+func parseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, NoSigningKey
+	}
+
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) < 32 {
+		return nil, InvalidKey
+	}
+	return key, nil
+}
+
+// Load the key signing the checkpoints from CHAIN_SIGNING_KEY or from the
+// file named by CHAIN_SIGNING_KEY_FILE
+func signingKey() ([]byte, error) {
+	kKeyOnce.Do(func() {
+		value := utils.Env("CHAIN_SIGNING_KEY", "")
+
+		path := utils.Env("CHAIN_SIGNING_KEY_FILE", "")
+		if value == "" && path != "" {
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				kKeyErr = err
+				return
+			}
+			value = string(b)
+		}
+
+		kKey, kKeyErr = parseKey(value)
+	})
+	return kKey, kKeyErr
+}
+
+// The heads of the chains at a given time, signed so that they can't be
+// forged by someone able to modify the database
+type Checkpoint struct {
+	CreatedAt time.Time `json:"created-at"`
+	Heads     []Head    `json:"heads"`
+	Signature string    `json:"signature"`
+}
+
+func (c *Checkpoint) payload() []byte {
+	b, _ := json.Marshal(struct {
+		CreatedAt string `json:"created-at"`
+		Heads     []Head `json:"heads"`
+	}{
+		c.CreatedAt.UTC().Format(time.RFC3339Nano),
+		c.Heads,
+	})
+	return b
+}
+
+func sign(key []byte, payload []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *Checkpoint) sign(key []byte) {
+	c.Signature = sign(key, c.payload())
+}
+
+func (c *Checkpoint) verify(key []byte) error {
+	expected := sign(key, c.payload())
+	if !hmac.Equal([]byte(expected), []byte(c.Signature)) {
+		return InvalidSignature
+	}
+	return nil
+}
+
+// Check the signature of the checkpoints with the configured key
+func VerifySignatures(checkpoints []*Checkpoint) error {
+	key, err := signingKey()
+	if err != nil {
+		return err
+	}
+
+	for _, checkpoint := range checkpoints {
+		err = checkpoint.verify(key)
+		if err != nil {
+			return errors.New("checkpoint of " + checkpoint.CreatedAt.Format(time.RFC3339) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+/*
+ * Sign the current heads of the chains and store the checkpoint. It is also
+ * appended to CHAIN_CHECKPOINT_FILE when set, which should be on a storage
+ * the database administrators can't write to.
+ */
+func CreateCheckpoint() (*Checkpoint, error) {
+	key, err := signingKey()
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := Checkpoint{
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+		Heads:     make([]Head, 0),
+	}
+	for _, table := range Tables() {
+		head, err := table.last()
+		if err != nil {
+			return nil, err
+		}
+		checkpoint.Heads = append(checkpoint.Heads, *head)
+	}
+	checkpoint.sign(key)
+
+	content, err := json.Marshal(&checkpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO chain_checkpoints
+		(created_at, content)
+		VALUES ($1::timestamp with time zone, $2::text)`,
+		checkpoint.CreatedAt, string(content),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	path := utils.Env("CHAIN_CHECKPOINT_FILE", "")
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		_, err = f.Write(append(content, '\n'))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &checkpoint, nil
+}
+
+// Return the checkpoints stored in the database, the oldest first
+func FindCheckpoints() ([]*Checkpoint, error) {
+	rows, err := db.Query(
+		`SELECT content FROM chain_checkpoints
+		ORDER BY created_at`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkpoints := make([]*Checkpoint, 0)
+	for rows.Next() {
+		var content string
+		err = rows.Scan(&content)
+		if err != nil {
+			return nil, err
+		}
+
+		checkpoint := Checkpoint{}
+		err = json.Unmarshal([]byte(content), &checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, &checkpoint)
+	}
+	return checkpoints, rows.Err()
+}
+
+// Write the checkpoints, one JSON document per line
+func WriteCheckpoints(w io.Writer, checkpoints []*Checkpoint) error {
+	encoder := json.NewEncoder(w)
+	for _, checkpoint := range checkpoints {
+		err := encoder.Encode(checkpoint)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read the checkpoints written by WriteCheckpoints or CreateCheckpoint
+func ReadCheckpoints(r io.Reader) ([]*Checkpoint, error) {
+	checkpoints := make([]*Checkpoint, 0)
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		checkpoint := Checkpoint{}
+		err := json.Unmarshal([]byte(text), &checkpoint)
+		if err != nil {
+			return nil, errors.New("line " + strconv.Itoa(line) + ": " + err.Error())
+		}
+		checkpoints = append(checkpoints, &checkpoint)
+	}
+	return checkpoints, scanner.Err()
+}
+
+// Create a checkpoint every CHAIN_CHECKPOINT_INTERVAL minutes, if a signing
+// key is configured
+func StartCheckpoints() {
+	minutes, err := strconv.Atoi(utils.Env("CHAIN_CHECKPOINT_INTERVAL", "60"))
+	if err != nil || minutes <= 0 {
+		return
+	}
+
+	_, err = signingKey()
+	if err != nil {
+		log.Warnf("No checkpoint of the audit and history chains: %s", err)
+		return
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Duration(minutes) * time.Minute)
+
+			_, err := CreateCheckpoint()
+			if err != nil {
+				log.Error("Unable to create the chain checkpoint: ", err)
+			}
+		}
+	}()
+}
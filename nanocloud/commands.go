@@ -0,0 +1,148 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Nanocloud/community/nanocloud/chain"
+)
+
+const usage = `usage: nanocloud [command]
+
+Without command, the server is started. The commands are:
+
+  verify-chain [checkpoints file]
+	verify the hash chains of the audit events and of the histories, with
+	the checkpoints stored in the database and the ones of the file
+  checkpoint
+	sign the heads of the chains, store the checkpoint and print it
+  export-checkpoints <file>
+	write the checkpoints stored in the database to the file
+`
+
+// Run the command given on the command line and return the exit status
+func runCommand(args []string) int {
+	var err error
+
+	switch {
+	case args[0] == "verify-chain" && len(args) <= 2:
+		return verifyChain(args[1:])
+
+	case args[0] == "checkpoint" && len(args) == 1:
+		var checkpoint *chain.Checkpoint
+		checkpoint, err = chain.CreateCheckpoint()
+		if err == nil {
+			err = chain.WriteCheckpoints(os.Stdout, []*chain.Checkpoint{checkpoint})
+		}
+
+	case args[0] == "export-checkpoints" && len(args) == 2:
+		err = exportCheckpoints(args[1])
+
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func exportCheckpoints(path string) error {
+	checkpoints, err := chain.FindCheckpoints()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = chain.WriteCheckpoints(f, checkpoints)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Verify every chain and report the first broken link of each. The status is
+// 1 if a chain is broken or a checkpoint is forged.
+func verifyChain(args []string) int {
+	checkpoints, err := chain.FindCheckpoints()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if len(args) == 1 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		external, err := chain.ReadCheckpoints(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", args[0], err)
+			return 1
+		}
+		checkpoints = append(checkpoints, external...)
+	}
+
+	status := 0
+	if len(checkpoints) > 0 {
+		err = chain.VerifySignatures(checkpoints)
+		if err != nil {
+			fmt.Printf("checkpoints: %s\n", err)
+			status = 1
+			// a forged checkpoint can't be trusted to verify the chains
+			checkpoints = nil
+		} else {
+			fmt.Printf("checkpoints: %d valid\n", len(checkpoints))
+		}
+	}
+
+	for _, table := range chain.Tables() {
+		report, err := table.Verify(checkpoints)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", table.Name, err)
+			return 1
+		}
+
+		if report.Broken != nil {
+			fmt.Printf("%s: broken at %s\n", report.Table, report.Broken.Error())
+			status = 1
+			continue
+		}
+		fmt.Printf(
+			"%s: intact, %d rows verified, %d rows before the chain, head %d %s\n",
+			report.Table, report.Verified, report.Unchained, report.Head.Seq, report.Head.Hash,
+		)
+	}
+	return status
+}
@@ -50,6 +50,39 @@ func Machines() ([]vms.Machine, error) {
 	return (*vm).Machines()
 }
 
+// Return the addresses of the machines that are up and can host sessions.
+// Machines whose status cannot be retrieved are skipped.
+func ExecutionServers() ([]string, error) {
+	machines, err := Machines()
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]string, 0)
+	for _, machine := range machines {
+		status, err := machine.Status()
+		if err != nil {
+			log.Errorf("Unable to get the status of machine %s: %s", machine.Id(), err)
+			continue
+		}
+
+		if status != StatusUp {
+			continue
+		}
+
+		ip, err := machine.IP()
+		if err != nil {
+			log.Errorf("Unable to get the address of machine %s: %s", machine.Id(), err)
+			continue
+		}
+
+		if ip != nil {
+			servers = append(servers, ip.String())
+		}
+	}
+	return servers, nil
+}
+
 func Machine(id string) (vms.Machine, error) {
 	return (*vm).Machine(id)
 }
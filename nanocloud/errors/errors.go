@@ -108,4 +108,124 @@ var (
 		http.StatusInternalServerError,
 		"The specified machine type does not exists",
 	}
+
+	AppNotFound = &apiError{
+		0x000014,
+		http.StatusNotFound,
+		"This application doesn't exist.",
+	}
+
+	GroupNotFound = &apiError{
+		0x000015,
+		http.StatusNotFound,
+		"This group doesn't exist.",
+	}
+
+	Conflict = &apiError{
+		0x000016,
+		http.StatusConflict,
+		"The resource already exists.",
+	}
+
+	GrantNotFound = &apiError{
+		0x000017,
+		http.StatusNotFound,
+		"This grant doesn't exist.",
+	}
+
+	InvalidMFACode = &apiError{
+		0x000018,
+		http.StatusBadRequest,
+		"The second factor code is not valid.",
+	}
+
+	MFAEnrollmentRequired = &apiError{
+		0x000019,
+		http.StatusForbidden,
+		"Administrators must enable the two-factor authentication.",
+	}
+
+	LockoutNotFound = &apiError{
+		0x00001A,
+		http.StatusNotFound,
+		"This lockout doesn't exist.",
+	}
+
+	PasswordTooShort = &apiError{
+		0x00001B,
+		http.StatusBadRequest,
+		"The password is too short.",
+	}
+
+	PasswordTooSimple = &apiError{
+		0x00001C,
+		http.StatusBadRequest,
+		"The password is too simple.",
+	}
+
+	PasswordBanned = &apiError{
+		0x00001D,
+		http.StatusBadRequest,
+		"The password contains a banned word.",
+	}
+
+	PasswordReused = &apiError{
+		0x00001E,
+		http.StatusBadRequest,
+		"The password has already been used.",
+	}
+
+	PasswordResetNotFound = &apiError{
+		0x00001F,
+		http.StatusNotFound,
+		"This password reset token is invalid or has expired.",
+	}
+
+	SignupDisabled = &apiError{
+		0x000020,
+		http.StatusForbidden,
+		"Sign-up is disabled.",
+	}
+
+	RegistrationNotFound = &apiError{
+		0x000021,
+		http.StatusNotFound,
+		"This registration doesn't exist.",
+	}
+
+	EmailVerificationNotFound = &apiError{
+		0x000022,
+		http.StatusNotFound,
+		"This verification link is invalid or has expired.",
+	}
+
+	JobNotFound = &apiError{
+		0x000023,
+		http.StatusNotFound,
+		"This job doesn't exist.",
+	}
+
+	ImportNotFound = &apiError{
+		0x000024,
+		http.StatusNotFound,
+		"This import doesn't exist.",
+	}
+
+	RoleNotFound = &apiError{
+		0x000025,
+		http.StatusNotFound,
+		"This role doesn't exist.",
+	}
+
+	RoleBuiltin = &apiError{
+		0x000026,
+		http.StatusForbidden,
+		"Built-in roles can't be modified.",
+	}
+
+	Forbidden = &apiError{
+		0x000027,
+		http.StatusForbidden,
+		"You are not allowed to perform this action.",
+	}
 )
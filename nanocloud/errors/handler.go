@@ -40,6 +40,9 @@ func Handler(err error, c *echo.Context) {
 	case *apiError:
 		e.Send(r)
 
+	case errorList:
+		e.Send(r)
+
 	case error:
 		log.WithFields(log.Fields{
 			"error": e.Error(),
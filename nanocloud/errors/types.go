@@ -26,6 +26,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 const unableToSerializeError = `{
@@ -100,15 +101,73 @@ func (e *detailedError) Error() string {
 	return e.err.Error() + " " + e.detail
 }
 
+func (e *detailedError) entry() hash {
+	return hash{
+		"code":   fmt.Sprintf("%06x", e.err.code),
+		"title":  e.err.title,
+		"detail": e.detail,
+	}
+}
+
 func (e *detailedError) Send(w http.ResponseWriter) {
 	b := hash{
 		"errors": [1]hash{
-			hash{
-				"code":   fmt.Sprintf("%06x", e.err.code),
-				"title":  e.err.title,
-				"detail": e.detail,
-			},
+			e.entry(),
 		},
 	}
 	sendError(w, e.err.status, b)
 }
+
+// Several errors reported at once, the response has the HTTP status of the
+// first one. Only the errors of this package can be listed.
+type errorList []error
+
+func List(errs ...error) errorList {
+	return errorList(errs)
+}
+
+func (l errorList) Error() string {
+	titles := make([]string, len(l))
+	for i, e := range l {
+		titles[i] = e.Error()
+	}
+	return strings.Join(titles, "; ")
+}
+
+func (l errorList) Send(w http.ResponseWriter) {
+	status := 0
+	entries := make([]hash, 0, len(l))
+	for _, err := range l {
+		var e *apiError
+		var entry hash
+
+		switch t := err.(type) {
+		case *apiError:
+			e = t
+			entry = hash{
+				"code":  fmt.Sprintf("%06x", e.code),
+				"title": e.title,
+			}
+		case *detailedError:
+			e = t.err
+			entry = t.entry()
+		default:
+			continue
+		}
+
+		if status == 0 {
+			status = e.status
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) == 0 {
+		InvalidError.Send(w)
+		return
+	}
+
+	b := hash{
+		"errors": entries,
+	}
+	sendError(w, status, b)
+}
@@ -0,0 +1,335 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package jobs runs the operations on external systems that must eventually
+// succeed. A job failing is kept in the jobs table and retried with an
+// exponential backoff until it succeeds or runs out of attempts. The jobs of
+// a queue run in the order they have been created: a job is only run once the
+// pending jobs created before it in its queue have succeeded.
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	Pending = "pending"
+	Failed  = "failed"
+
+	MaxAttempts = 10
+
+	baseDelay = time.Minute
+	maxDelay  = 6 * time.Hour
+
+	// a job claimed by a worker isn't run by the others for this long
+	lease = 5 * time.Minute
+)
+
+var JobNotFound = errors.New("job not found")
+
+// A Handler runs the jobs of a kind, the payload is the JSON the job has been
+// created with.
+type Handler func(payload []byte) error
+
+var handlers map[string]Handler
+
+func Register(kind string, handler Handler) {
+	if handlers == nil {
+		handlers = make(map[string]Handler, 0)
+	}
+	handlers[kind] = handler
+}
+
+type Job struct {
+	Id        string    `json:"-"`
+	Queue     string    `json:"queue"`
+	Kind      string    `json:"kind"`
+	Payload   string    `json:"payload"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last-error"`
+	RunAt     time.Time `json:"run-at"`
+	CreatedAt time.Time `json:"created-at"`
+}
+
+func (j *Job) GetID() string {
+	return j.Id
+}
+
+func (j *Job) SetID(id string) error {
+	j.Id = id
+	return nil
+}
+
+// Delay before the next attempt of a job that failed the specified number of
+// times
+func retryDelay(attempts int) time.Duration {
+	delay := baseDelay
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+func run(kind string, payload []byte) error {
+	handler, exists := handlers[kind]
+	if !exists {
+		return fmt.Errorf("no handler for the jobs \"%s\"", kind)
+	}
+	return handler(payload)
+}
+
+// Run the job right away. If it fails, it is saved to be retried later and
+// only an error saving it is returned.
+func Run(kind string, payload interface{}) error {
+	return RunInQueue("", kind, payload)
+}
+
+// Run the job right away unless the queue has pending jobs, in which case it
+// is saved to run after them. The empty queue isn't ordered.
+func RunInQueue(queue string, kind string, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if queue == "" {
+		return runOrRetry(db.Exec, queue, kind, b)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	waiting, err := hasPendingJobs(tx, queue)
+	if err == nil && waiting {
+		log.Infof("Job \"%s\" queued after the pending jobs of %s", kind, queue)
+		_, err = tx.Exec(
+			`INSERT INTO jobs
+			(queue, kind, payload, attempts, run_at)
+			VALUES ($1::varchar, $2::varchar, $3::varchar, 0, NOW())`,
+			queue, kind, string(b),
+		)
+	} else if err == nil {
+		err = runOrRetry(tx.Exec, queue, kind, b)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Run the job and save it to be retried if it fails
+func runOrRetry(exec func(string, ...interface{}) (sql.Result, error), queue string, kind string, payload []byte) error {
+	runErr := run(kind, payload)
+	if runErr == nil {
+		return nil
+	}
+
+	log.Warnf("Job \"%s\" failed, retrying in %s: %s", kind, retryDelay(1), runErr.Error())
+	_, err := exec(
+		`INSERT INTO jobs
+		(queue, kind, payload, attempts, last_error, run_at)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, 1, $4::varchar, NOW() + $5::integer * interval '1 second')`,
+		queue, kind, string(payload), runErr.Error(), int(retryDelay(1).Seconds()),
+	)
+	return err
+}
+
+// Lock the queue until the end of the transaction, so that its jobs can't
+// overtake each other, and tell whether it has pending jobs.
+func hasPendingJobs(tx *sql.Tx, queue string) (bool, error) {
+	_, err := tx.Exec(
+		`SELECT pg_advisory_xact_lock(hashtext($1::varchar))`,
+		"jobs:"+queue,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := tx.Query(
+		`SELECT id FROM jobs
+		WHERE queue = $1::varchar
+		AND status = $2::varchar
+		LIMIT 1`,
+		queue, Pending,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), rows.Err()
+}
+
+// Claim the next job due and run it. Return false if no job is due.
+func processNext() (bool, error) {
+	rows, err := db.Query(
+		`UPDATE jobs
+		SET run_at = NOW() + $2::integer * interval '1 second'
+		WHERE id = (
+			SELECT id FROM jobs j
+			WHERE status = $1::varchar
+			AND run_at <= NOW()
+			AND (queue = '' OR NOT EXISTS (
+				SELECT 1 FROM jobs previous
+				WHERE previous.queue = j.queue
+				AND previous.status = $1::varchar
+				AND previous.id < j.id
+			))
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, payload, attempts`,
+		Pending, int(lease.Seconds()),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if !rows.Next() {
+		rows.Close()
+		return false, nil
+	}
+
+	var id, attempts int
+	var kind, payload string
+	err = rows.Scan(&id, &kind, &payload, &attempts)
+	rows.Close()
+	if err != nil {
+		return false, err
+	}
+
+	runErr := run(kind, []byte(payload))
+	if runErr == nil {
+		_, err = db.Exec(`DELETE FROM jobs WHERE id = $1::integer`, id)
+		return true, err
+	}
+
+	attempts++
+	status := Pending
+	if attempts >= MaxAttempts {
+		status = Failed
+		log.Errorf("Job \"%s\" %d failed %d times, giving up: %s", kind, id, attempts, runErr.Error())
+	} else {
+		log.Warnf("Job \"%s\" %d failed, retrying in %s: %s", kind, id, retryDelay(attempts), runErr.Error())
+	}
+
+	_, err = db.Exec(
+		`UPDATE jobs
+		SET status = $2::varchar,
+		attempts = $3::integer,
+		last_error = $4::varchar,
+		run_at = NOW() + $5::integer * interval '1 second'
+		WHERE id = $1::integer`,
+		id, status, attempts, runErr.Error(), int(retryDelay(attempts).Seconds()),
+	)
+	return true, err
+}
+
+// Run the jobs due every interval until the program exits
+func Start(interval time.Duration) {
+	go func() {
+		for {
+			for {
+				processed, err := processNext()
+				if err != nil {
+					log.Error("Unable to process the jobs: ", err)
+					break
+				}
+				if !processed {
+					break
+				}
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// Return the jobs waiting to be retried and the ones that ran out of attempts
+func FindAll() ([]*Job, error) {
+	rows, err := db.Query(
+		`SELECT id, queue, kind, payload, status,
+		attempts, last_error, run_at, created_at
+		FROM jobs
+		ORDER BY created_at`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]*Job, 0)
+	for rows.Next() {
+		job := Job{}
+		err = rows.Scan(
+			&job.Id, &job.Queue, &job.Kind, &job.Payload, &job.Status,
+			&job.Attempts, &job.LastError, &job.RunAt, &job.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, &job)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Schedule the job to run as soon as possible with all its attempts back
+func Retry(id string) error {
+	res, err := db.Exec(
+		`UPDATE jobs
+		SET status = $2::varchar,
+		attempts = 0,
+		run_at = NOW()
+		WHERE id::varchar = $1::varchar`,
+		id, Pending,
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return JobNotFound
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{5, 16 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, 6 * time.Hour},
+		{50, 6 * time.Hour},
+	}
+
+	for _, test := range tests {
+		delay := retryDelay(test.attempts)
+		if delay != test.expected {
+			t.Errorf("%d attempts: expected %s, got %s", test.attempts, test.expected, delay)
+		}
+	}
+}
+
+func TestRunRegisteredHandler(t *testing.T) {
+	var received string
+	Register("test", func(payload []byte) error {
+		received = string(payload)
+		return nil
+	})
+
+	err := Run("test", map[string]string{"user_id": "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received != `{"user_id":"42"}` {
+		t.Errorf("unexpected payload %s", received)
+	}
+}
+
+func TestRunUnknownKind(t *testing.T) {
+	Register("failing", func(payload []byte) error {
+		return errors.New("failure")
+	})
+
+	if run("unknown", nil) == nil {
+		t.Error("jobs without handler must fail")
+	}
+	if run("failing", nil) == nil {
+		t.Error("the handler error must be returned")
+	}
+}
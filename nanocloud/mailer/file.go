@@ -0,0 +1,57 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/utils"
+)
+
+// Write every mail to a .eml file instead of sending it, for tests and
+// development setups without an SMTP server.
+type fileMailer struct{}
+
+func (f fileMailer) Send(message *Message) error {
+	dir := utils.Env("MAILER_DIRECTORY", filepath.Join(os.TempDir(), "nanocloud-mails"))
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	msg, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), utils.RandomString(8))
+	return ioutil.WriteFile(filepath.Join(dir, name), msg, 0600)
+}
+
+func init() {
+	Register("file", fileMailer{})
+}
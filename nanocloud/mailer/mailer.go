@@ -0,0 +1,106 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+const DefaultMailer = "smtp"
+
+var InvalidHeader = errors.New("invalid mail header")
+
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// A Mailer delivers the mails sent by Nanocloud to the users.
+type Mailer interface {
+	Send(message *Message) error
+}
+
+var mailers map[string]Mailer
+
+func Register(name string, mailer Mailer) {
+	if mailers == nil {
+		mailers = make(map[string]Mailer, 0)
+	}
+	mailers[name] = mailer
+}
+
+// Return the mailer selected with the MAILER environment variable. The
+// default mailer is returned if the configured one doesn't exist.
+func Get() Mailer {
+	name := utils.Env("MAILER", DefaultMailer)
+
+	mailer, exists := mailers[name]
+	if !exists {
+		log.Warnf("Unknown mailer \"%s\", using %s", name, DefaultMailer)
+		mailer = mailers[DefaultMailer]
+	}
+	return mailer
+}
+
+// Format the message as specified by RFC 5322
+func (m *Message) Bytes() ([]byte, error) {
+	for _, header := range []string{m.From, m.To, m.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, InvalidHeader
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+
+	lines := strings.Split(strings.Replace(m.Body, "\r\n", "\n", -1), "\n")
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+	return b.Bytes(), nil
+}
+
+// Send the message with the configured mailer, from MAIL_FROM if the
+// message doesn't set a sender.
+func Send(message *Message) error {
+	if message.From == "" {
+		message.From = utils.Env("MAIL_FROM", "nanocloud@localhost")
+	}
+	return Get().Send(message)
+}
@@ -0,0 +1,108 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mailer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBytes(t *testing.T) {
+	message := Message{
+		From:    "nanocloud@example.com",
+		To:      "user@example.com",
+		Subject: "Hello",
+		Body:    "first line\nsecond line",
+	}
+
+	b, err := message.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := string(b)
+	for _, expected := range []string{
+		"From: nanocloud@example.com\r\n",
+		"To: user@example.com\r\n",
+		"Subject: Hello\r\n",
+		"\r\n\r\nfirst line\r\nsecond line\r\n",
+	} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("%q not found in %q", expected, msg)
+		}
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	message := Message{
+		From:    "nanocloud@example.com",
+		To:      "user@example.com\r\nBcc: victim@example.com",
+		Subject: "Hello",
+	}
+
+	_, err := message.Bytes()
+	if err != InvalidHeader {
+		t.Errorf("expected InvalidHeader, got %v", err)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("MAILER", "file")
+	os.Setenv("MAILER_DIRECTORY", dir)
+	defer os.Unsetenv("MAILER")
+	defer os.Unsetenv("MAILER_DIRECTORY")
+
+	err = Send(&Message{
+		To:      "user@example.com",
+		Subject: "Hello",
+		Body:    "Hello world",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 mail, found %d", len(files))
+	}
+
+	b, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "From: nanocloud@localhost\r\n") {
+		t.Errorf("MAIL_FROM default not used: %q", string(b))
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mailer
+
+import (
+	"net"
+	"net/smtp"
+
+	"github.com/Nanocloud/community/nanocloud/utils"
+)
+
+// Deliver the mails to an SMTP relay, with STARTTLS if the server supports
+// it.
+type smtpMailer struct{}
+
+func (s smtpMailer) Send(message *Message) error {
+	host := utils.Env("SMTP_HOST", "localhost")
+	addr := net.JoinHostPort(host, utils.Env("SMTP_PORT", "25"))
+
+	var auth smtp.Auth
+	username := utils.Env("SMTP_USERNAME", "")
+	if username != "" {
+		auth = smtp.PlainAuth("", username, utils.Env("SMTP_PASSWORD", ""), host)
+	}
+
+	msg, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(addr, auth, message.From, []string{message.To}, msg)
+}
+
+func init() {
+	Register("smtp", smtpMailer{})
+}
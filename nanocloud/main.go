@@ -25,19 +25,32 @@ package main
 import (
 	"errors"
 	"os"
+	"time"
 
+	"github.com/Nanocloud/community/nanocloud/chain"
 	vmsConn "github.com/Nanocloud/community/nanocloud/connectors/vms"
 	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	jobsQueue "github.com/Nanocloud/community/nanocloud/jobs"
 	m "github.com/Nanocloud/community/nanocloud/middlewares"
 	"github.com/Nanocloud/community/nanocloud/migration"
+	"github.com/Nanocloud/community/nanocloud/models/imports"
 	_ "github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/provisioning"
+	"github.com/Nanocloud/community/nanocloud/routes/admin"
 	"github.com/Nanocloud/community/nanocloud/routes/apps"
+	"github.com/Nanocloud/community/nanocloud/routes/audit"
 	"github.com/Nanocloud/community/nanocloud/routes/files"
 	"github.com/Nanocloud/community/nanocloud/routes/front"
+	"github.com/Nanocloud/community/nanocloud/routes/groups"
 	"github.com/Nanocloud/community/nanocloud/routes/histories"
+	"github.com/Nanocloud/community/nanocloud/routes/jobs"
+	"github.com/Nanocloud/community/nanocloud/routes/lockouts"
 	"github.com/Nanocloud/community/nanocloud/routes/machine-drivers"
 	"github.com/Nanocloud/community/nanocloud/routes/machines"
 	"github.com/Nanocloud/community/nanocloud/routes/oauth"
+	"github.com/Nanocloud/community/nanocloud/routes/roles"
+	"github.com/Nanocloud/community/nanocloud/routes/saml"
+	"github.com/Nanocloud/community/nanocloud/routes/scim"
 	"github.com/Nanocloud/community/nanocloud/routes/sessions"
 	"github.com/Nanocloud/community/nanocloud/routes/tokens"
 	"github.com/Nanocloud/community/nanocloud/routes/upload"
@@ -84,11 +97,25 @@ func initVms() error {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	err := migration.Migrate()
 	if err != nil {
 		log.Error(err)
 		return
 	}
+
+	err = imports.Interrupt()
+	if err != nil {
+		log.Error(err)
+	}
+
+	jobsQueue.Start(30 * time.Second)
+	provisioning.StartReconciler()
+	provisioning.StartPasswordRotation(time.Minute)
+	chain.StartCheckpoints()
 	p := echo.New()
 	go p.Run(":8181")
 
@@ -109,17 +136,21 @@ func main() {
 	 * APPS
 	 */
 	e.Get("/api/apps", m.OAuth2(apps.ListApplications))
-	e.Delete("/api/apps/:app_id", m.OAuth2(m.Admin(apps.UnpublishApplication)))
-	e.Post("/api/apps", m.OAuth2(m.Admin(apps.PublishApplication)))
+	e.Delete("/api/apps/:app_id", m.Permission("apps:write", apps.UnpublishApplication))
+	e.Post("/api/apps", m.Permission("apps:write", apps.PublishApplication))
 	e.Get("/api/apps/connections", m.OAuth2(apps.GetConnections))
-	e.Patch("/api/apps/:app_id", m.OAuth2(m.Admin(apps.ChangeAppName)))
+	e.Patch("/api/apps/:app_id", m.Permission("apps:write", apps.ChangeAppName))
+	e.Get("/api/apps/:app_id/grants", m.Permission("apps:read", apps.ListGrants))
+	e.Post("/api/apps/:app_id/grants", m.Permission("apps:write", apps.AddGrant))
+	e.Delete("/api/apps/:app_id/grants/:grant_id", m.Permission("apps:write", apps.RemoveGrant))
 
 	/**
 	 * SESSIONS
 	 */
 
 	e.Get("/api/sessions", m.OAuth2(sessions.List))
-	e.Delete("/api/sessions", m.OAuth2(sessions.Logoff))
+	e.Delete("/api/sessions", m.OAuth2(m.NotImpersonated(sessions.Logoff)))
+	e.Delete("/api/users/:id/sessions", m.Permission("sessions:logoff", sessions.LogoffUser))
 
 	/**
 	 * HISTORY
@@ -131,26 +162,96 @@ func main() {
 	/**
 	 * USERS
 	 */
-	e.Patch("/api/users/:id", m.OAuth2(users.Update))
-	e.Get("/api/users", m.OAuth2(users.Get))
-	e.Post("/api/users", m.OAuth2(m.Admin(users.Post)))
-	e.Delete("/api/users/:id", m.OAuth2(m.Admin(users.Delete)))
-	e.Put("/api/users/:id", m.OAuth2(m.Admin(users.UpdatePassword)))
-	e.Get("/api/users/:id", m.OAuth2(users.GetUser))
+	e.Patch("/api/users/:id", m.OAuth2(m.NotImpersonated(users.Update)))
+	e.Get("/api/users", m.Scope("users:read", users.Get))
+	e.Post("/api/users", m.Permission("users:write", users.Post))
+	e.Post("/api/users/import", m.Permission("users:write", users.Import))
+	e.Get("/api/users/import", m.Permission("users:read", users.ListImports))
+	e.Get("/api/users/import/:id", m.Permission("users:read", users.GetImport))
+	e.Delete("/api/users/:id", m.Permission("users:write", users.Delete))
+	e.Put("/api/users/:id", m.Permission("passwords:reset", users.UpdatePassword))
+	e.Get("/api/users/:id", m.Scope("users:read", users.GetUser))
+	e.Post("/api/users/:id/impersonate", m.Administrator(users.Impersonate))
+	e.Get("/api/impersonations", m.Permission("audit:read", users.ListImpersonations))
+	e.Get("/api/users/:id/mfa", m.OAuth2(users.GetMFA))
+	e.Post("/api/users/:id/mfa", m.OAuth2(m.NotImpersonated(users.EnrollMFA)))
+	e.Delete("/api/users/:id/mfa", m.OAuth2(m.NotImpersonated(users.DisableMFA)))
+	e.Post("/api/users/:id/mfa/activate", m.OAuth2(m.NotImpersonated(users.ActivateMFA)))
+	e.Post("/api/users/:id/mfa/recovery-codes", m.OAuth2(m.NotImpersonated(users.RegenerateRecoveryCodes)))
+	e.Get("/api/mfa/policy", m.Permission("policies:read", users.GetMFAPolicy))
+	e.Patch("/api/mfa/policy", m.Permission("policies:write", users.UpdateMFAPolicy))
+	e.Get("/api/passwords/policy", m.Permission("policies:read", users.GetPasswordPolicy))
+	e.Patch("/api/passwords/policy", m.Permission("policies:write", users.UpdatePasswordPolicy))
+	e.Post("/api/password-resets", users.CreatePasswordReset)
+	e.Patch("/api/password-resets/:id", users.ResetPassword)
+
+	/**
+	 * REGISTRATIONS
+	 */
+	e.Post("/api/registrations", users.Register)
+	e.Patch("/api/email-verifications/:id", users.VerifyEmail)
+	e.Get("/api/registrations", m.Permission("users:read", users.ListRegistrations))
+	e.Patch("/api/registrations/:id", m.Permission("users:write", users.ApproveRegistration))
+	e.Delete("/api/registrations/:id", m.Permission("users:write", users.RejectRegistration))
+	e.Get("/api/registrations/policy", m.Permission("policies:read", users.GetRegistrationPolicy))
+	e.Patch("/api/registrations/policy", m.Permission("policies:write", users.UpdateRegistrationPolicy))
+
+	/**
+	 * LOCKOUTS
+	 */
+	e.Get("/api/lockouts", m.Permission("users:read", lockouts.Get))
+	e.Delete("/api/lockouts/:id", m.Permission("users:write", lockouts.Delete))
+
+	/**
+	 * JOBS
+	 */
+	e.Get("/api/jobs", m.Permission("jobs:read", jobs.Get))
+	e.Patch("/api/jobs/:id", m.Permission("jobs:write", jobs.Retry))
+	e.Post("/api/admin/reconcile", m.Permission("jobs:write", admin.Reconcile))
+
+	/**
+	 * GROUPS
+	 */
+	e.Get("/api/groups", m.Permission("groups:read", groups.List))
+	e.Post("/api/groups", m.Permission("groups:write", groups.Post))
+	e.Get("/api/groups/:id", m.Permission("groups:read", groups.Get))
+	e.Patch("/api/groups/:id", m.Permission("groups:write", groups.Patch))
+	e.Delete("/api/groups/:id", m.Permission("groups:write", groups.Delete))
+	e.Get("/api/groups/:id/members", m.Permission("groups:read", groups.ListMembers))
+	e.Post("/api/groups/:id/members", m.Permission("groups:write", groups.AddMember))
+	e.Delete("/api/groups/:id/members/:user_id", m.Permission("groups:write", groups.RemoveMember))
+
+	/**
+	 * AUDIT
+	 */
+	e.Get("/api/audit", m.Permission("audit:read", audit.List))
+
+	/**
+	 * ROLES
+	 */
+	e.Get("/api/permissions", m.Permission("roles:read", roles.ListPermissions))
+	e.Get("/api/roles", m.Permission("roles:read", roles.List))
+	e.Post("/api/roles", m.Permission("roles:write", roles.Post))
+	e.Get("/api/roles/:id", m.Permission("roles:read", roles.Get))
+	e.Patch("/api/roles/:id", m.Permission("roles:write", roles.Patch))
+	e.Delete("/api/roles/:id", m.Permission("roles:write", roles.Delete))
+	e.Get("/api/users/:id/roles", m.Permission("roles:read", roles.ListUserRoles))
+	e.Put("/api/users/:id/roles/:role_id", m.Permission("roles:write", roles.Assign))
+	e.Delete("/api/users/:id/roles/:role_id", m.Permission("roles:write", roles.Unassign))
 
 	/**
 	 * MACHINES
 	 */
-	e.Get("/api/machines", m.OAuth2(m.Admin(machines.Machines)))
-	e.Get("/api/machines/:id", m.OAuth2(m.Admin(machines.GetMachine)))
-	e.Patch("/api/machines/:id", m.OAuth2(m.Admin(machines.PatchMachine)))
-	e.Post("/api/machines", m.OAuth2(m.Admin(machines.CreateMachine)))
-	e.Delete("/api/machines/:id", m.OAuth2(m.Admin(machines.DeleteMachine)))
+	e.Get("/api/machines", m.Permission("machines:read", machines.Machines))
+	e.Get("/api/machines/:id", m.Permission("machines:read", machines.GetMachine))
+	e.Patch("/api/machines/:id", m.Permission("machines:write", machines.PatchMachine))
+	e.Post("/api/machines", m.Permission("machines:write", machines.CreateMachine))
+	e.Delete("/api/machines/:id", m.Permission("machines:write", machines.DeleteMachine))
 
 	/**
 	 * MACHINES DRIVERS
 	 */
-	e.Get("/api/machine-drivers", m.OAuth2(m.Admin(machinedrivers.FindAll)))
+	e.Get("/api/machine-drivers", m.Permission("machines:read", machinedrivers.FindAll))
 
 	/**
 	 * Files
@@ -168,11 +269,34 @@ func main() {
 	 */
 	e.Any("/oauth/*", oauth.Handler)
 
+	/**
+	 * SAML
+	 */
+	e.Get("/saml/metadata", saml.Metadata)
+	e.Post("/saml/acs", saml.ACS)
+
+	/**
+	 * SCIM
+	 */
+	e.Get("/scim/v2/ServiceProviderConfig", m.ServiceAccount("scim", scim.ServiceProviderConfig))
+	e.Get("/scim/v2/Users", m.ServiceAccount("scim", scim.ListUsers))
+	e.Post("/scim/v2/Users", m.ServiceAccount("scim", scim.CreateUser))
+	e.Get("/scim/v2/Users/:id", m.ServiceAccount("scim", scim.GetUser))
+	e.Put("/scim/v2/Users/:id", m.ServiceAccount("scim", scim.ReplaceUser))
+	e.Patch("/scim/v2/Users/:id", m.ServiceAccount("scim", scim.PatchUser))
+	e.Delete("/scim/v2/Users/:id", m.ServiceAccount("scim", scim.DeleteUser))
+	e.Get("/scim/v2/Groups", m.ServiceAccount("scim", scim.ListGroups))
+	e.Post("/scim/v2/Groups", m.ServiceAccount("scim", scim.CreateGroup))
+	e.Get("/scim/v2/Groups/:id", m.ServiceAccount("scim", scim.GetGroup))
+	e.Put("/scim/v2/Groups/:id", m.ServiceAccount("scim", scim.ReplaceGroup))
+	e.Patch("/scim/v2/Groups/:id", m.ServiceAccount("scim", scim.PatchGroup))
+	e.Delete("/scim/v2/Groups/:id", m.ServiceAccount("scim", scim.DeleteGroup))
+
 	/**
 	 * TOKENS
 	 */
 	e.Get("/api/tokens", m.OAuth2(tokens.Get))
-	e.Delete("/api/tokens/:id", m.OAuth2(tokens.Delete))
+	e.Delete("/api/tokens/:id", m.OAuth2(m.NotImpersonated(tokens.Delete)))
 
 	/**
 	 * UPLOAD
@@ -0,0 +1,79 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package middlewares
+
+import (
+	"net/http"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/impersonations"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+// Run the handler as the impersonated user and record the request in the
+// impersonation log
+func impersonate(c *echo.Context, identity *oauth.Impersonation, handler echo.HandlerFunc) error {
+	c.Set("user", identity.User)
+	c.Set("impersonator", identity.ImpersonatorId)
+
+	err := handler(c)
+	if err != nil {
+		// the error is sent now to log the status of the response
+		apiErrors.Handler(err, c)
+	}
+
+	r := c.Request()
+	fail := impersonations.Record(&impersonations.Entry{
+		ImpersonatorId: identity.ImpersonatorId,
+		UserId:         identity.User.Id,
+		Method:         r.Method,
+		Path:           r.URL.Path,
+		Status:         c.Response().Status(),
+		Ip:             oauth.ClientIP(r),
+	})
+	if fail != nil {
+		log.Errorf("Unable to record the request of %s impersonating %s: %s", identity.ImpersonatorId, identity.User.Id, fail)
+	}
+	return nil
+}
+
+func impersonated(c *echo.Context) bool {
+	return c.Get("impersonator") != nil
+}
+
+/*
+ * NotImpersonated protects the routes that an administrator impersonating a
+ * user mustn't reach, like the password and second factor changes. It goes
+ * inside OAuth2 or Scope.
+ */
+func NotImpersonated(handler echo.HandlerFunc) echo.HandlerFunc {
+	return func(c *echo.Context) error {
+		if impersonated(c) {
+			return c.JSON(http.StatusForbidden, hash{
+				"error": "not allowed while impersonating a user",
+			})
+		}
+		return handler(c)
+	}
+}
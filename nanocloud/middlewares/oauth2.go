@@ -24,29 +24,47 @@ package middlewares
 
 import (
 	"errors"
+	"net/http"
 
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/oauth2"
 	"github.com/labstack/echo"
 )
 
+func oauthErrorReply(w http.ResponseWriter, err *oauth2.OAuthError) error {
+	b, fail := err.ToJSON()
+	if fail != nil {
+		return fail
+	}
+
+	w.WriteHeader(err.HTTPStatusCode)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+	return nil
+}
+
 func oAuth2(c *echo.Context, handler echo.HandlerFunc) error {
 	r := c.Request()
 	w := c.Response()
 
 	user, err := oauth2.GetUser(w, r)
 	if err != nil {
-		b, fail := err.ToJSON()
-		if fail != nil {
-			return fail
-		}
+		return oauthErrorReply(w, err)
+	}
 
-		w.WriteHeader(err.HTTPStatusCode)
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(b)
-		return nil
+	if identity, ok := user.(*oauth.Impersonation); ok {
+		return impersonate(c, identity, handler)
 	}
 
 	if user != nil {
+		// service accounts can only reach the routes protected by Scope
+		if _, ok := user.(*users.User); !ok {
+			return c.JSON(http.StatusForbidden, hash{
+				"error": "forbidden",
+			})
+		}
+
 		c.Set("user", user)
 		return handler(c)
 	}
@@ -0,0 +1,123 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2015 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package middlewares
+
+import (
+	"net/http"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/mfa"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+func authorize(c *echo.Context, permission string, handler echo.HandlerFunc) error {
+	// the scope of a service account has already been checked by requireScope
+	if _, ok := c.Get("service-account").(*oauth.ServiceAccount); ok {
+		return handler(c)
+	}
+
+	// the administration isn't reachable while impersonating a user
+	if impersonated(c) {
+		return c.JSON(http.StatusForbidden, hash{
+			"error": "not allowed while impersonating a user",
+		})
+	}
+
+	user := c.Get("user").(*users.User)
+
+	allowed, err := roles.Can(user, permission)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return c.JSON(http.StatusForbidden, hash{
+			"error": "forbidden",
+		})
+	}
+
+	err = checkMFA(user)
+	if err != nil {
+		return err
+	}
+	return handler(c)
+}
+
+// The users administering Nanocloud without a second factor keep access to
+// their own account but not to the administration
+func checkMFA(user *users.User) error {
+	if !mfa.GetPolicy().RequiredForAdmins {
+		return nil
+	}
+
+	enabled, err := mfa.IsEnabled(user.Id)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return apiErrors.MFAEnrollmentRequired
+	}
+	return nil
+}
+
+/*
+ * Permission authenticates the request like Scope and checks that the user
+ * holds the permission, as an administrator or through one of their roles.
+ * Service accounts must hold the scope of the same name.
+ */
+func Permission(permission string, handler echo.HandlerFunc) echo.HandlerFunc {
+	return func(c *echo.Context) error {
+		return requireScope(c, permission, func(c *echo.Context) error {
+			return authorize(c, permission, handler)
+		})
+	}
+}
+
+/*
+ * Administrator only lets the administrators through, for the actions no role
+ * can grant.
+ */
+func Administrator(handler echo.HandlerFunc) echo.HandlerFunc {
+	return OAuth2(func(c *echo.Context) error {
+		if impersonated(c) {
+			return c.JSON(http.StatusForbidden, hash{
+				"error": "not allowed while impersonating a user",
+			})
+		}
+
+		user := c.Get("user").(*users.User)
+		if !user.IsAdmin {
+			return apiErrors.AdminLevelRequired
+		}
+
+		err := checkMFA(user)
+		if err != nil {
+			return err
+		}
+		return handler(c)
+	})
+}
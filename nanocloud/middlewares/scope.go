@@ -0,0 +1,92 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/oauth2"
+	"github.com/labstack/echo"
+)
+
+func requireScope(c *echo.Context, scope string, handler echo.HandlerFunc) error {
+	r := c.Request()
+	w := c.Response()
+
+	identity, err := oauth2.GetUser(w, r)
+	if err != nil {
+		return oauthErrorReply(w, err)
+	}
+
+	switch identity := identity.(type) {
+	case *users.User:
+		c.Set("user", identity)
+		return handler(c)
+
+	case *oauth.Impersonation:
+		return impersonate(c, identity, handler)
+
+	case *oauth.ServiceAccount:
+		if !identity.HasScope(scope) {
+			return c.JSON(http.StatusForbidden, hash{
+				"error": "insufficient scope",
+			})
+		}
+
+		c.Set("service-account", identity)
+		return handler(c)
+	}
+	return errors.New("unable to authenticate the user")
+}
+
+/*
+ * Scope authenticates the request like OAuth2 but also accepts the tokens of
+ * service accounts (client_credentials grant) holding the given scope.
+ * Users aren't subject to scopes, their rights are still checked by the
+ * handler or by Permission.
+ */
+func Scope(scope string, handler echo.HandlerFunc) echo.HandlerFunc {
+	return func(c *echo.Context) error {
+		return requireScope(c, scope, handler)
+	}
+}
+
+/*
+ * ServiceAccount only accepts the tokens of service accounts holding the
+ * given scope, for the APIs meant for other systems.
+ */
+func ServiceAccount(scope string, handler echo.HandlerFunc) echo.HandlerFunc {
+	return func(c *echo.Context) error {
+		return requireScope(c, scope, func(c *echo.Context) error {
+			if c.Get("service-account") == nil {
+				return c.JSON(http.StatusForbidden, hash{
+					"error": "a client credentials token is required",
+				})
+			}
+			return handler(c)
+		})
+	}
+}
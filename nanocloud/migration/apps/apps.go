@@ -28,7 +28,7 @@ import (
 	uuid "github.com/satori/go.uuid"
 )
 
-func Migrate() error {
+func createAppsTable() error {
 	rows, err := db.Query(
 		`SELECT table_name
 		FROM information_schema.tables
@@ -72,3 +72,57 @@ func Migrate() error {
 	rows.Close()
 	return nil
 }
+
+// app_grants entitles either a single user or a whole group to an application.
+// Exactly one of user_id and group_id is set on each row.
+func createAppGrantsTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'app_grants'`)
+	if err != nil {
+		log.Error("Select tables names failed: ", err.Error())
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("app_grants table already set up")
+		return nil
+	}
+	rows, err = db.Query(
+		`CREATE TABLE app_grants (
+			id       varchar(36) PRIMARY KEY,
+			app_id   varchar(36) NOT NULL
+			REFERENCES apps(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			user_id  varchar(36)
+			REFERENCES users(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			group_id varchar(36)
+			REFERENCES groups(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			UNIQUE (app_id, user_id),
+			UNIQUE (app_id, group_id),
+			CHECK ((user_id IS NULL) <> (group_id IS NULL))
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create app_grants table: %s", err)
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
+
+func Migrate() error {
+	err := createAppsTable()
+	if err != nil {
+		return err
+	}
+
+	return createAppGrantsTable()
+}
@@ -0,0 +1,150 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package audit
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
+	log "github.com/Sirupsen/logrus"
+)
+
+func createAuditEventsTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'audit_events'`)
+	if err != nil {
+		log.Error("Select tables names failed: ", err.Error())
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("audit_events table already set up")
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE audit_events (
+			id               bigserial PRIMARY KEY,
+			actor_type       varchar(32) NOT NULL,
+			actor_id         varchar(36) NOT NULL DEFAULT '',
+			impersonator_id  varchar(36) NOT NULL DEFAULT '',
+			action           varchar(64) NOT NULL,
+			target_type      varchar(32) NOT NULL,
+			target_id        varchar(255) NOT NULL DEFAULT '',
+			before           jsonb,
+			after            jsonb,
+			ip               varchar(255) NOT NULL DEFAULT '',
+			user_agent       text NOT NULL DEFAULT '',
+			created_at       timestamp with time zone NOT NULL DEFAULT current_timestamp
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create audit_events table: %s", err)
+		return err
+	}
+	rows.Close()
+
+	// the events can only be appended, the database refuses to change or
+	// remove them
+	_, err = db.Exec(
+		`CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_events is append-only';
+		END;
+		$$ LANGUAGE plpgsql;`)
+	if err != nil {
+		log.Errorf("Unable to create the audit_events trigger function: %s", err)
+		return err
+	}
+
+	_, err = db.Exec(
+		`CREATE TRIGGER audit_events_no_change
+		BEFORE UPDATE OR DELETE ON audit_events
+		FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only();`)
+	if err != nil {
+		log.Errorf("Unable to create the audit_events trigger: %s", err)
+		return err
+	}
+
+	_, err = db.Exec(
+		`CREATE TRIGGER audit_events_no_truncate
+		BEFORE TRUNCATE ON audit_events
+		FOR EACH STATEMENT EXECUTE PROCEDURE audit_events_append_only();`)
+	if err != nil {
+		log.Errorf("Unable to create the audit_events trigger: %s", err)
+		return err
+	}
+
+	_, err = db.Exec(
+		`CREATE INDEX audit_events_target
+		ON audit_events (target_type, target_id);`)
+	if err != nil {
+		log.Errorf("Unable to index audit_events: %s", err)
+		return err
+	}
+	return nil
+}
+
+// Signed heads of the chained tables, see the chain package
+func createCheckpointsTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'chain_checkpoints'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE chain_checkpoints (
+			id          serial PRIMARY KEY,
+			created_at  timestamp with time zone NOT NULL,
+			content     text NOT NULL
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create chain_checkpoints table: %s", err)
+		return err
+	}
+	rows.Close()
+	return nil
+}
+
+func Migrate() error {
+	err := createAuditEventsTable()
+	if err != nil {
+		return err
+	}
+
+	// hash chaining the events in the order of their id
+	err = schema.AddColumn("audit_events", "hash", "varchar(64) NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	return createCheckpointsTable()
+}
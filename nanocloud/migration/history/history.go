@@ -24,10 +24,11 @@ package history
 
 import (
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
 	log "github.com/Sirupsen/logrus"
 )
 
-func Migrate() error {
+func createHistoriesTable() error {
 	rows, err := db.Query(
 		`SELECT table_name
 		FROM information_schema.tables
@@ -62,3 +63,25 @@ func Migrate() error {
 	rows.Close()
 	return nil
 }
+
+func Migrate() error {
+	err := createHistoriesTable()
+	if err != nil {
+		return err
+	}
+
+	// the rows are chained in the order of seq, each hash covering the row
+	// and the hash of the previous one
+	err = schema.AddColumn("histories", "seq", "bigserial")
+	if err != nil {
+		return err
+	}
+
+	err = schema.AddColumn("histories", "hash", "varchar(64) NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS histories_seq ON histories (seq)`)
+	return err
+}
@@ -0,0 +1,78 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package jobs
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
+	log "github.com/Sirupsen/logrus"
+)
+
+func Migrate() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'jobs'`)
+	if err != nil {
+		log.Error("Select tables names failed: ", err.Error())
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("jobs table already set up")
+	} else {
+		err = createJobsTable()
+		if err != nil {
+			return err
+		}
+	}
+
+	// the jobs of a queue run in order, the jobs without queue in any order
+	err = schema.AddColumn("jobs", "queue", "varchar(255) NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func createJobsTable() error {
+	rows, err := db.Query(
+		`CREATE TABLE jobs (
+			id          serial PRIMARY KEY,
+			kind        varchar(255) NOT NULL,
+			payload     text NOT NULL DEFAULT '',
+			status      varchar(16) NOT NULL DEFAULT 'pending',
+			attempts    integer NOT NULL DEFAULT 0,
+			last_error  text NOT NULL DEFAULT '',
+			run_at      timestamp with time zone NOT NULL DEFAULT current_timestamp,
+			created_at  timestamp with time zone NOT NULL DEFAULT current_timestamp
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create jobs table: %s", err)
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
@@ -24,10 +24,13 @@ package migration
 
 import (
 	"github.com/Nanocloud/community/nanocloud/migration/apps"
+	"github.com/Nanocloud/community/nanocloud/migration/audit"
 	"github.com/Nanocloud/community/nanocloud/migration/config"
 	"github.com/Nanocloud/community/nanocloud/migration/history"
+	"github.com/Nanocloud/community/nanocloud/migration/jobs"
 	"github.com/Nanocloud/community/nanocloud/migration/machines"
 	"github.com/Nanocloud/community/nanocloud/migration/oauth"
+	"github.com/Nanocloud/community/nanocloud/migration/secrets"
 	"github.com/Nanocloud/community/nanocloud/migration/users"
 
 	log "github.com/Sirupsen/logrus"
@@ -70,5 +73,23 @@ func Migrate() error {
 		return err
 	}
 
+	err = jobs.Migrate()
+	if err != nil {
+		log.Error("jobs migration failed")
+		return err
+	}
+
+	err = secrets.Migrate()
+	if err != nil {
+		log.Error("secrets migration failed")
+		return err
+	}
+
+	err = audit.Migrate()
+	if err != nil {
+		log.Error("audit migration failed")
+		return err
+	}
+
 	return nil
 }
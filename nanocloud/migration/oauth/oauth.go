@@ -24,6 +24,8 @@ package oauth
 
 import (
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
+	oauthModel "github.com/Nanocloud/community/nanocloud/models/oauth"
 	log "github.com/Sirupsen/logrus"
 )
 
@@ -101,5 +103,252 @@ func Migrate() error {
 		}
 		defer rows.Close()
 	}
+
+	// token lifetimes, in seconds, of each client
+	err = schema.AddColumn("oauth_clients", "access_token_ttl", "integer NOT NULL DEFAULT 86400")
+	if err != nil {
+		return err
+	}
+
+	err = schema.AddColumn("oauth_clients", "refresh_token_ttl", "integer NOT NULL DEFAULT 2592000")
+	if err != nil {
+		return err
+	}
+
+	// oauth_refresh_tokens table
+	rows, err = db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'oauth_refresh_tokens'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("[nanocloud] oauth_refresh_tokens table already set up\n")
+	} else {
+		rows, err = db.Query(
+			`CREATE TABLE oauth_refresh_tokens (
+				id                varchar(255) PRIMARY KEY,
+				token             varchar(255) NOT NULL DEFAULT '' UNIQUE,
+				oauth_client_id   integer REFERENCES oauth_clients (id) ON DELETE CASCADE,
+				user_id           varchar(255) NOT NULL DEFAULT '',
+				access_token_id   varchar(255) NOT NULL DEFAULT '',
+				created_at        timestamp,
+				expires_at        timestamp
+			)`)
+
+		if err != nil {
+			log.Errorf("[nanocloud] Unable to create oauth_refresh_tokens table: %s\n", err)
+			return err
+		}
+		defer rows.Close()
+	}
+
+	// space separated list of the redirect URIs allowed for the
+	// authorization_code grant
+	err = schema.AddColumn("oauth_clients", "redirect_uris", "text NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	// oauth_authorization_codes table
+	rows, err = db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'oauth_authorization_codes'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("[nanocloud] oauth_authorization_codes table already set up\n")
+	} else {
+		rows, err = db.Query(
+			`CREATE TABLE oauth_authorization_codes (
+				code              varchar(255) PRIMARY KEY,
+				oauth_client_id   integer REFERENCES oauth_clients (id) ON DELETE CASCADE,
+				user_id           varchar(255) NOT NULL DEFAULT '',
+				redirect_uri      text NOT NULL DEFAULT '',
+				code_challenge    varchar(255) NOT NULL DEFAULT '',
+				created_at        timestamp,
+				expires_at        timestamp
+			)`)
+
+		if err != nil {
+			log.Errorf("[nanocloud] Unable to create oauth_authorization_codes table: %s\n", err)
+			return err
+		}
+		defer rows.Close()
+	}
+
+	// space separated list of the scopes a client can get through the
+	// client_credentials grant, and of the scopes granted to a token
+	err = schema.AddColumn("oauth_clients", "scopes", "text NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	err = schema.AddColumn("oauth_access_tokens", "scopes", "text NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	// id of the administrator who got the token to act as its user
+	err = schema.AddColumn("oauth_access_tokens", "impersonator_id", "varchar(36) NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	// client secrets are only stored hashed
+	err = schema.AddColumn("oauth_clients", "secret_hash", "varchar(64) NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	err = hashClientSecrets()
+	if err != nil {
+		return err
+	}
+
+	// resource servers allowed to use the introspection endpoint
+	err = schema.AddColumn("oauth_clients", "introspection", "boolean NOT NULL DEFAULT false")
+	if err != nil {
+		return err
+	}
+
+	// tokens obtained by refreshing the same grant share a family, revoked
+	// as a whole when a used refresh token is presented again
+	err = schema.AddColumn("oauth_access_tokens", "family_id", "varchar(255) NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	err = schema.AddColumn("oauth_refresh_tokens", "family_id", "varchar(255) NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	err = schema.AddColumn("oauth_refresh_tokens", "used_at", "timestamp")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`UPDATE oauth_access_tokens
+		SET family_id = id
+		WHERE family_id = ''`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`UPDATE oauth_refresh_tokens
+		SET family_id = access_token_id
+		WHERE family_id = ''`)
+	if err != nil {
+		return err
+	}
+
+	// login_failures table
+	rows, err = db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'login_failures'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("[nanocloud] login_failures table already set up\n")
+	} else {
+		rows, err = db.Query(
+			`CREATE TABLE login_failures (
+				id          serial PRIMARY KEY,
+				username    varchar(255) NOT NULL DEFAULT '',
+				ip          varchar(255),
+				user_agent  varchar(255),
+				created_at  timestamp
+			)`)
+
+		if err != nil {
+			log.Errorf("[nanocloud] Unable to create login_failures table: %s\n", err)
+			return err
+		}
+		defer rows.Close()
+	}
+
+	// login_lockouts table, failure counters of the accounts and of the IPs
+	rows, err = db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'login_lockouts'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("[nanocloud] login_lockouts table already set up\n")
+	} else {
+		rows, err = db.Query(
+			`CREATE TABLE login_lockouts (
+				id               serial PRIMARY KEY,
+				kind             varchar(16) NOT NULL,
+				value            varchar(255) NOT NULL,
+				failures         integer NOT NULL DEFAULT 0,
+				last_failure_at  timestamp,
+				locked_until     timestamp,
+				UNIQUE (kind, value)
+			)`)
+
+		if err != nil {
+			log.Errorf("[nanocloud] Unable to create login_lockouts table: %s\n", err)
+			return err
+		}
+		defer rows.Close()
+	}
+	return nil
+}
+
+// Hash the plain secrets left by earlier versions, or set by hand in the
+// database, and clear them.
+func hashClientSecrets() error {
+	rows, err := db.Query(
+		`SELECT id, secret
+		FROM oauth_clients
+		WHERE secret != ''`)
+	if err != nil {
+		return err
+	}
+
+	secrets := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var secret string
+		err = rows.Scan(&id, &secret)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		secrets[id] = secret
+	}
+	rows.Close()
+
+	for id, secret := range secrets {
+		_, err = db.Exec(
+			`UPDATE oauth_clients
+			SET secret_hash = $1::varchar, secret = ''
+			WHERE id = $2::integer`,
+			oauthModel.HashClientSecret(secret), id,
+		)
+		if err != nil {
+			log.Errorf("[nanocloud] Unable to hash the secret of oauth client %d: %s\n", id, err)
+			return err
+		}
+	}
 	return nil
 }
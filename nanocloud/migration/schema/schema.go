@@ -0,0 +1,82 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package schema
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Add a column to an existing table unless it is already there.
+// definition is the SQL type and constraints of the column.
+func AddColumn(table, column, definition string) error {
+	rows, err := db.Query(
+		`SELECT column_name
+		FROM information_schema.columns
+		WHERE table_name = $1::varchar
+		AND column_name = $2::varchar`,
+		table, column,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	_, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	if err != nil {
+		log.Errorf("Unable to add column %s to %s: %s", column, table, err)
+		return err
+	}
+	return nil
+}
+
+// Change the type of a column to text unless it already is.
+func WidenToText(table, column string) error {
+	rows, err := db.Query(
+		`SELECT column_name
+		FROM information_schema.columns
+		WHERE table_name = $1::varchar
+		AND column_name = $2::varchar
+		AND data_type = 'text'`,
+		table, column,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	_, err = db.Exec(`ALTER TABLE ` + table + ` ALTER COLUMN ` + column + ` TYPE text`)
+	if err != nil {
+		log.Errorf("Unable to change the type of %s.%s: %s", table, column, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package secrets
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
+	"github.com/Nanocloud/community/nanocloud/secrets"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Tables whose password column holds encrypted credentials
+var tables = []string{"windows_users", "machines"}
+
+type row struct {
+	id       string
+	password string
+}
+
+// Encrypt the passwords stored in plain text and the ones encrypted with an
+// older master key
+func encryptTable(table string) error {
+	rows, err := db.Query(
+		`SELECT id::varchar, password
+		FROM ` + table + `
+		WHERE password IS NOT NULL
+		AND password <> ''`,
+	)
+	if err != nil {
+		return err
+	}
+
+	pending := make([]row, 0)
+	for rows.Next() {
+		r := row{}
+		err = rows.Scan(&r.id, &r.password)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if secrets.NeedsRotation(r.password) {
+			pending = append(pending, r)
+		}
+	}
+	rows.Close()
+
+	for _, r := range pending {
+		password, err := secrets.Rotate(r.password)
+		if err != nil {
+			log.Errorf("Unable to encrypt the password of %s %s: %s", table, r.id, err)
+			return err
+		}
+
+		_, err = db.Exec(
+			`UPDATE `+table+`
+			SET password = $1::varchar
+			WHERE id::varchar = $2::varchar
+			AND password = $3::varchar`,
+			password, r.id, r.password,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(pending) > 0 {
+		log.Infof("%d passwords of %s encrypted with the current master key", len(pending), table)
+	}
+	return nil
+}
+
+func Migrate() error {
+	// master keys that are configured but unusable stop the startup
+	err := secrets.Load()
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		// the encrypted passwords don't fit in the original columns
+		err = schema.WidenToText(table, "password")
+		if err != nil {
+			return err
+		}
+
+		if !secrets.Enabled() {
+			continue
+		}
+
+		err = encryptTable(table)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -24,6 +24,8 @@ package users
 
 import (
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
@@ -125,6 +127,396 @@ func createUsersTable() (bool, error) {
 	return true, nil
 }
 
+func createGroupsTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+			FROM information_schema.tables
+			WHERE table_name = 'groups'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE groups (
+			id   varchar(36)  PRIMARY KEY,
+			name varchar(255) NOT NULL UNIQUE
+		);`)
+	if err != nil {
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
+
+func createUsersGroupsTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+			FROM information_schema.tables
+			WHERE table_name = 'users_groups'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE users_groups (
+			user_id varchar(36)
+			REFERENCES users(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			group_id varchar(36)
+			REFERENCES groups(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			PRIMARY KEY (user_id, group_id)
+		);`)
+	if err != nil {
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
+
+func createUsersMFATable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+			FROM information_schema.tables
+			WHERE table_name = 'users_mfa'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE users_mfa (
+			user_id        varchar(36)
+			REFERENCES users(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			secret         varchar(255) NOT NULL,
+			enabled        boolean NOT NULL DEFAULT false,
+			last_used_step bigint NOT NULL DEFAULT 0,
+			PRIMARY KEY (user_id)
+		);`)
+	if err != nil {
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
+
+func createUsersRecoveryCodesTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+			FROM information_schema.tables
+			WHERE table_name = 'users_recovery_codes'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE users_recovery_codes (
+			user_id   varchar(36)
+			REFERENCES users(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			code_hash varchar(64),
+			PRIMARY KEY (user_id, code_hash)
+		);`)
+	if err != nil {
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
+
+func createUsersPasswordHistoryTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+			FROM information_schema.tables
+			WHERE table_name = 'users_password_history'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE users_password_history (
+			user_id     varchar(36)
+			REFERENCES users(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			password    varchar(60) NOT NULL,
+			created_at  timestamp with time zone NOT NULL DEFAULT current_timestamp
+		);`)
+	if err != nil {
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
+
+func createPasswordResetsTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+			FROM information_schema.tables
+			WHERE table_name = 'password_resets'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE password_resets (
+			token_hash  varchar(64) PRIMARY KEY,
+			user_id     varchar(36)
+			REFERENCES users(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			created_at  timestamp with time zone NOT NULL DEFAULT current_timestamp,
+			expires_at  timestamp with time zone NOT NULL
+		);`)
+	if err != nil {
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
+
+func createRegistrationsTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+			FROM information_schema.tables
+			WHERE table_name = 'registrations'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE registrations (
+			user_id         varchar(36)
+			REFERENCES users(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			token_hash      varchar(64) UNIQUE,
+			email_verified  boolean NOT NULL DEFAULT false,
+			created_at      timestamp with time zone NOT NULL DEFAULT current_timestamp,
+			expires_at      timestamp with time zone NOT NULL,
+			PRIMARY KEY (user_id)
+		);`)
+	if err != nil {
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
+
+func createUserImportsTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+			FROM information_schema.tables
+			WHERE table_name = 'user_imports'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE user_imports (
+			id           varchar(36) PRIMARY KEY,
+			source       varchar(16) NOT NULL,
+			dry_run      boolean NOT NULL DEFAULT false,
+			status       varchar(16) NOT NULL DEFAULT 'running',
+			total        integer NOT NULL DEFAULT 0,
+			processed    integer NOT NULL DEFAULT 0,
+			created      integer NOT NULL DEFAULT 0,
+			failed       integer NOT NULL DEFAULT 0,
+			created_at   timestamp with time zone NOT NULL DEFAULT current_timestamp,
+			finished_at  timestamp with time zone
+		);`)
+	if err != nil {
+		return err
+	}
+	rows.Close()
+
+	rows, err = db.Query(
+		`CREATE TABLE user_import_errors (
+			import_id  varchar(36)
+			REFERENCES user_imports(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			line       integer NOT NULL,
+			email      varchar(255) NOT NULL DEFAULT '',
+			detail     text NOT NULL,
+			PRIMARY KEY (import_id, line)
+		);`)
+	if err != nil {
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
+
+// The roles created with the tables, administrators can then add their own
+var builtinRoles = []roles.Role{
+	{
+		Name:        "helpdesk",
+		Description: "Resets passwords and closes sessions",
+		Permissions: []string{"users:read", "passwords:reset", "sessions:logoff"},
+		Builtin:     true,
+	},
+	{
+		Name:        "app-publisher",
+		Description: "Publishes and unpublishes applications",
+		Permissions: []string{"apps:read", "apps:write"},
+		Builtin:     true,
+	},
+}
+
+func createRolesTables() error {
+	rows, err := db.Query(
+		`SELECT table_name
+			FROM information_schema.tables
+			WHERE table_name = 'roles'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE roles (
+			id           varchar(36) PRIMARY KEY,
+			name         varchar(255) NOT NULL UNIQUE,
+			description  text NOT NULL DEFAULT '',
+			builtin      boolean NOT NULL DEFAULT false,
+			created_at   timestamp with time zone NOT NULL DEFAULT current_timestamp
+		);`)
+	if err != nil {
+		return err
+	}
+	rows.Close()
+
+	rows, err = db.Query(
+		`CREATE TABLE roles_permissions (
+			role_id     varchar(36)
+			REFERENCES roles(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			permission  varchar(64) NOT NULL,
+			PRIMARY KEY (role_id, permission)
+		);`)
+	if err != nil {
+		return err
+	}
+	rows.Close()
+
+	rows, err = db.Query(
+		`CREATE TABLE users_roles (
+			user_id  varchar(36)
+			REFERENCES users(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			role_id  varchar(36)
+			REFERENCES roles(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			PRIMARY KEY (user_id, role_id)
+		);`)
+	if err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, role := range builtinRoles {
+		role := role
+		err = roles.Create(&role)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The audit log outlives the accounts, it has no foreign key to users
+func createImpersonationLogsTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+			FROM information_schema.tables
+			WHERE table_name = 'impersonation_logs'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE impersonation_logs (
+			id               varchar(36) PRIMARY KEY,
+			impersonator_id  varchar(36) NOT NULL,
+			user_id          varchar(36) NOT NULL,
+			method           varchar(16) NOT NULL,
+			path             text NOT NULL,
+			status           integer NOT NULL DEFAULT 0,
+			ip               varchar(255) NOT NULL DEFAULT '',
+			created_at       timestamp with time zone NOT NULL DEFAULT current_timestamp
+		);`)
+	if err != nil {
+		return err
+	}
+	rows.Close()
+	return nil
+}
+
 func Migrate() error {
 	insertAdmin, err := createUsersTable()
 	if err != nil {
@@ -141,6 +533,85 @@ func Migrate() error {
 		return err
 	}
 
+	err = createGroupsTable()
+	if err != nil {
+		return err
+	}
+
+	err = createUsersGroupsTable()
+	if err != nil {
+		return err
+	}
+
+	err = createUsersMFATable()
+	if err != nil {
+		return err
+	}
+
+	err = createUsersRecoveryCodesTable()
+	if err != nil {
+		return err
+	}
+
+	err = createUsersPasswordHistoryTable()
+	if err != nil {
+		return err
+	}
+
+	err = createPasswordResetsTable()
+	if err != nil {
+		return err
+	}
+
+	err = createRegistrationsTable()
+	if err != nil {
+		return err
+	}
+
+	err = createUserImportsTable()
+	if err != nil {
+		return err
+	}
+
+	err = createRolesTables()
+	if err != nil {
+		return err
+	}
+
+	err = createImpersonationLogsTable()
+	if err != nil {
+		return err
+	}
+
+	err = schema.AddColumn("windows_users", "password_expires_at", "timestamp with time zone")
+	if err != nil {
+		return err
+	}
+
+	err = schema.AddColumn("users", "password_changed_at", "timestamp with time zone NOT NULL DEFAULT current_timestamp")
+	if err != nil {
+		return err
+	}
+
+	// directory entry of the users provisioned from LDAP, empty for the
+	// other users
+	err = schema.AddColumn("users", "ldap_dn", "text NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	// issuer and NameID of the users provisioned by SAML, empty for the
+	// other users
+	err = schema.AddColumn("users", "saml_issuer", "text NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	err = schema.AddColumn("users", "saml_name_id", "text NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
 	if insertAdmin {
 		adminpwd := utils.Env("ADMIN_PASSWORD", "Nanocloud123+")
 		adminfirstname := utils.Env("ADMIN_FIRSTNAME", "Admin")
@@ -23,16 +23,17 @@
 package apps
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
-	"math/rand"
 	"strconv"
-	"strings"
-	"time"
 
+	"github.com/Nanocloud/community/nanocloud/balancer"
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/connectors/vms"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/plaza"
+	"github.com/Nanocloud/community/nanocloud/provisioning"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
 	uuid "github.com/satori/go.uuid"
@@ -47,11 +48,23 @@ var (
 	PublishFailed       = errors.New("Publish application failed")
 	AppsListUnavailable = errors.New("Apps list isn't available")
 	FailedNameChange    = errors.New("Failed to change the app name")
+	GrantNotFound       = errors.New("Grant not found")
+	GrantNotCreated     = errors.New("Grant not created")
+	GrantDuplicated     = errors.New("Grant duplicated")
 )
 
+// entitledAppIds selects the ids of the applications granted to the user
+// passed as first parameter, either directly or through one of its groups.
+const entitledAppIds = `SELECT app_grants.app_id
+	FROM app_grants
+	WHERE app_grants.user_id = $1::varchar
+	UNION
+	SELECT app_grants.app_id
+	FROM app_grants
+	JOIN users_groups ON users_groups.group_id = app_grants.group_id
+	WHERE users_groups.user_id = $1::varchar`
+
 var (
-	kServer               string
-	kExecutionServers     []string
 	kRDPPort              string
 	kXMLConfigurationFile string
 	kProtocol             string
@@ -76,6 +89,22 @@ func (a *App) SetID(id string) error {
 	return nil
 }
 
+type Grant struct {
+	Id      string `json:"-"`
+	AppId   string `json:"app-id"`
+	UserId  string `json:"user-id,omitempty"`
+	GroupId string `json:"group-id,omitempty"`
+}
+
+func (g *Grant) GetID() string {
+	return g.Id
+}
+
+func (g *Grant) SetID(id string) error {
+	g.Id = id
+	return nil
+}
+
 type ApplicationWin struct {
 	Id             int
 	CollectionName string
@@ -218,7 +247,9 @@ func GetUserApps(userId string) ([]*App, error) {
 		alias, display_name,
 		file_path,
 		icon_content
-		FROM apps`,
+		FROM apps
+		WHERE id IN (`+entitledAppIds+`)`,
+		userId,
 	)
 
 	if err != nil {
@@ -249,6 +280,19 @@ func GetUserApps(userId string) ([]*App, error) {
 	return applications, nil
 }
 
+// RemoteApp publications are shared by the whole collection, so any of the
+// execution servers can publish or unpublish an application.
+func publicationServer() (string, error) {
+	servers, err := vms.ExecutionServers()
+	if err != nil {
+		return "", err
+	}
+	if len(servers) == 0 {
+		return "", balancer.NoServerAvailable
+	}
+	return servers[0], nil
+}
+
 func getCredentials() (string, string) {
 	rows, err := db.Query(
 		`SELECT sam, windows_password
@@ -296,9 +340,9 @@ func UnpublishApp(user *users.User, id string) error {
 
 	rows.Scan(&alias, &collection)
 
-	plazaAddress := utils.Env("EXECUTION_SERVERS", "iaas-module")
-	if plazaAddress == "" {
-		return errors.New("plaza address unknown")
+	plazaAddress, err := publicationServer()
+	if err != nil {
+		return err
 	}
 
 	plazaPort, err := strconv.Atoi(utils.Env("PLAZA_PORT", "9090"))
@@ -334,9 +378,9 @@ func UnpublishApp(user *users.User, id string) error {
 }
 
 func PublishApp(user *users.User, app *App) error {
-	plazaAddress := utils.Env("EXECUTION_SERVERS", "iaas-module")
-	if plazaAddress == "" {
-		return errors.New("plaza address unknown")
+	plazaAddress, err := publicationServer()
+	if err != nil {
+		return err
 	}
 
 	plazaPort, err := strconv.Atoi(utils.Env("PLAZA_PORT", "9090"))
@@ -417,41 +461,59 @@ func CreateApp(app *App) (*App, error) {
 }
 
 func RetrieveConnections(user *users.User) ([]Connection, error) {
-	rand.Seed(time.Now().UTC().UnixNano())
 	var connections []Connection
 
-	rows, err := db.Query("SELECT alias FROM apps")
+	servers, err := vms.ExecutionServers()
+	if err != nil {
+		log.Error("Unable to retrieve the execution servers: ", err)
+		return nil, err
+	}
+
+	execServ, err := balancer.Pick(user.Id, servers)
+	if err != nil {
+		log.Error("Unable to pick an execution server: ", err)
+		return nil, err
+	}
+
+	var rows *sql.Rows
+	if user.IsAdmin {
+		rows, err = db.Query("SELECT alias FROM apps")
+	} else {
+		rows, err = db.Query(
+			`SELECT alias FROM apps
+			WHERE id IN (`+entitledAppIds+`)`,
+			user.Id,
+		)
+	}
 	if err != nil {
 		log.Error("Unable to retrieve apps list from Postgres: ", err.Error())
 		return nil, AppsListUnavailable
 	}
 	defer rows.Close()
-	var execServ string
+
+	// the credentials are only issued if the user has applications
+	var username, pwd string
 	for rows.Next() {
 		appParam := App{}
 		rows.Scan(
 			&appParam.Alias,
 		)
 
-		if count := len(kExecutionServers); count > 0 {
-			execServ = kExecutionServers[rand.Intn(count)]
-		} else {
-			execServ = kServer
-		}
+		if username == "" {
+			winUser, err := provisioning.SessionCredentials(user)
+			if err != nil {
+				return nil, err
+			}
 
-		winUser, err := user.WindowsCredentials()
-		if err != nil {
-			return nil, err
-		}
+			username = winUser.Sam
 
-		username := winUser.Sam
+			if len(winUser.Domain) > 0 {
+				username = username + "@" + winUser.Domain
+			}
 
-		if len(winUser.Domain) > 0 {
-			username = username + "@" + winUser.Domain
+			pwd = winUser.Password
 		}
 
-		pwd := winUser.Password
-
 		var conn Connection
 		if appParam.Alias != "hapticDesktop" {
 			conn = Connection{
@@ -480,17 +542,114 @@ func RetrieveConnections(user *users.User) ([]Connection, error) {
 	return connections, nil
 }
 
-func init() {
-	kProtocol = utils.Env("PROTOCOL", "rdp")
-	kRDPPort = utils.Env("RDP_PORT", "3389")
-	kServer = utils.Env("EXECUTION_SERVERS", "iaas-module")
-	kExecutionServers = strings.Split(utils.Env("EXECUTION_SERVERS", ""), ",")
+func GetGrants(appId string) ([]*Grant, error) {
+	rows, err := db.Query(
+		`SELECT id, app_id,
+		COALESCE(user_id, ''), COALESCE(group_id, '')
+		FROM app_grants
+		WHERE app_id = $1::varchar`,
+		appId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := make([]*Grant, 0)
+	for rows.Next() {
+		grant := Grant{}
+		err = rows.Scan(
+			&grant.Id,
+			&grant.AppId,
+			&grant.UserId,
+			&grant.GroupId,
+		)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, &grant)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
 
-	if kServer == "" {
-		panic("EXECUTION_SERVERS not set")
+// Entitle a user or a group to an application. Exactly one of userId and
+// groupId must be set.
+func CreateGrant(appId, userId, groupId string) (*Grant, error) {
+	if (userId == "") == (groupId == "") {
+		return nil, GrantNotCreated
 	}
 
-	if len(kExecutionServers) == 0 {
-		panic("EXECUTION_SERVERS not set")
+	var user, group interface{}
+	if userId != "" {
+		user = userId
+	}
+	if groupId != "" {
+		group = groupId
+	}
+
+	id := uuid.NewV4().String()
+	rows, err := db.Query(
+		`INSERT INTO app_grants
+		(id, app_id, user_id, group_id)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, $4::varchar)
+		RETURNING id, app_id,
+		COALESCE(user_id, ''), COALESCE(group_id, '')`,
+		id, appId, user, group,
+	)
+	if err != nil {
+		switch err.Error() {
+		case "pq: duplicate key value violates unique constraint \"app_grants_app_id_user_id_key\"",
+			"pq: duplicate key value violates unique constraint \"app_grants_app_id_group_id_key\"":
+			return nil, GrantDuplicated
+		}
+		log.Error("Unable to create grant: ", err)
+		return nil, GrantNotCreated
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, GrantNotCreated
 	}
+
+	var grant Grant
+	err = rows.Scan(
+		&grant.Id,
+		&grant.AppId,
+		&grant.UserId,
+		&grant.GroupId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+func DeleteGrant(appId, grantId string) error {
+	res, err := db.Exec(
+		`DELETE FROM app_grants
+		WHERE id = $1::varchar
+		AND app_id = $2::varchar`,
+		grantId, appId,
+	)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return GrantNotFound
+	}
+	return nil
+}
+
+func init() {
+	kProtocol = utils.Env("PROTOCOL", "rdp")
+	kRDPPort = utils.Env("RDP_PORT", "3389")
 }
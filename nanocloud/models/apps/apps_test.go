@@ -131,6 +131,21 @@ func TestGetUserApps(t *testing.T) {
 	if err != nil {
 		t.Error("Unable to get user apps")
 	}
+	if len(apps) != 0 {
+		t.Errorf("No application should be returned before being granted, got %d", len(apps))
+	}
+
+	for _, app := range list_apps {
+		_, err = CreateGrant(app.Id, user.GetID(), "")
+		if err != nil {
+			log.Fatalln("Cannot grant the app:", err.Error())
+		}
+	}
+
+	apps, err = GetUserApps(user.GetID())
+	if err != nil {
+		t.Error("Unable to get user apps")
+	}
 
 	for _, get_app := range apps {
 		if get_app == nil {
@@ -0,0 +1,250 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package groups
+
+import (
+	"errors"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	log "github.com/Sirupsen/logrus"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	GroupNotFound    = errors.New("group not found")
+	GroupNotCreated  = errors.New("group not created")
+	GroupDuplicated  = errors.New("group duplicated")
+	MemberNotFound   = errors.New("member not found")
+	MemberDuplicated = errors.New("member duplicated")
+)
+
+type Group struct {
+	Id   string `json:"-"`
+	Name string `json:"name"`
+}
+
+func (g *Group) GetID() string {
+	return g.Id
+}
+
+func (g *Group) SetID(id string) error {
+	g.Id = id
+	return nil
+}
+
+func FindAll() ([]*Group, error) {
+	rows, err := db.Query(
+		`SELECT id, name
+		FROM groups
+		ORDER BY name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make([]*Group, 0)
+	for rows.Next() {
+		group := Group{}
+		err = rows.Scan(&group.Id, &group.Name)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, &group)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func GetGroup(id string) (*Group, error) {
+	rows, err := db.Query(
+		`SELECT id, name
+		FROM groups
+		WHERE id = $1::varchar`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, nil
+	}
+
+	var group Group
+	err = rows.Scan(&group.Id, &group.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func CreateGroup(name string) (*Group, error) {
+	id := uuid.NewV4().String()
+
+	rows, err := db.Query(
+		`INSERT INTO groups
+		(id, name)
+		VALUES ($1::varchar, $2::varchar)
+		RETURNING id, name`,
+		id, name,
+	)
+	if err != nil {
+		if err.Error() == "pq: duplicate key value violates unique constraint \"groups_name_key\"" {
+			log.Error("group name exists already")
+			return nil, GroupDuplicated
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, GroupNotCreated
+	}
+
+	var group Group
+	err = rows.Scan(&group.Id, &group.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func UpdateGroupName(id, name string) error {
+	res, err := db.Exec(
+		`UPDATE groups
+		SET name = $1::varchar
+		WHERE id = $2::varchar`,
+		name, id,
+	)
+	if err != nil {
+		if err.Error() == "pq: duplicate key value violates unique constraint \"groups_name_key\"" {
+			return GroupDuplicated
+		}
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return GroupNotFound
+	}
+	return nil
+}
+
+func DeleteGroup(id string) error {
+	res, err := db.Exec("DELETE FROM groups WHERE id = $1::varchar", id)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return GroupNotFound
+	}
+	return nil
+}
+
+// Return the users belonging to the specified group.
+func GetMembers(groupId string) ([]*users.User, error) {
+	rows, err := db.Query(
+		`SELECT users.id, users.first_name, users.last_name,
+			users.email, users.is_admin, users.activated
+		FROM users_groups
+		JOIN users ON users.id = users_groups.user_id
+		WHERE users_groups.group_id = $1::varchar`,
+		groupId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]*users.User, 0)
+	for rows.Next() {
+		user := users.User{}
+		err = rows.Scan(
+			&user.Id,
+			&user.FirstName, &user.LastName,
+			&user.Email,
+			&user.IsAdmin,
+			&user.Activated,
+		)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, &user)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func AddMember(groupId, userId string) error {
+	_, err := db.Exec(
+		`INSERT INTO users_groups
+		(user_id, group_id)
+		VALUES ($1::varchar, $2::varchar)`,
+		userId, groupId,
+	)
+	if err != nil {
+		if err.Error() == "pq: duplicate key value violates unique constraint \"users_groups_pkey\"" {
+			return MemberDuplicated
+		}
+		return err
+	}
+	return nil
+}
+
+func RemoveMember(groupId, userId string) error {
+	res, err := db.Exec(
+		`DELETE FROM users_groups
+		WHERE group_id = $1::varchar
+		AND user_id = $2::varchar`,
+		groupId, userId,
+	)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return MemberNotFound
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package histories
 
 import (
 	"errors"
+	"github.com/Nanocloud/community/nanocloud/chain"
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
 	uuid "github.com/satori/go.uuid"
 )
@@ -75,19 +76,34 @@ func CreateHistory(
 ) (*History, error) {
 	id := uuid.NewV4().String()
 
-	rows, err := db.Query(
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	prev, err := kChain.Head(tx)
+	if err != nil {
+		return nil, err
+	}
+	hash := chain.Link(prev, id, userId, userMail, userFirstname, userLastname, connectionId, startDate, endDate)
+
+	_, err = tx.Exec(
 		`INSERT INTO histories
-		(id, userid, usermail, userfirstname, userlastname, connectionid, startdate, enddate)
-		VALUES(	$1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar, $6::varchar, $7::varchar, $8::varchar)`,
-		id, userId, userMail, userFirstname, userLastname, connectionId, startDate, endDate)
+		(id, userid, usermail, userfirstname, userlastname, connectionid, startdate, enddate, hash)
+		VALUES(	$1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar, $6::varchar, $7::varchar, $8::varchar, $9::varchar)`,
+		id, userId, userMail, userFirstname, userLastname, connectionId, startDate, endDate, hash)
 
 	if err != nil {
 		return nil, err
 	}
 
-	rows.Close()
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
 
-	rows, err = db.Query(
+	rows, err := db.Query(
 		`SELECT id, userid, usermail, userfirstname, userlastname, connectionid, startdate, enddate
 		FROM histories WHERE id = $1::varchar`, id)
 
@@ -115,3 +131,45 @@ func CreateHistory(
 
 	return &history, err
 }
+
+// The rows of the histories, in the order they have been chained
+func chainRows(after int64, limit int) ([]chain.Row, error) {
+	rows, err := db.Query(
+		`SELECT seq, hash, id, userid, usermail, userfirstname,
+		userlastname, connectionid, startdate, enddate
+		FROM histories
+		WHERE seq > $1::bigint
+		ORDER BY seq
+		LIMIT $2::integer`,
+		after, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]chain.Row, 0)
+	for rows.Next() {
+		row := chain.Row{Fields: make([]string, 8)}
+		err = rows.Scan(
+			&row.Seq, &row.Hash,
+			&row.Fields[0], &row.Fields[1], &row.Fields[2], &row.Fields[3],
+			&row.Fields[4], &row.Fields[5], &row.Fields[6], &row.Fields[7],
+		)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+var kChain = &chain.Table{
+	Name: "histories",
+	Seq:  "seq",
+	Rows: chainRows,
+}
+
+func init() {
+	chain.Register(kChain)
+}
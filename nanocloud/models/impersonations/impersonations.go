@@ -0,0 +1,109 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+// Package impersonations keeps the audit log of the requests made by the
+// administrators on behalf of the users they impersonate.
+package impersonations
+
+import (
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	uuid "github.com/satori/go.uuid"
+)
+
+// A request made with an impersonation token, or the creation of the token
+type Entry struct {
+	Id             string    `json:"-"`
+	ImpersonatorId string    `json:"impersonator-id"`
+	UserId         string    `json:"user-id"`
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	Status         int       `json:"status"`
+	Ip             string    `json:"ip"`
+	CreatedAt      time.Time `json:"created-at"`
+}
+
+func (e *Entry) GetID() string {
+	return e.Id
+}
+
+func (e *Entry) SetID(id string) error {
+	e.Id = id
+	return nil
+}
+
+func Record(entry *Entry) error {
+	entry.Id = uuid.NewV4().String()
+
+	rows, err := db.Query(
+		`INSERT INTO impersonation_logs
+		(id, impersonator_id, user_id,
+		 method, path, status, ip)
+		VALUES
+		($1::varchar, $2::varchar, $3::varchar,
+		 $4::varchar, $5::varchar, $6::integer, $7::varchar)
+		RETURNING created_at`,
+		entry.Id, entry.ImpersonatorId, entry.UserId,
+		entry.Method, entry.Path, entry.Status, entry.Ip,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return rows.Scan(&entry.CreatedAt)
+	}
+	return rows.Err()
+}
+
+// Return the entries, the most recent first. An empty id matches every user
+// or every administrator.
+func FindAll(userId, impersonatorId string) ([]*Entry, error) {
+	rows, err := db.Query(
+		`SELECT id, impersonator_id, user_id,
+		method, path, status, ip, created_at
+		FROM impersonation_logs
+		WHERE ($1::varchar = '' OR user_id = $1::varchar)
+		AND ($2::varchar = '' OR impersonator_id = $2::varchar)
+		ORDER BY created_at DESC`,
+		userId, impersonatorId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*Entry, 0)
+	for rows.Next() {
+		entry := Entry{}
+		err = rows.Scan(
+			&entry.Id, &entry.ImpersonatorId, &entry.UserId,
+			&entry.Method, &entry.Path, &entry.Status, &entry.Ip, &entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, rows.Err()
+}
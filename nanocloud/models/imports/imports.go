@@ -0,0 +1,206 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package imports creates users in bulk. The rows are checked with the rules
+// applied when an administrator creates a user and the import runs in the
+// background, its progress and the rows rejected being saved as it goes.
+package imports
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	Running     = "running"
+	Done        = "done"
+	Failed      = "failed"
+	Interrupted = "interrupted"
+)
+
+var ImportNotFound = errors.New("import not found")
+
+// A row that couldn't be imported
+type RowError struct {
+	Line   int    `json:"line"`
+	Email  string `json:"email"`
+	Detail string `json:"detail"`
+}
+
+type Import struct {
+	Id         string     `json:"-"`
+	Source     string     `json:"source"`
+	DryRun     bool       `json:"dry-run"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Processed  int        `json:"processed"`
+	Created    int        `json:"created"`
+	Failed     int        `json:"failed"`
+	CreatedAt  time.Time  `json:"created-at"`
+	FinishedAt *time.Time `json:"finished-at"`
+	Errors     []RowError `json:"errors"`
+}
+
+func (i *Import) GetID() string {
+	return i.Id
+}
+
+func (i *Import) SetID(id string) error {
+	i.Id = id
+	return nil
+}
+
+func create(source string, dryRun bool, total int) (*Import, error) {
+	imp := Import{
+		Id:        uuid.NewV4().String(),
+		Source:    source,
+		DryRun:    dryRun,
+		Status:    Running,
+		Total:     total,
+		CreatedAt: time.Now(),
+		Errors:    []RowError{},
+	}
+
+	_, err := db.Exec(
+		`INSERT INTO user_imports
+		(id, source, dry_run, total)
+		VALUES ($1::varchar, $2::varchar, $3::boolean, $4::integer)`,
+		imp.Id, imp.Source, imp.DryRun, imp.Total,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &imp, nil
+}
+
+func (i *Import) saveProgress() error {
+	_, err := db.Exec(
+		`UPDATE user_imports
+		SET processed = $2::integer, created = $3::integer, failed = $4::integer
+		WHERE id = $1::varchar`,
+		i.Id, i.Processed, i.Created, i.Failed,
+	)
+	return err
+}
+
+func (i *Import) addError(rowError RowError) error {
+	_, err := db.Exec(
+		`INSERT INTO user_import_errors
+		(import_id, line, email, detail)
+		VALUES ($1::varchar, $2::integer, $3::varchar, $4::varchar)`,
+		i.Id, rowError.Line, rowError.Email, rowError.Detail,
+	)
+	return err
+}
+
+func (i *Import) finish(status string) error {
+	_, err := db.Exec(
+		`UPDATE user_imports
+		SET status = $2::varchar, finished_at = NOW()
+		WHERE id = $1::varchar`,
+		i.Id, status,
+	)
+	return err
+}
+
+// Mark the imports that were running when the server stopped as
+// interrupted. The rows aren't kept, so they can't be resumed.
+func Interrupt() error {
+	_, err := db.Exec(
+		`UPDATE user_imports
+		SET status = $2::varchar, finished_at = NOW()
+		WHERE status = $1::varchar`,
+		Running, Interrupted,
+	)
+	return err
+}
+
+func scanImports(where string, args ...interface{}) ([]*Import, error) {
+	rows, err := db.Query(
+		`SELECT id, source, dry_run, status, total, processed,
+			created, failed, created_at, finished_at
+		FROM user_imports
+		`+where+`
+		ORDER BY created_at DESC`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	all := make([]*Import, 0)
+	for rows.Next() {
+		imp := Import{Errors: []RowError{}}
+		err = rows.Scan(
+			&imp.Id, &imp.Source, &imp.DryRun, &imp.Status, &imp.Total,
+			&imp.Processed, &imp.Created, &imp.Failed, &imp.CreatedAt, &imp.FinishedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, &imp)
+	}
+	return all, rows.Err()
+}
+
+// Return the imports, most recent first, without their errors
+func FindAll() ([]*Import, error) {
+	return scanImports("")
+}
+
+// Return the import with the rows that couldn't be imported
+func Get(id string) (*Import, error) {
+	found, err := scanImports("WHERE id = $1::varchar", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, ImportNotFound
+	}
+	imp := found[0]
+
+	rows, err := db.Query(
+		`SELECT line, email, detail
+		FROM user_import_errors
+		WHERE import_id = $1::varchar
+		ORDER BY line`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rowError := RowError{}
+		err = rows.Scan(&rowError.Line, &rowError.Email, &rowError.Detail)
+		if err != nil {
+			return nil, err
+		}
+		imp.Errors = append(imp.Errors, rowError)
+	}
+	return imp, rows.Err()
+}
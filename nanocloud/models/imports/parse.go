@@ -0,0 +1,179 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package imports
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+)
+
+// Sources of the rows
+const (
+	CSV        = "csv"
+	JSONLines  = "jsonl"
+	LDAPSearch = "ldap"
+)
+
+// Maximum number of rows of an import
+const MaxRows = 10000
+
+var TooManyRows = fmt.Errorf("an import can't have more than %d rows", MaxRows)
+
+// A user to create. Line is the position of the row in its file, the CSV
+// header being the line 1. The users found in the directory have no
+// password, they log in with their directory account, DN.
+type Row struct {
+	Line      int    `json:"-"`
+	Email     string `json:"email"`
+	FirstName string `json:"first-name"`
+	LastName  string `json:"last-name"`
+	Password  string `json:"password"`
+	External  bool   `json:"-"`
+	DN        string `json:"-"`
+}
+
+// Read the rows of a CSV file. Its first line names the columns: email,
+// first-name, last-name and password, in any order.
+func ParseCSV(r io.Reader) ([]Row, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the CSV file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "email", "first-name", "last-name", "password":
+		default:
+			return nil, fmt.Errorf("unknown column \"%s\"", name)
+		}
+		if _, exists := columns[name]; exists {
+			return nil, fmt.Errorf("duplicated column \"%s\"", name)
+		}
+		columns[name] = i
+	}
+
+	if _, exists := columns["email"]; !exists {
+		return nil, errors.New("the email column is missing")
+	}
+
+	field := func(record []string, name string) string {
+		i, exists := columns[name]
+		if !exists {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	rows := make([]Row, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rows) == MaxRows {
+			return nil, TooManyRows
+		}
+
+		rows = append(rows, Row{
+			Line:      len(rows) + 2,
+			Email:     field(record, "email"),
+			FirstName: field(record, "first-name"),
+			LastName:  field(record, "last-name"),
+			Password:  field(record, "password"),
+		})
+	}
+	return rows, nil
+}
+
+// Read the rows of a JSON Lines file, one object per line with the
+// attributes of the user. The blank lines are ignored.
+func ParseJSONLines(r io.Reader) ([]Row, error) {
+	scanner := bufio.NewScanner(r)
+
+	rows := make([]Row, 0)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		if len(rows) == MaxRows {
+			return nil, TooManyRows
+		}
+
+		row := Row{}
+		err := json.Unmarshal([]byte(text), &row)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		row.Line = line
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// Build the rows from the directory accounts matching the filter
+func SearchLDAP(filter string) ([]Row, error) {
+	accounts, err := ldap.Search(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(accounts) > MaxRows {
+		return nil, TooManyRows
+	}
+
+	rows := make([]Row, len(accounts))
+	for i, account := range accounts {
+		rows[i] = Row{
+			Line:      i + 1,
+			Email:     account.Email,
+			FirstName: account.FirstName,
+			LastName:  account.LastName,
+			External:  true,
+			DN:        account.DN,
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,103 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package imports
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCSV(t *testing.T) {
+	rows, err := ParseCSV(strings.NewReader(
+		"Email, last-name,first-name,password\n" +
+			"jdoe@example.com,Doe,John,\"s3cret,Pass\"\n" +
+			"\n" +
+			"asmith@example.com,Smith,Alice,\n",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+
+	expected := Row{Line: 2, Email: "jdoe@example.com", FirstName: "John", LastName: "Doe", Password: "s3cret,Pass"}
+	if rows[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, rows[0])
+	}
+	if rows[1].Line != 3 || rows[1].Password != "" {
+		t.Errorf("unexpected second row: %+v", rows[1])
+	}
+}
+
+func TestParseInvalidCSV(t *testing.T) {
+	invalid := []string{
+		"",
+		"first-name,last-name\nJohn,Doe\n",
+		"email,phone\njdoe@example.com,555\n",
+		"email,email\njdoe@example.com,jdoe@example.com\n",
+		"email,first-name\njdoe@example.com\n",
+	}
+
+	for _, file := range invalid {
+		_, err := ParseCSV(strings.NewReader(file))
+		if err == nil {
+			t.Errorf("%q should be rejected", file)
+		}
+	}
+}
+
+func TestParseJSONLines(t *testing.T) {
+	rows, err := ParseJSONLines(strings.NewReader(
+		`{"email": "jdoe@example.com", "first-name": "John", "last-name": "Doe", "password": "s3cret"}` + "\n" +
+			"\n" +
+			`{"email": "asmith@example.com"}` + "\n",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+	if rows[0].FirstName != "John" || rows[0].Password != "s3cret" || rows[0].Line != 1 {
+		t.Errorf("unexpected first row: %+v", rows[0])
+	}
+	if rows[1].Email != "asmith@example.com" || rows[1].Line != 3 {
+		t.Errorf("unexpected second row: %+v", rows[1])
+	}
+
+	_, err = ParseJSONLines(strings.NewReader("{\"email\": \"jdoe@example.com\"}\nemail,first-name\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2") {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+}
+
+func TestTooManyRows(t *testing.T) {
+	file := "email\n" + strings.Repeat("jdoe@example.com\n", MaxRows+1)
+	_, err := ParseCSV(strings.NewReader(file))
+	if err != TooManyRows {
+		t.Errorf("expected TooManyRows, got %v", err)
+	}
+}
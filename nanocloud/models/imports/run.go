@@ -0,0 +1,168 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package imports
+
+import (
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/models/passwords"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/provisioning"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Start importing the rows in the background. A dry run only checks them.
+func Start(source string, rows []Row, dryRun bool) (*Import, error) {
+	imp, err := create(source, dryRun, len(rows))
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		err := imp.run(rows)
+		if err != nil {
+			log.Errorf("User import %s failed: %s", imp.Id, err)
+			err = imp.finish(Failed)
+		} else {
+			err = imp.finish(Done)
+		}
+		if err != nil {
+			log.Error(err)
+		}
+	}()
+	return imp, nil
+}
+
+func (i *Import) run(rows []Row) error {
+	seen := make(map[string]bool, len(rows))
+
+	for _, row := range rows {
+		detail, err := check(row, seen)
+		if err != nil {
+			return err
+		}
+
+		if detail == "" && !i.DryRun {
+			detail = importRow(row)
+		}
+
+		i.Processed++
+		if detail == "" {
+			i.Created++
+		} else {
+			i.Failed++
+			err = i.addError(RowError{row.Line, row.Email, detail})
+			if err != nil {
+				return err
+			}
+		}
+
+		err = i.saveProgress()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check a row with the rules of the users created by the administrators,
+// return why it can't be imported, or an empty string. The errors returned
+// are the ones preventing to check the row.
+func check(row Row, seen map[string]bool) (string, error) {
+	user := users.User{
+		Email:     row.Email,
+		FirstName: row.FirstName,
+		LastName:  row.LastName,
+	}
+
+	err := user.Validate()
+	if err != nil {
+		return err.Error(), nil
+	}
+
+	email := strings.ToLower(row.Email)
+	if seen[email] {
+		return "email is used by another row", nil
+	}
+	seen[email] = true
+
+	existing, err := users.GetUserFromEmail(row.Email)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		return users.UserDuplicated.Error(), nil
+	}
+
+	if row.External {
+		return "", nil
+	}
+
+	if row.Password == "" {
+		return "password is missing", nil
+	}
+
+	violations, err := passwords.Check("", row.Password, passwords.PersonalWords(&user)...)
+	if err != nil {
+		return "", err
+	}
+
+	details := make([]string, len(violations))
+	for i, violation := range violations {
+		details[i] = violation.Detail
+	}
+	return strings.Join(details, ", "), nil
+}
+
+// Create the user of a checked row, return why it failed or an empty string
+func importRow(row Row) string {
+	password := row.Password
+	if row.External {
+		// Like the users provisioned on their first login, their
+		// password is never used
+		password = utils.RandomString(32)
+	}
+
+	user, err := users.CreateUser(true, row.Email, row.FirstName, row.LastName, password, false)
+	if err != nil {
+		return err.Error()
+	}
+
+	// The directory users are linked to their account when they log in
+	if row.External {
+		err = users.SetLDAPDN(user.Id, row.DN)
+		if err != nil {
+			log.Error(err)
+			return "user created but it couldn't be bound to its directory entry"
+		}
+		return ""
+	}
+
+	err = provisioning.CreateAccount(user.Id)
+	if err != nil {
+		log.Error(err)
+		return "user created but its Windows account couldn't be scheduled"
+	}
+	return ""
+}
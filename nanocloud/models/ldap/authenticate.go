@@ -0,0 +1,181 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ldap
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/ldap.v2"
+)
+
+var InvalidCredentials = errors.New("Invalid credentials")
+var AuthenticationFailed = errors.New("Failed to authenticate user")
+var SearchFailed = errors.New("Failed to search the accounts")
+
+// An Active Directory account
+type Account struct {
+	DN        string
+	Sam       string
+	Email     string
+	FirstName string
+	LastName  string
+	Domain    string
+}
+
+// Return the DNS domain of an entry from its DC components
+// ("CN=john,DC=intra,DC=localdomain,DC=com" gives "intra.localdomain.com").
+func domainFromDN(dn string) string {
+	var dcs []string
+	for _, rdn := range strings.Split(dn, ",") {
+		rdn = strings.TrimSpace(rdn)
+		if len(rdn) > 3 && strings.EqualFold(rdn[:3], "DC=") {
+			dcs = append(dcs, rdn[3:])
+		}
+	}
+	return strings.Join(dcs, ".")
+}
+
+// Check the credentials of an account by binding as this account. The
+// username can be either the sAMAccountName, the userPrincipalName or the
+// mail of the account.
+func Authenticate(username, password string) (*Account, error) {
+	// An empty password would result in an unauthenticated bind, which
+	// succeeds whatever the username.
+	if username == "" || password == "" {
+		return nil, InvalidCredentials
+	}
+
+	ldapConnection, err := DialandBind()
+	if err != nil {
+		log.Error("Error while connecting to Active Directory: " + err.Error())
+		return nil, AuthenticationFailed
+	}
+	defer ldapConnection.Close()
+
+	escaped := ldap.EscapeFilter(username)
+	filter := "(" + kConfig.UsernameAttribute + "=" + escaped + ")(" + kConfig.EmailAttribute + "=" + escaped + ")"
+	if kConfig.ActiveDirectory {
+		filter += "(userPrincipalName=" + escaped + ")"
+
+		upn := kConfig.upn(username)
+		if upn != "" && !strings.Contains(username, "@") {
+			filter += "(userPrincipalName=" + ldap.EscapeFilter(upn) + ")"
+		}
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		utils.Env("LDAP_SEARCH_BASE", kConfig.OU),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		kConfig.personFilter("(|"+filter+")"),
+		accountAttributes(),
+		nil,
+	)
+	sr, err := ldapConnection.Search(searchRequest)
+	if err != nil {
+		log.Error("Search error: " + err.Error())
+		return nil, AuthenticationFailed
+	}
+
+	if len(sr.Entries) != 1 {
+		return nil, UnknownUser
+	}
+	entry := sr.Entries[0]
+
+	err = ldapConnection.Bind(entry.DN, password)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, InvalidCredentials
+		}
+		log.Error("Bind error: " + err.Error())
+		return nil, AuthenticationFailed
+	}
+
+	return accountFromEntry(entry), nil
+}
+
+// Return the accounts under LDAP_SEARCH_BASE matching the filter, which is
+// restricted to the person entries
+func Search(filter string) ([]*Account, error) {
+	ldapConnection, err := DialandBind()
+	if err != nil {
+		log.Error("Error while connecting to Active Directory: " + err.Error())
+		return nil, SearchFailed
+	}
+	defer ldapConnection.Close()
+
+	searchRequest := ldap.NewSearchRequest(
+		utils.Env("LDAP_SEARCH_BASE", kConfig.OU),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		kConfig.personFilter(filter),
+		accountAttributes(),
+		nil,
+	)
+	sr, err := ldapConnection.SearchWithPaging(searchRequest, 500)
+	if err != nil {
+		log.Error("Search error: " + err.Error())
+		return nil, SearchFailed
+	}
+
+	accounts := make([]*Account, len(sr.Entries))
+	for i, entry := range sr.Entries {
+		accounts[i] = accountFromEntry(entry)
+	}
+	return accounts, nil
+}
+
+func accountAttributes() []string {
+	return []string{
+		"dn",
+		"userPrincipalName",
+		kConfig.UsernameAttribute,
+		kConfig.EmailAttribute,
+		kConfig.FirstNameAttribute,
+		kConfig.LastNameAttribute,
+	}
+}
+
+func accountFromEntry(entry *ldap.Entry) *Account {
+	account := Account{
+		DN:        entry.DN,
+		Sam:       entry.GetAttributeValue(kConfig.UsernameAttribute),
+		Email:     entry.GetAttributeValue(kConfig.EmailAttribute),
+		FirstName: entry.GetAttributeValue(kConfig.FirstNameAttribute),
+		LastName:  entry.GetAttributeValue(kConfig.LastNameAttribute),
+		Domain:    kConfig.UPNSuffix,
+	}
+
+	if account.Domain == "" {
+		account.Domain = domainFromDN(entry.DN)
+	}
+
+	if account.Email == "" {
+		account.Email = entry.GetAttributeValue("userPrincipalName")
+	}
+	if account.Email == "" {
+		account.Email = account.Sam + "@" + account.Domain
+	}
+	return &account
+}
@@ -0,0 +1,128 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ldap
+
+import (
+	"testing"
+)
+
+func env(vars map[string]string) func(string, string) string {
+	return func(key, def string) string {
+		v, ok := vars[key]
+		if !ok {
+			return def
+		}
+		return v
+	}
+}
+
+func loadTestConfig(t *testing.T, vars map[string]string) *config {
+	conf, err := loadConfig(env(vars))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+func TestDefaultConfig(t *testing.T) {
+	conf := loadTestConfig(t, nil)
+
+	if !conf.ActiveDirectory || conf.Server.Scheme != "ldaps" || conf.StartTLS {
+		t.Error("the default should be Active Directory over LDAPS")
+	}
+	if conf.TLS.InsecureSkipVerify || conf.TLS.RootCAs != nil {
+		t.Error("without a CA bundle the certificate should be verified against the system roots")
+	}
+
+	dn := conf.userDN("8d6a7e0f")
+	if dn != "cn=8d6a7e0f,OU=NanocloudUsers,DC=intra,DC=localdomain,DC=com" {
+		t.Errorf("unexpected DN: %s", dn)
+	}
+}
+
+func TestSkipVerifyOptIn(t *testing.T) {
+	conf := loadTestConfig(t, map[string]string{"LDAP_TLS_SKIP_VERIFY": "true"})
+	if !conf.TLS.InsecureSkipVerify {
+		t.Error("LDAP_TLS_SKIP_VERIFY=true should skip the verification")
+	}
+}
+
+func TestOpenLDAPConfig(t *testing.T) {
+	conf := loadTestConfig(t, map[string]string{
+		"LDAP_PROFILE":    "openldap",
+		"LDAP_SERVER_URI": "ldap://openldap",
+		"LDAP_BASE_DN":    "dc=example,dc=org",
+		"LDAP_ATTR_EMAIL": "mailPrimaryAddress",
+	})
+
+	if conf.ActiveDirectory {
+		t.Error("OpenLDAP is not Active Directory")
+	}
+	if conf.Server.Host != "openldap:389" || !conf.StartTLS {
+		t.Errorf("expected StartTLS on openldap:389, got %s", conf.Server.Host)
+	}
+	if conf.TLS.ServerName != "openldap" {
+		t.Errorf("unexpected server name: %s", conf.TLS.ServerName)
+	}
+	if conf.Username != "cn=admin,dc=example,dc=org" {
+		t.Errorf("unexpected bind DN: %s", conf.Username)
+	}
+	if conf.EmailAttribute != "mailPrimaryAddress" || conf.UsernameAttribute != "uid" {
+		t.Error("attributes of the profile should be overridable")
+	}
+	if conf.userDN("jdoe") != "uid=jdoe,ou=NanocloudUsers,dc=example,dc=org" {
+		t.Errorf("unexpected DN: %s", conf.userDN("jdoe"))
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	invalid := []map[string]string{
+		{"LDAP_PROFILE": "novell"},
+		{"LDAP_USER_DN": "cn=admin,{ou}"},
+		{"LDAP_SERVER_URI": "http://iaas-module"},
+		{"LDAP_CA_FILE": "/nonexistent/ca.pem"},
+	}
+
+	for _, vars := range invalid {
+		_, err := loadConfig(env(vars))
+		if err == nil {
+			t.Errorf("%v should be rejected", vars)
+		}
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	cases := map[string]string{
+		"jdoe":       "jdoe",
+		"doe, john":  `doe\, john`,
+		"#admin":     `\#admin`,
+		" padded ":   `\ padded\ `,
+		`a+b=c<d>;\`: `a\+b\=c\<d\>\;\\`,
+	}
+
+	for value, expected := range cases {
+		if escaped := escapeDN(value); escaped != expected {
+			t.Errorf("escapeDN(%q) = %q, expected %q", value, escaped, expected)
+		}
+	}
+}
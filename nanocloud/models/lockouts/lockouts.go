@@ -0,0 +1,263 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package lockouts
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/config"
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+)
+
+// Config keys of the number of consecutive failures locking an account or an
+// IP address
+const (
+	AccountThresholdKey = "LOCKOUT_ACCOUNT_THRESHOLD"
+	IPThresholdKey      = "LOCKOUT_IP_THRESHOLD"
+)
+
+const (
+	Account = "account"
+	IP      = "ip"
+
+	defaultAccountThreshold = 5
+	defaultIPThreshold      = 20
+
+	// The lock lasts baseDelay after the threshold is reached and doubles
+	// with every further failure, up to maxDelay.
+	baseDelay = 30 * time.Second
+	maxDelay  = time.Hour
+
+	// counters are reset when no failure happened for this long
+	resetAfter = time.Hour
+)
+
+var LockoutNotFound = errors.New("lockout not found")
+
+type Lockout struct {
+	Id            string    `json:"-"`
+	Kind          string    `json:"kind"`
+	Value         string    `json:"value"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last-failure-at"`
+	LockedUntil   time.Time `json:"locked-until"`
+}
+
+func (l *Lockout) GetID() string {
+	return l.Id
+}
+
+func (l *Lockout) SetID(id string) error {
+	l.Id = id
+	return nil
+}
+
+func threshold(key string, defaultValue int) int {
+	value, err := strconv.Atoi(config.Get(key)[key])
+	if err != nil || value < 1 {
+		return defaultValue
+	}
+	return value
+}
+
+// Duration of the lock after the specified number of failures
+func lockDuration(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+
+	delay := baseDelay
+	for i := threshold; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+func normalize(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// Return how long the account and the IP address are still locked, 0 if
+// neither is.
+func Check(username, ip string) (time.Duration, error) {
+	rows, err := db.Query(
+		`SELECT COALESCE(MAX(extract(epoch from locked_until - NOW())), 0)
+		FROM login_lockouts
+		WHERE locked_until > NOW()
+		AND ((kind = $1::varchar AND value = $2::varchar)
+		  OR (kind = $3::varchar AND value = $4::varchar))`,
+		Account, normalize(username),
+		IP, ip,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var seconds float64
+	if rows.Next() {
+		err = rows.Scan(&seconds)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func recordFailure(kind, value string, threshold int) error {
+	rows, err := db.Query(
+		`INSERT INTO login_lockouts
+		(kind, value, failures, last_failure_at)
+		VALUES ($1::varchar, $2::varchar, 1, NOW())
+		ON CONFLICT (kind, value) DO UPDATE SET
+		failures = CASE
+			WHEN login_lockouts.last_failure_at < NOW() - $3::integer * interval '1 second' THEN 1
+			ELSE login_lockouts.failures + 1
+		END,
+		last_failure_at = NOW()
+		RETURNING id, failures`,
+		kind, value, int(resetAfter.Seconds()),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil
+	}
+
+	var id, failures int
+	err = rows.Scan(&id, &failures)
+	if err != nil {
+		return err
+	}
+
+	delay := lockDuration(failures, threshold)
+	if delay == 0 {
+		return nil
+	}
+
+	_, err = db.Exec(
+		`UPDATE login_lockouts
+		SET locked_until = NOW() + $2::integer * interval '1 second'
+		WHERE id = $1::integer`,
+		id, int(delay.Seconds()),
+	)
+	return err
+}
+
+// Record a failed login and update the counters of the account and of the IP
+// address.
+func RecordFailure(username, ip, userAgent string) error {
+	username = normalize(username)
+
+	_, err := db.Exec(
+		`INSERT INTO login_failures
+		(username, ip, user_agent, created_at)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, NOW())`,
+		username, ip, userAgent,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = recordFailure(Account, username, threshold(AccountThresholdKey, defaultAccountThreshold))
+	if err != nil {
+		return err
+	}
+	return recordFailure(IP, ip, threshold(IPThresholdKey, defaultIPThreshold))
+}
+
+// Reset the counter of the account after a successful login. The counter of
+// the IP address is kept, a valid account must not help guessing the others.
+func RecordSuccess(username string) error {
+	_, err := db.Exec(
+		`DELETE FROM login_lockouts
+		WHERE kind = $1::varchar
+		AND value = $2::varchar`,
+		Account, normalize(username),
+	)
+	return err
+}
+
+// Return the accounts and IP addresses currently locked
+func FindLocked() ([]*Lockout, error) {
+	rows, err := db.Query(
+		`SELECT id, kind, value, failures,
+		last_failure_at, locked_until
+		FROM login_lockouts
+		WHERE locked_until > NOW()
+		ORDER BY locked_until DESC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockouts := make([]*Lockout, 0)
+	for rows.Next() {
+		lockout := Lockout{}
+		err = rows.Scan(
+			&lockout.Id, &lockout.Kind, &lockout.Value, &lockout.Failures,
+			&lockout.LastFailureAt, &lockout.LockedUntil,
+		)
+		if err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, &lockout)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return lockouts, nil
+}
+
+// Unlock an account or an IP address and reset its counter
+func Unlock(id string) error {
+	res, err := db.Exec(
+		`DELETE FROM login_lockouts
+		WHERE id::varchar = $1::varchar`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return LockoutNotFound
+	}
+	return nil
+}
@@ -2,7 +2,7 @@
  * Nanocloud Community, a comprehensive platform to turn any application
  * into a cloud solution.
  *
- * Copyright (C) 2015 Nanocloud Software
+ * Copyright (C) 2016 Nanocloud Software
  *
  * This file is part of Nanocloud community.
  *
@@ -20,30 +20,32 @@
  * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
-package middlewares
+package lockouts
 
 import (
-	"net/http"
-
-	"github.com/Nanocloud/community/nanocloud/models/users"
-	"github.com/labstack/echo"
+	"testing"
+	"time"
 )
 
-type hash map[string]interface{}
-
-func admin(c *echo.Context, handler echo.HandlerFunc) error {
-	user := c.Get("user").(*users.User)
-
-	if !user.IsAdmin {
-		return c.JSON(http.StatusForbidden, hash{
-			"error": "forbidden",
-		})
+func TestLockDuration(t *testing.T) {
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, 30 * time.Second},
+		{6, time.Minute},
+		{7, 2 * time.Minute},
+		{11, 32 * time.Minute},
+		{12, time.Hour},
+		{100, time.Hour},
 	}
-	return handler(c)
-}
 
-func Admin(handler echo.HandlerFunc) echo.HandlerFunc {
-	return func(c *echo.Context) error {
-		return admin(c, handler)
+	for _, test := range tests {
+		delay := lockDuration(test.failures, 5)
+		if delay != test.expected {
+			t.Errorf("%d failures: expected %s, got %s", test.failures, test.expected, delay)
+		}
 	}
 }
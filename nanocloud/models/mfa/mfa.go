@@ -0,0 +1,382 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/config"
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/totp"
+)
+
+// Config key set to "true" when the administrators must use a second factor
+const RequiredForAdminsKey = "MFA_REQUIRED_FOR_ADMINS"
+
+const (
+	issuer             = "Nanocloud"
+	recoveryCodesCount = 10
+)
+
+var (
+	NotEnrolled    = errors.New("MFA not enrolled")
+	AlreadyEnabled = errors.New("MFA already enabled")
+	InvalidCode    = errors.New("invalid MFA code")
+)
+
+type Status struct {
+	UserId            string `json:"-"`
+	Enabled           bool   `json:"enabled"`
+	RecoveryCodesLeft int    `json:"recovery-codes-left"`
+}
+
+func (s *Status) GetID() string {
+	return s.UserId
+}
+
+func (s *Status) SetID(id string) error {
+	s.UserId = id
+	return nil
+}
+
+// Secret to register in the authenticator application of the user
+type Enrollment struct {
+	UserId          string `json:"-"`
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning-uri"`
+}
+
+func (e *Enrollment) GetID() string {
+	return e.UserId
+}
+
+func (e *Enrollment) SetID(id string) error {
+	e.UserId = id
+	return nil
+}
+
+type RecoveryCodes struct {
+	UserId string   `json:"-"`
+	Codes  []string `json:"codes"`
+}
+
+func (r *RecoveryCodes) GetID() string {
+	return r.UserId
+}
+
+func (r *RecoveryCodes) SetID(id string) error {
+	r.UserId = id
+	return nil
+}
+
+type Policy struct {
+	RequiredForAdmins bool `json:"required-for-admins"`
+}
+
+func (p *Policy) GetID() string {
+	return "mfa-policy"
+}
+
+func (p *Policy) SetID(id string) error {
+	return nil
+}
+
+func GetPolicy() *Policy {
+	return &Policy{
+		RequiredForAdmins: config.Get(RequiredForAdminsKey)[RequiredForAdminsKey] == "true",
+	}
+}
+
+func SetPolicy(policy *Policy) {
+	if policy.RequiredForAdmins {
+		config.Set(RequiredForAdminsKey, "true")
+	} else {
+		config.Unset(RequiredForAdminsKey)
+	}
+}
+
+func GetStatus(userId string) (*Status, error) {
+	rows, err := db.Query(
+		`SELECT enabled,
+		(SELECT COUNT(*) FROM users_recovery_codes WHERE user_id = $1::varchar)
+		FROM users_mfa
+		WHERE user_id = $1::varchar`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	status := Status{UserId: userId}
+	if rows.Next() {
+		err = rows.Scan(&status.Enabled, &status.RecoveryCodesLeft)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &status, nil
+}
+
+func IsEnabled(userId string) (bool, error) {
+	status, err := GetStatus(userId)
+	if err != nil {
+		return false, err
+	}
+	return status.Enabled, nil
+}
+
+// Generate a new secret for the user. MFA is enabled once a first code
+// generated with this secret is submitted to Activate.
+func Enroll(user *users.User) (*Enrollment, error) {
+	enabled, err := IsEnabled(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, AlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO users_mfa
+		(user_id, secret, enabled)
+		VALUES ($1::varchar, $2::varchar, false)
+		ON CONFLICT (user_id)
+		DO UPDATE SET secret = EXCLUDED.secret, enabled = false, last_used_step = 0`,
+		user.Id, secret,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Enrollment{
+		UserId:          user.Id,
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(issuer, user.Email, secret),
+	}, nil
+}
+
+// Enable MFA if code is valid for the pending enrollment and return the
+// recovery codes of the user.
+func Activate(userId, code string) (*RecoveryCodes, error) {
+	rows, err := db.Query(
+		`SELECT secret
+		FROM users_mfa
+		WHERE user_id = $1::varchar
+		AND enabled = false`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, NotEnrolled
+	}
+
+	var secret string
+	err = rows.Scan(&secret)
+	if err != nil {
+		return nil, err
+	}
+
+	step, err := totp.Validate(secret, code, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if step == 0 {
+		return nil, InvalidCode
+	}
+
+	_, err = db.Exec(
+		`UPDATE users_mfa
+		SET enabled = true, last_used_step = $2::bigint
+		WHERE user_id = $1::varchar`,
+		userId, step,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return GenerateRecoveryCodes(userId)
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Replace the recovery codes of the user. Only their hashes are stored, the
+// codes can't be displayed again.
+func GenerateRecoveryCodes(userId string) (*RecoveryCodes, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM users_recovery_codes
+		WHERE user_id = $1::varchar`,
+		userId,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	codes := RecoveryCodes{UserId: userId}
+	for i := 0; i < recoveryCodesCount; i++ {
+		random := make([]byte, 5)
+		_, err = rand.Read(random)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		code := hex.EncodeToString(random)
+		code = code[:5] + "-" + code[5:]
+
+		_, err = tx.Exec(
+			`INSERT INTO users_recovery_codes
+			(user_id, code_hash)
+			VALUES ($1::varchar, $2::varchar)`,
+			userId, hashRecoveryCode(code),
+		)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		codes.Codes = append(codes.Codes, code)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &codes, nil
+}
+
+func Disable(userId string) error {
+	_, err := db.Exec(
+		`DELETE FROM users_mfa
+		WHERE user_id = $1::varchar`,
+		userId,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`DELETE FROM users_recovery_codes
+		WHERE user_id = $1::varchar`,
+		userId,
+	)
+	return err
+}
+
+/*
+ * Check the second factor of the user, either a TOTP code or a recovery code.
+ * A TOTP code can be used only once and a recovery code is deleted once used.
+ */
+func Verify(userId, code string) error {
+	rows, err := db.Query(
+		`SELECT secret
+		FROM users_mfa
+		WHERE user_id = $1::varchar
+		AND enabled = true`,
+		userId,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return NotEnrolled
+	}
+
+	var secret string
+	err = rows.Scan(&secret)
+	if err != nil {
+		return err
+	}
+
+	step, err := totp.Validate(secret, code, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if step != 0 {
+		res, err := db.Exec(
+			`UPDATE users_mfa
+			SET last_used_step = $2::bigint
+			WHERE user_id = $1::varchar
+			AND last_used_step < $2::bigint`,
+			userId, step,
+		)
+		if err != nil {
+			return err
+		}
+
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 1 {
+			return nil
+		}
+		return InvalidCode
+	}
+
+	res, err := db.Exec(
+		`DELETE FROM users_recovery_codes
+		WHERE user_id = $1::varchar
+		AND code_hash = $2::varchar`,
+		userId, hashRecoveryCode(code),
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 1 {
+		return nil
+	}
+	return InvalidCode
+}
@@ -0,0 +1,80 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package oauth
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+)
+
+// An Impersonation is the identity behind a token delivered to an
+// administrator to act as another user. The middlewares unwrap it, the routes
+// that don't know this type refuse it.
+type Impersonation struct {
+	User           *users.User
+	ImpersonatorId string
+}
+
+// Lifetime of the impersonation tokens, they can't be refreshed
+func impersonationTTL() time.Duration {
+	minutes, err := strconv.Atoi(utils.Env("IMPERSONATION_TTL", "15"))
+	if err != nil || minutes <= 0 {
+		minutes = 15
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// Deliver a short lived token for the user, marked with the id of the
+// administrator it is delivered to. The token is bound to the client of the
+// web interface, or to the one set with IMPERSONATION_OAUTH_CLIENT.
+func Impersonate(admin, user *users.User, req *http.Request) (*AccessToken, error) {
+	removeExpiredTokens()
+
+	client, err := getClient(utils.Env("IMPERSONATION_OAUTH_CLIENT", "9405fb6b0e59d2997e3c777a22d8f0e617a9f5b36b6565c7579e5be6deb8f7ae"))
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, ClientNotFound
+	}
+
+	ttl := int(impersonationTTL().Seconds())
+	if client.AccessTokenTTL > 0 && client.AccessTokenTTL < ttl {
+		ttl = client.AccessTokenTTL
+	}
+	client.AccessTokenTTL = ttl
+
+	_, token, err := insertAccessToken(user.Id, admin.Id, "", nil, client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessToken{
+		Token:     token,
+		Type:      "Bearer",
+		ExpiresIn: time.Duration(ttl),
+	}, nil
+}
@@ -23,42 +23,148 @@
 package oauth
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Nanocloud/community/nanocloud/authenticators"
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/lockouts"
+	"github.com/Nanocloud/community/nanocloud/models/mfa"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/oauth2"
 	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
 	uuid "github.com/satori/go.uuid"
 )
 
 type oauthConnector struct{}
 
+var ClientNotFound = errors.New("OAuth client not found")
+
 type Client struct {
 	Id   int
 	Name string
 	Key  string
+	// Lifetimes of the tokens delivered to this client, in seconds.
+	// No refresh token is delivered if RefreshTokenTTL is 0.
+	AccessTokenTTL  int
+	RefreshTokenTTL int
+	// URIs the authorization_code grant is allowed to redirect to
+	RedirectURIs []string
+	// Scopes the client can get with the client_credentials grant. The grant
+	// is denied to clients without scopes.
+	Scopes []string
+	// Whether the client, a resource server, can introspect the tokens
+	// delivered to the others
+	Introspection bool
+
+	secretHash string
+}
+
+// A ServiceAccount is the identity behind an access token delivered with the
+// client_credentials grant. Such tokens aren't bound to any user.
+type ServiceAccount struct {
+	ClientId   int
+	ClientName string
+	Scopes     []string
+}
+
+func (s *ServiceAccount) HasScope(scope string) bool {
+	for _, granted := range s.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
 }
 
+// Lifetime of the codes delivered by the authorization endpoint
+const authorizationCodeTTL = 10 * time.Minute
+
 type AccessToken struct {
-	Token     string        `json:"access_token"`
-	Type      string        `json:"token_type"`
-	ExpiresIn time.Duration `json:"expires_in"`
+	Token        string        `json:"access_token"`
+	Type         string        `json:"token_type"`
+	ExpiresIn    time.Duration `json:"expires_in"`
+	RefreshToken string        `json:"refresh_token,omitempty"`
+}
+
+// Token description returned by the introspection endpoint
+type TokenInfo struct {
+	Active    bool   `json:"active"`
+	TokenType string `json:"token_type"`
+	Subject   string `json:"sub,omitempty"`
+	Username  string `json:"username,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+	ClientId  string `json:"client_id"`
+	Scope     string `json:"scope,omitempty"`
+	IsAdmin   bool   `json:"is_admin"`
+	// The administrator behind an impersonation token (RFC 8693)
+	Actor *Actor `json:"act,omitempty"`
+}
+
+type Actor struct {
+	Subject string `json:"sub"`
 }
 
 func (c oauthConnector) AuthenticateUser(username, password string) (interface{}, error) {
-	return users.GetUserFromEmailPassword(username, password)
+	user, err := authenticators.Authenticate(username, password)
+	if err == users.PasswordExpired {
+		return nil, oauth2.PasswordExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (c oauthConnector) CheckSecondFactor(rawUser interface{}, code string) error {
+	user := rawUser.(*users.User)
+
+	// Administrators who have to enable MFA can still log in to do so, the
+	// Permission middleware denies them the administration until then.
+	enabled, err := mfa.IsEnabled(user.Id)
+	if err != nil || !enabled {
+		return err
+	}
+
+	if code == "" {
+		return oauth2.SecondFactorRequired
+	}
+
+	err = mfa.Verify(user.Id, code)
+	if err == mfa.InvalidCode {
+		return oauth2.SecondFactorInvalid
+	}
+	return err
+}
+
+func (c oauthConnector) CheckLoginAttempt(username string, req *http.Request) (time.Duration, error) {
+	return lockouts.Check(username, ClientIP(req))
+}
+
+func (c oauthConnector) RecordLoginAttempt(username string, req *http.Request, succeeded bool) error {
+	if succeeded {
+		return lockouts.RecordSuccess(username)
+	}
+	return lockouts.RecordFailure(username, ClientIP(req), req.UserAgent())
 }
 
 func (c oauthConnector) GetUserFromAccessToken(accessToken string) (interface{}, error) {
 	rows, err := db.Query(
-		`SELECT user_id
-		FROM oauth_access_tokens
-		WHERE token = $1::varchar
-		AND expires_at > NOW()
+		`SELECT t.user_id, t.scopes, t.impersonator_id,
+		c.id, c.name
+		FROM oauth_access_tokens t
+		JOIN oauth_clients c ON c.id = t.oauth_client_id
+		WHERE t.token = $1::varchar
+		AND t.expires_at > NOW()
 		`,
 		accessToken,
 	)
@@ -72,19 +178,39 @@ func (c oauthConnector) GetUserFromAccessToken(accessToken string) (interface{},
 		return nil, nil
 	}
 
-	var userId string
-	err = rows.Scan(&userId)
+	var userId, scopes, impersonatorId string
+	serviceAccount := ServiceAccount{}
+	err = rows.Scan(
+		&userId, &scopes, &impersonatorId,
+		&serviceAccount.ClientId, &serviceAccount.ClientName,
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	return users.GetUser(userId)
+	if userId == "" {
+		serviceAccount.Scopes = strings.Fields(scopes)
+		return &serviceAccount, nil
+	}
+
+	user, err := users.GetUser(userId)
+	if user == nil {
+		return nil, err
+	}
+
+	if impersonatorId != "" {
+		return &Impersonation{user, impersonatorId}, nil
+	}
+	return user, nil
 }
 
-func (c oauthConnector) GetClient(key string, secret string) (interface{}, error) {
+func getClient(key string) (*Client, error) {
 	rows, err := db.Query(
 		`SELECT id, name,
-		key
+		key, access_token_ttl,
+		refresh_token_ttl, redirect_uris,
+		scopes, secret_hash,
+		introspection
 		FROM oauth_clients
 		WHERE key = $1::varchar`,
 		key,
@@ -97,33 +223,107 @@ func (c oauthConnector) GetClient(key string, secret string) (interface{}, error
 	if rows.Next() {
 		client := Client{}
 
-		err = rows.Scan(&client.Id, &client.Name, &client.Key)
+		var redirectURIs, scopes string
+		err = rows.Scan(
+			&client.Id, &client.Name,
+			&client.Key, &client.AccessTokenTTL,
+			&client.RefreshTokenTTL, &redirectURIs,
+			&scopes, &client.secretHash,
+			&client.Introspection,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		client.RedirectURIs = strings.Fields(redirectURIs)
+		client.Scopes = strings.Fields(scopes)
 		return &client, nil
 	}
 	return nil, nil
 }
 
+// Client secrets are long random strings, a SHA-256 digest is enough to store
+// them.
+func HashClientSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func checkClientSecret(hash string, secret string) bool {
+	if hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(HashClientSecret(secret))) == 1
+}
+
+// Return the client only if the secret matches, nil otherwise
+func (c oauthConnector) GetClient(key string, secret string) (interface{}, error) {
+	client, err := getClient(key)
+	if client == nil {
+		return nil, err
+	}
+
+	if !checkClientSecret(client.secretHash, secret) {
+		return nil, nil
+	}
+	return client, nil
+}
+
+func (c oauthConnector) GetAuthorizationClient(key string, redirectURI string) (interface{}, error) {
+	client, err := getClient(key)
+	if client == nil {
+		return nil, err
+	}
+
+	for _, uri := range client.RedirectURIs {
+		if uri == redirectURI {
+			return client, nil
+		}
+	}
+	return nil, nil
+}
+
 func removeExpiredTokens() {
 	db.Exec(
 		`DELETE FROM oauth_access_tokens
 		WHERE expires_at < NOW()`,
 	)
+	db.Exec(
+		`DELETE FROM oauth_refresh_tokens
+		WHERE expires_at < NOW()`,
+	)
+	db.Exec(
+		`DELETE FROM oauth_authorization_codes
+		WHERE expires_at < NOW()`,
+	)
 }
 
-func (c oauthConnector) GetAccessToken(rawUser, rawClient interface{}, req *http.Request) (interface{}, error) {
-	removeExpiredTokens()
-
-	user := rawUser.(*users.User)
-	client := rawClient.(*Client)
-
-	ua := req.UserAgent()
+// Number of proxies in front of nanocloud, each appending the address it
+// got the request from to X-Forwarded-For
+func proxyHops() int {
+	hops, err := strconv.Atoi(os.Getenv("TRUST_PROXY_HOPS"))
+	if err != nil || hops < 1 {
+		return 1
+	}
+	return hops
+}
 
-	// Get IP client address
+// Address of the client, taken from X-Forwarded-For when TRUST_PROXY is set.
+// Only the addresses appended by our proxies are trusted: the entries before
+// them come from the client, which can send any X-Forwarded-For.
+func ClientIP(req *http.Request) string {
 	var ip string
 	if os.Getenv("TRUST_PROXY") == "true" {
-		xForwardedFor := req.Header["X-Forwarded-For"]
-		if len(xForwardedFor) > 0 {
-			ip = xForwardedFor[0]
+		var forwardedFor []string
+		for _, header := range req.Header["X-Forwarded-For"] {
+			for _, address := range strings.Split(header, ",") {
+				forwardedFor = append(forwardedFor, strings.TrimSpace(address))
+			}
+		}
+
+		hops := proxyHops()
+		if len(forwardedFor) >= hops {
+			ip = forwardedFor[len(forwardedFor)-hops]
 		}
 	}
 
@@ -132,52 +332,486 @@ func (c oauthConnector) GetAccessToken(rawUser, rawClient interface{}, req *http
 		i := strings.LastIndex(addr, ":")
 		ip = addr[0:i]
 	}
+	return ip
+}
+
+// Store a new access token. The tokens obtained by refreshing it share its
+// family, an empty familyId starts a new one.
+func insertAccessToken(userId, impersonatorId, familyId string, scopes []string, client *Client, req *http.Request) (string, string, error) {
+	ua := req.UserAgent()
+	ip := ClientIP(req)
 
 	id := uuid.NewV4().String()
 	token := utils.RandomString(25)
+	if familyId == "" {
+		familyId = id
+	}
 
-	rows, err := db.Query(
+	_, err := db.Exec(
 		`INSERT INTO oauth_access_tokens
 		(id, token, oauth_client_id, user_id,
-		 created_at, user_agent, ip, expires_at)
+		 created_at, user_agent, ip, expires_at,
+		 scopes, impersonator_id, family_id)
 		VALUES
 		($1::varchar, $2::varchar, $3::integer, $4::varchar,
-		 NOW(), $5::varchar, $6::varchar, NOW() + interval '1 day')`,
-		id, token, client.Id, user.Id,
-		ua, ip,
+		 NOW(), $5::varchar, $6::varchar, NOW() + $7::integer * interval '1 second',
+		 $8::varchar, $9::varchar, $10::varchar)`,
+		id, token, client.Id, userId,
+		ua, ip, client.AccessTokenTTL,
+		strings.Join(scopes, " "), impersonatorId, familyId,
 	)
 
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
+	return id, token, nil
+}
 
-	rows.Close()
+// Create an access token for the user and, if the client accepts them, the
+// refresh token that can be exchanged for the next one. Both belong to the
+// family of the refreshed token, if any.
+func createAccessToken(user *users.User, client *Client, familyId string, req *http.Request) (*AccessToken, error) {
+	id, token, err := insertAccessToken(user.Id, "", familyId, nil, client, req)
+	if err != nil {
+		return nil, err
+	}
+	if familyId == "" {
+		familyId = id
+	}
 
 	accessToken := AccessToken{
 		Token:     token,
 		Type:      "Bearer",
-		ExpiresIn: 60 * 60 * 24,
+		ExpiresIn: time.Duration(client.AccessTokenTTL),
+	}
+
+	if client.RefreshTokenTTL > 0 {
+		refreshToken := utils.RandomString(40)
+
+		_, err = db.Exec(
+			`INSERT INTO oauth_refresh_tokens
+			(id, token, oauth_client_id, user_id,
+			 access_token_id, created_at, expires_at, family_id)
+			VALUES
+			($1::varchar, $2::varchar, $3::integer, $4::varchar,
+			 $5::varchar, NOW(), NOW() + $6::integer * interval '1 second', $7::varchar)`,
+			uuid.NewV4().String(), refreshToken, client.Id, user.Id,
+			id, client.RefreshTokenTTL, familyId,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		accessToken.RefreshToken = refreshToken
 	}
-	return accessToken, nil
+
+	return &accessToken, nil
 }
 
-func (c oauthConnector) RevokeAccessToken(rawClient interface{}, rawUser interface{}, accessToken string) error {
+func (c oauthConnector) GetAccessToken(rawUser, rawClient interface{}, req *http.Request) (interface{}, error) {
+	removeExpiredTokens()
+
 	user := rawUser.(*users.User)
 	client := rawClient.(*Client)
 
+	return createAccessToken(user, client, "", req)
+}
+
+// Deliver an access token for the user to the client identified by clientKey,
+// as the password grant does. Used by the login methods that don't go through
+// the token endpoint.
+func CreateAccessToken(user *users.User, clientKey string, req *http.Request) (*AccessToken, error) {
+	client, err := getClient(clientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if client == nil {
+		return nil, ClientNotFound
+	}
+
+	accessToken, err := oauthConnector{}.GetAccessToken(user, client, req)
+	if err != nil {
+		return nil, err
+	}
+	return accessToken.(*AccessToken), nil
+}
+
+// Exchange a refresh token for a new access token. Refresh tokens are single
+// use: the one presented is marked as used and a new one is delivered. Used
+// tokens are kept until they expire so that a replayed one, likely stolen,
+// revokes every token of its family.
+func (c oauthConnector) RefreshAccessToken(rawClient interface{}, refreshToken string, req *http.Request) (interface{}, error) {
+	removeExpiredTokens()
+
+	client := rawClient.(*Client)
+
+	rows, err := db.Query(
+		`UPDATE oauth_refresh_tokens
+		SET used_at = NOW()
+		WHERE token = $1::varchar
+		AND oauth_client_id = $2::integer
+		AND expires_at > NOW()
+		AND used_at IS NULL
+		RETURNING user_id, family_id`,
+		refreshToken, client.Id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, revokeReusedRefreshToken(client, refreshToken)
+	}
+
+	var userId, familyId string
+	err = rows.Scan(&userId, &familyId)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := users.GetUser(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil || !user.Activated {
+		return nil, nil
+	}
+
+	return createAccessToken(user, client, familyId, req)
+}
+
+// Revoke the family of a refresh token presented after it has been used
+func revokeReusedRefreshToken(client *Client, refreshToken string) error {
+	rows, err := db.Query(
+		`SELECT family_id, user_id FROM oauth_refresh_tokens
+		WHERE token = $1::varchar
+		AND oauth_client_id = $2::integer
+		AND used_at IS NOT NULL`,
+		refreshToken, client.Id,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil
+	}
+
+	var familyId, userId string
+	err = rows.Scan(&familyId, &userId)
+	if err != nil {
+		return err
+	}
+
+	log.Warnf("[OAuth] Refresh token reused for user %s, revoking its token family", userId)
+	return revokeTokenFamily(familyId)
+}
+
+// Revoke the tokens obtained from the same grant, through refreshes
+func revokeTokenFamily(familyId string) error {
+	_, err := db.Exec(
+		`DELETE FROM oauth_refresh_tokens
+		WHERE family_id = $1::varchar`,
+		familyId,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`DELETE FROM oauth_access_tokens
+		WHERE family_id = $1::varchar`,
+		familyId,
+	)
+	return err
+}
+
+// Revoke a refresh token of the client along with every token of its family,
+// as RFC 7009 asks for the access tokens based on the same grant. Unknown
+// tokens are ignored.
+func (c oauthConnector) RevokeRefreshToken(rawClient interface{}, refreshToken string) error {
+	client := rawClient.(*Client)
+
+	rows, err := db.Query(
+		`DELETE FROM oauth_refresh_tokens
+		WHERE token = $1::varchar
+		AND oauth_client_id = $2::integer
+		RETURNING family_id`,
+		refreshToken, client.Id,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil
+	}
+
+	var familyId string
+	err = rows.Scan(&familyId)
+	if err != nil {
+		return err
+	}
+	return revokeTokenFamily(familyId)
+}
+
+func (c oauthConnector) CreateAuthorizationCode(rawClient, rawUser interface{}, redirectURI string, codeChallenge string) (string, error) {
+	// an impersonation token traded for a code would give a regular,
+	// refreshable token
+	user, ok := rawUser.(*users.User)
+	if !ok {
+		return "", oauth2.IdentityNotAllowed
+	}
+	client := rawClient.(*Client)
+
+	code := utils.RandomString(40)
+
 	_, err := db.Exec(
+		`INSERT INTO oauth_authorization_codes
+		(code, oauth_client_id, user_id, redirect_uri,
+		 code_challenge, created_at, expires_at)
+		VALUES
+		($1::varchar, $2::integer, $3::varchar, $4::varchar,
+		 $5::varchar, NOW(), NOW() + $6::integer * interval '1 second')`,
+		code, client.Id, user.Id, redirectURI,
+		codeChallenge, int(authorizationCodeTTL.Seconds()),
+	)
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// Authorization codes can be used only once: a code is deleted as soon as it
+// is presented, even if the PKCE verification fails afterwards.
+func (c oauthConnector) ConsumeAuthorizationCode(rawClient interface{}, code string) (interface{}, string, string, error) {
+	removeExpiredTokens()
+
+	client := rawClient.(*Client)
+
+	rows, err := db.Query(
+		`DELETE FROM oauth_authorization_codes
+		WHERE code = $1::varchar
+		AND oauth_client_id = $2::integer
+		AND expires_at > NOW()
+		RETURNING user_id, redirect_uri, code_challenge`,
+		code, client.Id,
+	)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, "", "", nil
+	}
+
+	var userId, redirectURI, codeChallenge string
+	err = rows.Scan(&userId, &redirectURI, &codeChallenge)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	user, err := users.GetUser(userId)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if user == nil || !user.Activated {
+		return nil, "", "", nil
+	}
+	return user, redirectURI, codeChallenge, nil
+}
+
+// Deliver a token bound to the client itself. The token gets the requested
+// scopes, or all the scopes of the client if none is requested.
+func (c oauthConnector) GetClientCredentialsToken(rawClient interface{}, scopes []string, req *http.Request) (interface{}, error) {
+	removeExpiredTokens()
+
+	client := rawClient.(*Client)
+
+	if len(client.Scopes) == 0 {
+		return nil, nil
+	}
+
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	for _, scope := range scopes {
+		granted := false
+		for _, clientScope := range client.Scopes {
+			if scope == clientScope {
+				granted = true
+				break
+			}
+		}
+
+		if !granted {
+			return nil, oauth2.InvalidScope
+		}
+	}
+
+	_, token, err := insertAccessToken("", "", "", scopes, client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessToken{
+		Token:     token,
+		Type:      "Bearer",
+		ExpiresIn: time.Duration(client.AccessTokenTTL),
+	}, nil
+}
+
+func (c oauthConnector) CanIntrospect(rawClient interface{}) bool {
+	return rawClient.(*Client).Introspection
+}
+
+func (c oauthConnector) IntrospectAccessToken(accessToken string) (interface{}, error) {
+	rows, err := db.Query(
+		`SELECT t.user_id, t.scopes, t.impersonator_id,
+		extract(epoch from t.expires_at)::bigint,
+		extract(epoch from t.created_at)::bigint,
+		c.key
+		FROM oauth_access_tokens t
+		JOIN oauth_clients c ON c.id = t.oauth_client_id
+		WHERE t.token = $1::varchar
+		AND t.expires_at > NOW()`,
+		accessToken,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, nil
+	}
+
+	info := TokenInfo{
+		Active:    true,
+		TokenType: "Bearer",
+	}
+
+	var impersonatorId string
+	err = rows.Scan(
+		&info.Subject, &info.Scope, &impersonatorId,
+		&info.ExpiresAt,
+		&info.IssuedAt,
+		&info.ClientId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// service account tokens aren't bound to a user
+	if info.Subject == "" {
+		return &info, nil
+	}
+
+	user, err := users.GetUser(info.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	// the tokens of a deactivated user are reported as inactive
+	if user == nil || !user.Activated {
+		return nil, nil
+	}
+
+	info.Username = user.Email
+	info.IsAdmin = user.IsAdmin
+	if impersonatorId != "" {
+		info.Actor = &Actor{impersonatorId}
+	}
+	return &info, nil
+}
+
+func (c oauthConnector) RevokeAccessToken(rawClient interface{}, rawUser interface{}, accessToken string) error {
+	client := rawClient.(*Client)
+
+	if impersonation, ok := rawUser.(*Impersonation); ok {
+		rawUser = impersonation.User
+	}
+
+	user, ok := rawUser.(*users.User)
+	if !ok {
+		// service account tokens have no refresh token
+		_, err := db.Exec(
+			`DELETE FROM oauth_access_tokens
+			WHERE user_id = ''
+			AND token = $1::varchar
+			AND oauth_client_id = $2::integer`,
+			accessToken, client.Id,
+		)
+		return err
+	}
+
+	rows, err := db.Query(
 		`DELETE FROM oauth_access_tokens
 		WHERE user_id = $1::varchar
 		AND token = $2::varchar
-		AND oauth_client_id = $3::integer`,
+		AND oauth_client_id = $3::integer
+		RETURNING id`,
 		user.Id, accessToken, client.Id,
 	)
 	if err != nil {
 		return err
 	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	// the refresh token can't be used anymore once its access token is revoked
+	for _, id := range ids {
+		_, err = db.Exec(
+			`DELETE FROM oauth_refresh_tokens
+			WHERE user_id = $1::varchar
+			AND access_token_id = $2::varchar`,
+			user.Id, id,
+		)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// Revoke every token of a user, so that a deprovisioned user is logged out
+// right away
+func RevokeUserTokens(userId string) error {
+	_, err := db.Exec(
+		`DELETE FROM oauth_refresh_tokens
+		WHERE user_id = $1::varchar`,
+		userId,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`DELETE FROM oauth_access_tokens
+		WHERE user_id = $1::varchar`,
+		userId,
+	)
+	return err
+}
+
 func init() {
 	oauth2.SetConnector(oauthConnector{})
 }
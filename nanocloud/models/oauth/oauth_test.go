@@ -0,0 +1,81 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package oauth
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestCheckClientSecret(t *testing.T) {
+	hash := HashClientSecret("9050d67c2be0943f2c63507052ddedb3ae34a30e39bbbbdab241c93f8b5cf341")
+
+	if !checkClientSecret(hash, "9050d67c2be0943f2c63507052ddedb3ae34a30e39bbbbdab241c93f8b5cf341") {
+		t.Fatalf("The right secret should be accepted")
+	}
+
+	if checkClientSecret(hash, "wrong") {
+		t.Fatalf("A wrong secret should be refused")
+	}
+
+	if checkClientSecret(hash, "") {
+		t.Fatalf("An empty secret should be refused")
+	}
+
+	if checkClientSecret("", "") {
+		t.Fatalf("A client without secret should refuse every secret")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	defer os.Setenv("TRUST_PROXY", os.Getenv("TRUST_PROXY"))
+	defer os.Setenv("TRUST_PROXY_HOPS", os.Getenv("TRUST_PROXY_HOPS"))
+
+	req, _ := http.NewRequest("GET", "/api/me", nil)
+	req.RemoteAddr = "172.17.0.5:41872"
+	// the client sent a spoofed address, the proxy appended the real one
+	req.Header.Add("X-Forwarded-For", "10.0.0.1, 203.0.113.7")
+
+	os.Setenv("TRUST_PROXY", "false")
+	if ip := ClientIP(req); ip != "172.17.0.5" {
+		t.Errorf("X-Forwarded-For should be ignored without proxy, got %s", ip)
+	}
+
+	os.Setenv("TRUST_PROXY", "true")
+	os.Setenv("TRUST_PROXY_HOPS", "")
+	if ip := ClientIP(req); ip != "203.0.113.7" {
+		t.Errorf("expected the address appended by the proxy, got %s", ip)
+	}
+
+	// a second proxy appends the address of the first one
+	req.Header.Add("X-Forwarded-For", "172.18.0.2")
+	os.Setenv("TRUST_PROXY_HOPS", "2")
+	if ip := ClientIP(req); ip != "203.0.113.7" {
+		t.Errorf("expected the address appended by the first proxy, got %s", ip)
+	}
+
+	os.Setenv("TRUST_PROXY_HOPS", "4")
+	if ip := ClientIP(req); ip != "172.17.0.5" {
+		t.Errorf("expected the peer address when proxies are missing, got %s", ip)
+	}
+}
@@ -0,0 +1,267 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package passwords
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Nanocloud/community/nanocloud/config"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+// Config keys of the password policy
+const (
+	MinLengthKey           = "PASSWORD_MIN_LENGTH"
+	MinCharacterClassesKey = "PASSWORD_MIN_CHARACTER_CLASSES"
+	HistorySizeKey         = "PASSWORD_HISTORY_SIZE"
+	MaxAgeKey              = "PASSWORD_MAX_AGE"
+	BannedWordsKey         = "PASSWORD_BANNED_WORDS"
+)
+
+// Rules a password can break
+const (
+	TooShort  = "too-short"
+	TooSimple = "too-simple"
+	Banned    = "banned"
+	Reused    = "reused"
+)
+
+// Every account also gets a Windows account whose password must satisfy the
+// Active Directory complexity rules: 7 characters from at least 3 of the 5
+// character classes. The policy can't be weaker than that.
+var ActiveDirectory = Policy{
+	MinLength:           7,
+	MinCharacterClasses: 3,
+}
+
+type Violation struct {
+	Rule   string
+	Detail string
+}
+
+type Policy struct {
+	MinLength           int `json:"min-length"`
+	MinCharacterClasses int `json:"min-character-classes"`
+	// Number of previous passwords that can't be used again, the current one
+	// included
+	HistorySize int `json:"history-size"`
+	// Days after which a password expires, 0 if they never do
+	MaxAge      int      `json:"max-age"`
+	BannedWords []string `json:"banned-words"`
+}
+
+func (p *Policy) GetID() string {
+	return "password-policy"
+}
+
+func (p *Policy) SetID(id string) error {
+	return nil
+}
+
+func (p *Policy) normalize() {
+	if p.MinLength < ActiveDirectory.MinLength {
+		p.MinLength = ActiveDirectory.MinLength
+	}
+
+	if p.MinCharacterClasses < ActiveDirectory.MinCharacterClasses {
+		p.MinCharacterClasses = ActiveDirectory.MinCharacterClasses
+	}
+	if p.MinCharacterClasses > 5 {
+		p.MinCharacterClasses = 5
+	}
+
+	if p.HistorySize < 0 {
+		p.HistorySize = 0
+	}
+	if p.HistorySize > users.PasswordHistoryMaxSize+1 {
+		p.HistorySize = users.PasswordHistoryMaxSize + 1
+	}
+
+	if p.MaxAge < 0 {
+		p.MaxAge = 0
+	}
+
+	words := make([]string, 0, len(p.BannedWords))
+	for _, word := range p.BannedWords {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word != "" && !strings.Contains(word, ",") {
+			words = append(words, word)
+		}
+	}
+	p.BannedWords = words
+}
+
+func atoi(value string) int {
+	i, _ := strconv.Atoi(value)
+	return i
+}
+
+func GetPolicy() *Policy {
+	values := config.Get(
+		MinLengthKey,
+		MinCharacterClassesKey,
+		HistorySizeKey,
+		MaxAgeKey,
+		BannedWordsKey,
+	)
+
+	policy := Policy{
+		MinLength:           atoi(values[MinLengthKey]),
+		MinCharacterClasses: atoi(values[MinCharacterClassesKey]),
+		HistorySize:         atoi(values[HistorySizeKey]),
+		MaxAge:              atoi(values[MaxAgeKey]),
+		BannedWords:         strings.Split(values[BannedWordsKey], ","),
+	}
+	policy.normalize()
+	return &policy
+}
+
+func SetPolicy(policy *Policy) {
+	policy.normalize()
+
+	config.Set(MinLengthKey, strconv.Itoa(policy.MinLength))
+	config.Set(MinCharacterClassesKey, strconv.Itoa(policy.MinCharacterClasses))
+	config.Set(HistorySizeKey, strconv.Itoa(policy.HistorySize))
+	config.Set(MaxAgeKey, strconv.Itoa(policy.MaxAge))
+	config.Set(BannedWordsKey, strings.Join(policy.BannedWords, ","))
+}
+
+// Count the character classes used in the password, the ones of the Active
+// Directory complexity rules: uppercase, lowercase, digits, non alphanumeric
+// characters and the other alphabetic characters.
+func characterClasses(password string) int {
+	var upper, lower, digit, special, other int
+	for _, c := range password {
+		switch {
+		case unicode.IsDigit(c):
+			digit = 1
+		case unicode.IsLower(c):
+			lower = 1
+		case unicode.IsUpper(c):
+			upper = 1
+		case unicode.IsLetter(c):
+			other = 1
+		default:
+			special = 1
+		}
+	}
+	return upper + lower + digit + special + other
+}
+
+// Check the rules that don't depend on the password history. The personal
+// words (names, email...) of the user are banned too.
+func (p *Policy) Validate(password string, personal ...string) []Violation {
+	violations := make([]Violation, 0)
+
+	if len([]rune(password)) < p.MinLength {
+		violations = append(violations, Violation{
+			TooShort,
+			fmt.Sprintf("The password must be at least %d characters long", p.MinLength),
+		})
+	}
+
+	if characterClasses(password) < p.MinCharacterClasses {
+		violations = append(violations, Violation{
+			TooSimple,
+			fmt.Sprintf(
+				"The password must contain characters from %d of the following classes: uppercase, lowercase, digits, symbols, other letters",
+				p.MinCharacterClasses,
+			),
+		})
+	}
+
+	lower := strings.ToLower(password)
+	banned := append([]string{}, p.BannedWords...)
+	for _, word := range personal {
+		// short words would ban too many passwords, Active Directory ignores
+		// them as well
+		if len([]rune(word)) >= 3 {
+			banned = append(banned, strings.ToLower(word))
+		}
+	}
+	for _, word := range banned {
+		if strings.Contains(lower, word) {
+			violations = append(violations, Violation{
+				Banned,
+				"The password must not contain common words or your personal information",
+			})
+			break
+		}
+	}
+
+	return violations
+}
+
+// Check the password of the user against the policy, including the password
+// history. userId is empty for the accounts not created yet.
+func Check(userId, password string, personal ...string) ([]Violation, error) {
+	policy := GetPolicy()
+
+	violations := policy.Validate(password, personal...)
+	if userId == "" {
+		return violations, nil
+	}
+
+	reused, err := users.PasswordReused(userId, password, policy.HistorySize)
+	if err != nil {
+		return nil, err
+	}
+
+	if reused {
+		violations = append(violations, Violation{
+			Reused,
+			fmt.Sprintf("The password must differ from the last %d passwords", policy.HistorySize),
+		})
+	}
+	return violations, nil
+}
+
+// Personal words of the user that can't be part of their password
+func PersonalWords(user *users.User) []string {
+	words := []string{user.FirstName, user.LastName}
+	i := strings.Index(user.Email, "@")
+	if i > 0 {
+		words = append(words, user.Email[:i])
+	}
+	return words
+}
+
+// Check whether the password of the user is older than the policy allows
+func Expired(userId string) (bool, error) {
+	policy := GetPolicy()
+	if policy.MaxAge == 0 {
+		return false, nil
+	}
+
+	changedAt, err := users.PasswordChangedAt(userId)
+	if err != nil {
+		return false, err
+	}
+
+	maxAge := time.Duration(policy.MaxAge) * 24 * time.Hour
+	return time.Since(changedAt) > maxAge, nil
+}
@@ -0,0 +1,132 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package passwords
+
+import (
+	"testing"
+)
+
+func rules(violations []Violation) []string {
+	r := make([]string, len(violations))
+	for i, violation := range violations {
+		r[i] = violation.Rule
+	}
+	return r
+}
+
+func TestCharacterClasses(t *testing.T) {
+	tests := map[string]int{
+		"":          0,
+		"abc":       1,
+		"abcDEF":    2,
+		"abcDEF123": 3,
+		"abcDEF12+": 4,
+		"aB1+é":     4,
+		"aB1+ステ":    5,
+	}
+
+	for password, expected := range tests {
+		classes := characterClasses(password)
+		if classes != expected {
+			t.Errorf("%q: expected %d classes, got %d", password, expected, classes)
+		}
+	}
+}
+
+func TestActiveDirectory(t *testing.T) {
+	if len(ActiveDirectory.Validate("Nanocloud123+")) != 0 {
+		t.Error("Nanocloud123+ should satisfy the Active Directory rules")
+	}
+
+	if len(ActiveDirectory.Validate("nanocloud")) != 1 {
+		t.Error("nanocloud should be too simple")
+	}
+
+	if len(ActiveDirectory.Validate("aB1+")) != 1 {
+		t.Error("aB1+ should be too short")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	policy := Policy{
+		MinLength:           10,
+		MinCharacterClasses: 3,
+		BannedWords:         []string{"nanocloud"},
+	}
+
+	violations := policy.Validate("Xk9+mq2Lp4")
+	if len(violations) != 0 {
+		t.Errorf("expected no violation, got %v", rules(violations))
+	}
+
+	violations = policy.Validate("nano")
+	if len(violations) != 2 || violations[0].Rule != TooShort || violations[1].Rule != TooSimple {
+		t.Errorf("expected too-short and too-simple, got %v", rules(violations))
+	}
+
+	violations = policy.Validate("MyNanoCloud1")
+	if len(violations) != 1 || violations[0].Rule != Banned {
+		t.Errorf("expected banned, got %v", rules(violations))
+	}
+
+	violations = policy.Validate("Johnathan2016", "Johnathan", "Doe")
+	if len(violations) != 1 || violations[0].Rule != Banned {
+		t.Errorf("expected banned, got %v", rules(violations))
+	}
+
+	// words shorter than 3 characters aren't personal enough to be banned
+	violations = policy.Validate("Xk9+mq2Lp4", "Xk")
+	if len(violations) != 0 {
+		t.Errorf("expected no violation, got %v", rules(violations))
+	}
+
+	if len(policy.BannedWords) != 1 {
+		t.Error("Validate must not change the banned words of the policy")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	policy := Policy{
+		MinLength:   4,
+		HistorySize: 100,
+		MaxAge:      -1,
+		BannedWords: []string{" Password ", "", "a,b"},
+	}
+	policy.normalize()
+
+	if policy.MinLength != ActiveDirectory.MinLength {
+		t.Errorf("min length should be raised to %d, got %d", ActiveDirectory.MinLength, policy.MinLength)
+	}
+	if policy.MinCharacterClasses != ActiveDirectory.MinCharacterClasses {
+		t.Errorf("min character classes should be raised to %d, got %d", ActiveDirectory.MinCharacterClasses, policy.MinCharacterClasses)
+	}
+	if policy.HistorySize != 25 {
+		t.Errorf("history size should be capped to 25, got %d", policy.HistorySize)
+	}
+	if policy.MaxAge != 0 {
+		t.Errorf("max age should be 0, got %d", policy.MaxAge)
+	}
+	if len(policy.BannedWords) != 1 || policy.BannedWords[0] != "password" {
+		t.Errorf("unexpected banned words %v", policy.BannedWords)
+	}
+}
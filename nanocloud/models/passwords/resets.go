@@ -0,0 +1,136 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+)
+
+const ResetTokenTTL = time.Hour
+
+var ResetNotFound = errors.New("password reset not found")
+
+// Only the hash of the tokens is stored, like for the recovery codes
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue a single-use token to reset the password of the user. The previous
+// tokens of the user are revoked.
+func CreateReset(userId string) (string, error) {
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(random)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM password_resets
+		WHERE user_id = $1::varchar
+		OR expires_at < NOW()`,
+		userId,
+	)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO password_resets
+		(token_hash, user_id, expires_at)
+		VALUES ($1::varchar, $2::varchar, NOW() + $3::integer * interval '1 second')`,
+		hashToken(token), userId, int(ResetTokenTTL.Seconds()),
+	)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	return token, tx.Commit()
+}
+
+// Return the id of the user the token has been issued for
+func GetResetUser(token string) (string, error) {
+	rows, err := db.Query(
+		`SELECT user_id
+		FROM password_resets
+		WHERE token_hash = $1::varchar
+		AND expires_at > NOW()`,
+		hashToken(token),
+	)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return "", ResetNotFound
+	}
+
+	var userId string
+	err = rows.Scan(&userId)
+	return userId, err
+}
+
+// Delete the token and return the id of the user it has been issued for. The
+// token can't be used afterwards.
+func ConsumeReset(token string) (string, error) {
+	rows, err := db.Query(
+		`DELETE FROM password_resets
+		WHERE token_hash = $1::varchar
+		RETURNING user_id, expires_at > NOW()`,
+		hashToken(token),
+	)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return "", ResetNotFound
+	}
+
+	var userId string
+	var valid bool
+	err = rows.Scan(&userId, &valid)
+	if err != nil {
+		return "", err
+	}
+	if !valid {
+		return "", ResetNotFound
+	}
+	return userId, nil
+}
@@ -0,0 +1,242 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package registrations
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/config"
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+)
+
+// Config keys set to "true" when anyone can sign up and when the accounts
+// must be approved by an administrator once their email is verified
+const (
+	EnabledKey          = "SIGNUP_ENABLED"
+	ApprovalRequiredKey = "SIGNUP_APPROVAL_REQUIRED"
+)
+
+// Time given to the users to verify their email, the registration and the
+// deactivated user are deleted afterwards.
+const VerificationTTL = 24 * time.Hour
+
+var RegistrationNotFound = errors.New("registration not found")
+
+// A user who signed up and whose account isn't activated yet
+type Registration struct {
+	Id            string    `json:"-"`
+	Email         string    `json:"email"`
+	FirstName     string    `json:"first-name"`
+	LastName      string    `json:"last-name"`
+	EmailVerified bool      `json:"email-verified"`
+	CreatedAt     time.Time `json:"created-at"`
+}
+
+func (r *Registration) GetID() string {
+	return r.Id
+}
+
+func (r *Registration) SetID(id string) error {
+	r.Id = id
+	return nil
+}
+
+type Policy struct {
+	Enabled          bool `json:"enabled"`
+	ApprovalRequired bool `json:"approval-required"`
+}
+
+func (p *Policy) GetID() string {
+	return "registration-policy"
+}
+
+func (p *Policy) SetID(id string) error {
+	return nil
+}
+
+func GetPolicy() *Policy {
+	values := config.Get(EnabledKey, ApprovalRequiredKey)
+	return &Policy{
+		Enabled:          values[EnabledKey] == "true",
+		ApprovalRequired: values[ApprovalRequiredKey] == "true",
+	}
+}
+
+func setFlag(key string, value bool) {
+	if value {
+		config.Set(key, "true")
+	} else {
+		config.Unset(key)
+	}
+}
+
+func SetPolicy(policy *Policy) {
+	setFlag(EnabledKey, policy.Enabled)
+	setFlag(ApprovalRequiredKey, policy.ApprovalRequired)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Register the deactivated user and return the token verifying their email
+func Create(userId string) (string, error) {
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(random)
+
+	_, err = db.Exec(
+		`INSERT INTO registrations
+		(user_id, token_hash, expires_at)
+		VALUES ($1::varchar, $2::varchar, NOW() + $3::integer * interval '1 second')`,
+		userId, hashToken(token), int(VerificationTTL.Seconds()),
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Delete the users who didn't verify their email in time, so that their
+// address can be used to sign up again.
+func DeleteExpired() error {
+	_, err := db.Exec(
+		`DELETE FROM users
+		WHERE id IN (
+			SELECT user_id
+			FROM registrations
+			WHERE email_verified = false
+			AND expires_at < NOW()
+		)`,
+	)
+	return err
+}
+
+func find(where string, args ...interface{}) ([]*Registration, error) {
+	rows, err := db.Query(
+		`SELECT u.id, u.email, u.first_name, u.last_name,
+		r.email_verified, r.created_at
+		FROM registrations r
+		JOIN users u ON u.id = r.user_id
+		WHERE `+where+`
+		ORDER BY r.created_at`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	registrations := make([]*Registration, 0)
+	for rows.Next() {
+		registration := Registration{}
+		err = rows.Scan(
+			&registration.Id, &registration.Email,
+			&registration.FirstName, &registration.LastName,
+			&registration.EmailVerified, &registration.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		registrations = append(registrations, &registration)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return registrations, nil
+}
+
+func Get(userId string) (*Registration, error) {
+	registrations, err := find("r.user_id = $1::varchar", userId)
+	if err != nil {
+		return nil, err
+	}
+	if len(registrations) == 0 {
+		return nil, RegistrationNotFound
+	}
+	return registrations[0], nil
+}
+
+// Return the registrations waiting for the approval of an administrator
+func FindPending() ([]*Registration, error) {
+	return find("r.email_verified = true")
+}
+
+// Mark the email of the registration the token has been sent for as
+// verified. The token can't be used afterwards.
+func Verify(token string) (*Registration, error) {
+	rows, err := db.Query(
+		`UPDATE registrations
+		SET email_verified = true, token_hash = NULL
+		WHERE token_hash = $1::varchar
+		AND expires_at > NOW()
+		RETURNING user_id`,
+		hashToken(token),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, RegistrationNotFound
+	}
+
+	var userId string
+	err = rows.Scan(&userId)
+	if err != nil {
+		return nil, err
+	}
+	return Get(userId)
+}
+
+// Delete the registration once the user is activated
+func Delete(userId string) error {
+	res, err := db.Exec(
+		`DELETE FROM registrations
+		WHERE user_id = $1::varchar`,
+		userId,
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return RegistrationNotFound
+	}
+	return nil
+}
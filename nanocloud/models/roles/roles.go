@@ -0,0 +1,428 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package roles grants administration permissions to the users who aren't
+// administrators. The permissions have the names of the OAuth scopes of the
+// service accounts, administrators hold all of them.
+package roles
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	RoleNotFound      = errors.New("role not found")
+	RoleDuplicated    = errors.New("role duplicated")
+	RoleBuiltin       = errors.New("built-in roles can't be modified")
+	UnknownPermission = errors.New("unknown permission")
+)
+
+// The permissions the routes require, with what they allow
+var Permissions = map[string]string{
+	"apps:read":       "List every application and their grants",
+	"audit:read":      "Read the audit log and the requests made while impersonating users",
+	"apps:write":      "Publish, rename and unpublish applications, grant them",
+	"groups:read":     "List the groups and their members",
+	"groups:write":    "Manage the groups and their members",
+	"jobs:read":       "List the provisioning jobs",
+	"jobs:write":      "Retry the provisioning jobs and reconcile Active Directory",
+	"machines:read":   "List the machines and their drivers",
+	"machines:write":  "Create, update and delete machines",
+	"passwords:reset": "Set the password of the users",
+	"policies:read":   "Read the password, MFA and registration policies",
+	"policies:write":  "Update the password, MFA and registration policies",
+	"roles:read":      "List the roles and their holders",
+	"roles:write":     "Manage the roles and grant them",
+	"sessions:logoff": "Close the sessions of the users",
+	"users:read":      "List the users, the registrations, the imports and the lockouts",
+	"users:write":     "Create, update and delete users, approve registrations, unlock accounts",
+}
+
+type Role struct {
+	Id          string    `json:"-"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	Builtin     bool      `json:"builtin"`
+	CreatedAt   time.Time `json:"created-at"`
+}
+
+func (r *Role) GetID() string {
+	return r.Id
+}
+
+func (r *Role) SetID(id string) error {
+	r.Id = id
+	return nil
+}
+
+// Check that the permissions are known and sort them
+func (r *Role) normalize() error {
+	if r.Permissions == nil {
+		r.Permissions = []string{}
+	}
+	for _, permission := range r.Permissions {
+		if _, exists := Permissions[permission]; !exists {
+			return UnknownPermission
+		}
+	}
+	sort.Strings(r.Permissions)
+	return nil
+}
+
+func isDuplicate(err error) bool {
+	return err != nil && err.Error() == "pq: duplicate key value violates unique constraint \"roles_name_key\""
+}
+
+func scanRoles(rows *sql.Rows) ([]*Role, error) {
+	defer rows.Close()
+
+	roles := make([]*Role, 0)
+	byId := make(map[string]*Role)
+	for rows.Next() {
+		var permission sql.NullString
+		role := Role{Permissions: []string{}}
+		err := rows.Scan(&role.Id, &role.Name, &role.Description, &role.Builtin, &role.CreatedAt, &permission)
+		if err != nil {
+			return nil, err
+		}
+
+		existing, exists := byId[role.Id]
+		if !exists {
+			existing = &role
+			byId[role.Id] = existing
+			roles = append(roles, existing)
+		}
+		if permission.Valid {
+			existing.Permissions = append(existing.Permissions, permission.String)
+		}
+	}
+	return roles, rows.Err()
+}
+
+const selectRoles = `SELECT roles.id, roles.name, roles.description,
+	roles.builtin, roles.created_at, roles_permissions.permission
+	FROM roles
+	LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+	`
+
+func FindAll() ([]*Role, error) {
+	rows, err := db.Query(selectRoles + `ORDER BY roles.name, roles_permissions.permission`)
+	if err != nil {
+		return nil, err
+	}
+	return scanRoles(rows)
+}
+
+func Get(id string) (*Role, error) {
+	rows, err := db.Query(
+		selectRoles+`WHERE roles.id = $1::varchar
+		ORDER BY roles_permissions.permission`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := scanRoles(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return nil, RoleNotFound
+	}
+	return roles[0], nil
+}
+
+func setPermissions(tx *sql.Tx, role *Role) error {
+	_, err := tx.Exec(
+		`DELETE FROM roles_permissions
+		WHERE role_id = $1::varchar`,
+		role.Id,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, permission := range role.Permissions {
+		_, err = tx.Exec(
+			`INSERT INTO roles_permissions
+			(role_id, permission)
+			VALUES ($1::varchar, $2::varchar)
+			ON CONFLICT DO NOTHING`,
+			role.Id, permission,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Insert the role with its permissions. Used by the migration for the
+// built-in roles.
+func Create(role *Role) error {
+	err := role.normalize()
+	if err != nil {
+		return err
+	}
+	role.Id = uuid.NewV4().String()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		`INSERT INTO roles
+		(id, name, description, builtin)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, $4::boolean)
+		RETURNING created_at`,
+		role.Id, role.Name, role.Description, role.Builtin,
+	).Scan(&role.CreatedAt)
+	if isDuplicate(err) {
+		return RoleDuplicated
+	}
+	if err != nil {
+		return err
+	}
+
+	err = setPermissions(tx, role)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update the name, the description and the permissions of a role
+func Update(role *Role) error {
+	err := role.normalize()
+	if err != nil {
+		return err
+	}
+
+	current, err := Get(role.Id)
+	if err != nil {
+		return err
+	}
+	if current.Builtin {
+		return RoleBuiltin
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`UPDATE roles
+		SET name = $2::varchar, description = $3::varchar
+		WHERE id = $1::varchar`,
+		role.Id, role.Name, role.Description,
+	)
+	if isDuplicate(err) {
+		return RoleDuplicated
+	}
+	if err != nil {
+		return err
+	}
+
+	err = setPermissions(tx, role)
+	if err != nil {
+		return err
+	}
+
+	role.Builtin = false
+	role.CreatedAt = current.CreatedAt
+	return tx.Commit()
+}
+
+func Delete(id string) error {
+	role, err := Get(id)
+	if err != nil {
+		return err
+	}
+	if role.Builtin {
+		return RoleBuiltin
+	}
+
+	_, err = db.Exec("DELETE FROM roles WHERE id = $1::varchar", id)
+	return err
+}
+
+// Return the roles held by a user
+func UserRoles(userId string) ([]*Role, error) {
+	rows, err := db.Query(
+		selectRoles+`JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1::varchar
+		ORDER BY roles.name, roles_permissions.permission`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanRoles(rows)
+}
+
+func Assign(userId, roleId string) error {
+	_, err := Get(roleId)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO users_roles
+		(user_id, role_id)
+		VALUES ($1::varchar, $2::varchar)
+		ON CONFLICT DO NOTHING`,
+		userId, roleId,
+	)
+	return err
+}
+
+func Unassign(userId, roleId string) error {
+	res, err := db.Exec(
+		`DELETE FROM users_roles
+		WHERE user_id = $1::varchar
+		AND role_id = $2::varchar`,
+		userId, roleId,
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return RoleNotFound
+	}
+	return nil
+}
+
+// Whether the user holds the permission, administrators hold them all
+func Can(user *users.User, permission string) (bool, error) {
+	if user.IsAdmin {
+		return true, nil
+	}
+
+	rows, err := db.Query(
+		`SELECT 1
+		FROM users_roles
+		JOIN roles_permissions ON roles_permissions.role_id = users_roles.role_id
+		WHERE users_roles.user_id = $1::varchar
+		AND roles_permissions.permission = $2::varchar`,
+		user.Id, permission,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), nil
+}
+
+// Return the permissions held through the roles of the user
+func userPermissions(userId string) ([]string, error) {
+	rows, err := db.Query(
+		`SELECT DISTINCT roles_permissions.permission
+		FROM users_roles
+		JOIN roles_permissions ON roles_permissions.role_id = users_roles.role_id
+		WHERE users_roles.user_id = $1::varchar`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := make([]string, 0)
+	for rows.Next() {
+		var permission string
+		err = rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+// Whether every permission of target is in held
+func covers(held, target []string) bool {
+	set := make(map[string]bool, len(held))
+	for _, permission := range held {
+		set[permission] = true
+	}
+	for _, permission := range target {
+		if !set[permission] {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether the user holds every one of permissions, so that they can grant
+// them through a role
+func Holds(user *users.User, permissions []string) (bool, error) {
+	if user.IsAdmin {
+		return true, nil
+	}
+
+	held, err := userPermissions(user.Id)
+	if err != nil {
+		return false, err
+	}
+	return covers(held, permissions), nil
+}
+
+// Whether the user can act on the account of target. Administrators are only
+// managed by administrators, and a user can't manage an account holding
+// permissions they lack: taking it over would grant them.
+func CanManage(user, target *users.User) (bool, error) {
+	if user.IsAdmin {
+		return true, nil
+	}
+	if target.IsAdmin {
+		return false, nil
+	}
+
+	held, err := userPermissions(user.Id)
+	if err != nil {
+		return false, err
+	}
+	required, err := userPermissions(target.Id)
+	if err != nil {
+		return false, err
+	}
+	return covers(held, required), nil
+}
@@ -0,0 +1,68 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package roles
+
+import (
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	role := Role{Permissions: []string{"sessions:logoff", "passwords:reset", "users:read"}}
+	err := role.normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"passwords:reset", "sessions:logoff", "users:read"}
+	for i, permission := range expected {
+		if role.Permissions[i] != permission {
+			t.Errorf("the permissions should be sorted, got %v", role.Permissions)
+			break
+		}
+	}
+
+	role = Role{}
+	err = role.normalize()
+	if err != nil || role.Permissions == nil || len(role.Permissions) != 0 {
+		t.Error("a role without permissions should have an empty list")
+	}
+
+	role = Role{Permissions: []string{"apps:write", "machines:destroy"}}
+	err = role.normalize()
+	if err != UnknownPermission {
+		t.Errorf("expected UnknownPermission, got %v", err)
+	}
+}
+
+func TestCovers(t *testing.T) {
+	helpdesk := []string{"passwords:reset", "sessions:logoff", "users:read"}
+
+	if !covers(helpdesk, []string{"users:read"}) || !covers(helpdesk, nil) {
+		t.Error("the permissions held should cover their subsets")
+	}
+	if covers(helpdesk, []string{"users:read", "roles:write"}) {
+		t.Error("a permission that isn't held shouldn't be covered")
+	}
+	if covers(nil, []string{"users:read"}) {
+		t.Error("no permission covers nothing but users without permissions")
+	}
+}
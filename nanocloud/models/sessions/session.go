@@ -6,6 +6,7 @@ type Session struct {
 	Username    string `json:"username"`
 	State       string `json:"state"`
 	UserId      string `json:"user-id"`
+	Server      string `json:"server"`
 }
 
 func (h *Session) GetID() string {
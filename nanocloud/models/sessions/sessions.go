@@ -2,27 +2,64 @@ package sessions
 
 import (
 	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/connectors/vms"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
-	"io/ioutil"
-	"net/http"
 )
 
-var kServer string
 var kPort string
 
+// A plaza that stops answering must not hang the requests querying it
+var kClient *http.Client
+
+var NoServerAvailable = errors.New("No execution server available")
+
 type hash map[string]interface{}
 
-func GetAll(userSam string) ([]Session, error) {
+// The errors met on each execution server that couldn't be reached or
+// refused a request. The other servers are still processed.
+type ServerErrors map[string]error
 
-	var sessionList []Session
+func (e ServerErrors) Error() string {
+	servers := make([]string, 0, len(e))
+	for server := range e {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
 
-	resp, err := http.Get("http://" + kServer + ":" + kPort + "/sessions/" + userSam)
+	messages := make([]string, len(servers))
+	for i, server := range servers {
+		messages[i] = server + ": " + e[server].Error()
+	}
+	return "Unable to reach every execution server (" + strings.Join(messages, ", ") + ")"
+}
+
+func (e ServerErrors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
 
+func getSessions(server string, userSam string) ([][]string, error) {
+	resp, err := kClient.Get("http://" + server + ":" + kPort + "/sessions/" + userSam)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Unable to retrieve sessions: " + resp.Status)
+	}
 
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -38,44 +75,141 @@ func GetAll(userSam string) ([]Session, error) {
 	if err != nil {
 		return nil, err
 	}
+	return body.Data, nil
+}
 
-	for _, tab := range body.Data {
+// Return the sessions of a user opened on any of the execution servers.
+// Servers that can't be reached are reported in a ServerErrors along with
+// the sessions found on the others.
+func GetAll(userSam string) ([]Session, error) {
 
-		rows, err := db.Query(
-			`SELECT users.id FROM users
-			left join users_windows_user on users.id = users_windows_user.user_id
-			left join windows_users on users_windows_user.windows_user_id = windows_users.id
-			WHERE windows_users.sam = $1::varchar`,
-			tab[1])
+	var sessionList []Session
+
+	servers, err := vms.ExecutionServers()
+	if err != nil {
+		return nil, err
+	}
 
+	failures := make(ServerErrors)
+	for _, server := range servers {
+		data, err := getSessions(server, userSam)
 		if err != nil {
-			return nil, err
+			failures[server] = err
+			continue
 		}
 
-		defer rows.Close()
-		var user_id string
-		if rows.Next() {
-			err = rows.Scan(
-				&user_id,
-			)
+		for _, tab := range data {
+
+			rows, err := db.Query(
+				`SELECT users.id FROM users
+				left join users_windows_user on users.id = users_windows_user.user_id
+				left join windows_users on users_windows_user.windows_user_id = windows_users.id
+				WHERE windows_users.sam = $1::varchar`,
+				tab[1])
 
 			if err != nil {
 				return nil, err
 			}
 
-			var session Session
-			session.SessionName = tab[0]
-			session.Username = tab[1]
-			session.Id = tab[2]
-			session.State = tab[3]
-			session.UserId = user_id
-			sessionList = append(sessionList, session)
+			var user_id string
+			if rows.Next() {
+				err = rows.Scan(
+					&user_id,
+				)
+
+				if err != nil {
+					rows.Close()
+					return nil, err
+				}
+
+				var session Session
+				session.SessionName = tab[0]
+				session.Username = tab[1]
+				session.Id = tab[2]
+				session.State = tab[3]
+				session.UserId = user_id
+				session.Server = server
+				sessionList = append(sessionList, session)
+			}
+			rows.Close()
 		}
 	}
-	return sessionList, nil
+	return sessionList, failures.orNil()
+}
+
+// Count the sessions opened on the specified execution server.
+// Plaza lists every session when asked for the Administrator ones.
+func Count(server string) (int, error) {
+	data, err := getSessions(server, "Administrator")
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Return the execution server hosting a session of the user. If the user has
+// no session opened, the first available server is returned.
+func FindServer(userSam string) (string, error) {
+	servers, err := vms.ExecutionServers()
+	if err != nil {
+		return "", err
+	}
+
+	if len(servers) == 0 {
+		return "", NoServerAvailable
+	}
+
+	for _, server := range servers {
+		data, err := getSessions(server, userSam)
+		if err != nil {
+			log.Errorf("Unable to retrieve the sessions of %s: %s", server, err)
+			continue
+		}
+
+		if len(data) > 0 {
+			return server, nil
+		}
+	}
+	return servers[0], nil
+}
+
+// Close the sessions of a user on every execution server. The servers that
+// failed are reported in a ServerErrors once all of them have been tried.
+func Logoff(userSam string) error {
+	servers, err := vms.ExecutionServers()
+	if err != nil {
+		return err
+	}
+
+	failures := make(ServerErrors)
+	for _, server := range servers {
+		req, err := http.NewRequest("DELETE", "http://"+server+":"+kPort+"/sessions/"+userSam, nil)
+		if err != nil {
+			failures[server] = err
+			continue
+		}
+
+		resp, err := kClient.Do(req)
+		if err != nil {
+			failures[server] = err
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			failures[server] = errors.New("Unable to close the sessions: " + resp.Status)
+		}
+	}
+	return failures.orNil()
 }
 
 func init() {
-	kServer = utils.Env("EXECUTION_SERVERS", "iaas-module")
 	kPort = utils.Env("PLAZA_PORT", "9090")
+
+	timeout, err := strconv.Atoi(utils.Env("PLAZA_TIMEOUT", "5"))
+	if err != nil || timeout <= 0 {
+		log.Error("PLAZA_TIMEOUT must be a positive number of seconds, using 5")
+		timeout = 5
+	}
+	kClient = &http.Client{Timeout: time.Duration(timeout) * time.Second}
 }
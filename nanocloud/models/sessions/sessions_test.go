@@ -0,0 +1,43 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package sessions
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestServerErrors(t *testing.T) {
+	if (ServerErrors{}).orNil() != nil {
+		t.Error("no failure should report no error")
+	}
+
+	err := ServerErrors{
+		"10.0.0.2": errors.New("timeout"),
+		"10.0.0.1": errors.New("connection refused"),
+	}.orNil()
+
+	expected := "Unable to reach every execution server (10.0.0.1: connection refused, 10.0.0.2: timeout)"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+}
@@ -2,14 +2,27 @@ package users
 
 import (
 	"errors"
+	"time"
 
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/secrets"
 )
 
 type WindowsUser struct {
 	Sam      string
 	Password string
 	Domain   string
+	// When the ephemeral password stops being handed out, in the past if
+	// none has been issued
+	PasswordExpiresAt time.Time
+}
+
+// A user and the sAMAccountName of its Windows account, empty if it has none
+type WindowsLink struct {
+	UserId    string
+	Email     string
+	Activated bool
+	Sam       string
 }
 
 type User struct {
@@ -32,12 +45,27 @@ func (u *User) SetID(id string) error {
 	return nil
 }
 
+// Check that the fields required to create the user are set
+func (u *User) Validate() error {
+	if u.Email == "" {
+		return EmailMissing
+	}
+	if u.FirstName == "" {
+		return FirstNameMissing
+	}
+	if u.LastName == "" {
+		return LastNameMissing
+	}
+	return nil
+}
+
 func (u *User) WindowsCredentials() (*WindowsUser, error) {
 	res, err := db.Query(
 		`SELECT
 			windows_users.sam,
 			windows_users.password,
-			windows_users.domain
+			windows_users.domain,
+			COALESCE(windows_users.password_expires_at, to_timestamp(0))
 		FROM users_windows_user
 		LEFT JOIN
 			windows_users
@@ -59,6 +87,12 @@ func (u *User) WindowsCredentials() (*WindowsUser, error) {
 		&winUser.Sam,
 		&winUser.Password,
 		&winUser.Domain,
+		&winUser.PasswordExpiresAt,
 	)
+
+	winUser.Password, err = secrets.Decrypt(winUser.Password)
+	if err != nil {
+		return nil, err
+	}
 	return &winUser, nil
 }
@@ -23,9 +23,13 @@
 package users
 
 import (
+	"database/sql"
 	errors "errors"
+	"time"
 
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/secrets"
+	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
 	uuid "github.com/satori/go.uuid"
 	"golang.org/x/crypto/bcrypt"
@@ -37,6 +41,12 @@ var (
 	UserDisabled       = errors.New("user disabled")
 	UserDuplicated     = errors.New("user duplicated")
 	UserNotCreated     = errors.New("user not created")
+	PasswordExpired    = errors.New("password expired")
+	EmailMissing       = errors.New("email is missing")
+	FirstNameMissing   = errors.New("first-name is missing")
+	LastNameMissing    = errors.New("last-name is missing")
+	NotFromDirectory   = errors.New("the account isn't provisioned from the directory")
+	NotFromSAML        = errors.New("the account isn't provisioned by SAML")
 )
 
 func GetUserFromEmailPassword(email, password string) (*User, error) {
@@ -84,6 +94,183 @@ func GetUserFromEmailPassword(email, password string) (*User, error) {
 	return &user, nil
 }
 
+// Return the user with the specified email, nil if there is none.
+func GetUserFromEmail(email string) (*User, error) {
+	rows, err := db.Query(
+		`SELECT id
+		FROM users
+		WHERE email = $1::varchar`,
+		email,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, nil
+	}
+
+	var id string
+	err = rows.Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return GetUser(id)
+}
+
+// Return the user bound to the NameID of a SAML identity provider, creating
+// it if no account uses the email yet. The password of the created users is
+// never used. As with ProvisionLDAPUser, the other accounts and the
+// administrators are never matched: NotFromSAML is returned instead.
+func ProvisionSAMLUser(issuer, nameID, email, firstName, lastName string) (*User, error) {
+	rows, err := db.Query(
+		`SELECT id
+		FROM users
+		WHERE saml_issuer = $1::varchar
+		AND saml_name_id = $2::varchar`,
+		issuer, nameID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		user, err := GetUser(id)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil || user.IsAdmin {
+			return nil, NotFromSAML
+		}
+		return user, nil
+	}
+
+	user, err := GetUserFromEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		return nil, NotFromSAML
+	}
+
+	user, err = CreateUser(true, email, firstName, lastName, utils.RandomString(32), false)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(
+		`UPDATE users
+		SET saml_issuer = $1::varchar, saml_name_id = $2::varchar
+		WHERE id = $3::varchar`,
+		issuer, nameID, user.Id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("User %s provisioned from %s", email, issuer)
+	return user, nil
+}
+
+// Return the user provisioned from the directory entry dn, creating it if
+// no account uses the email yet. The accounts created locally, the ones
+// provisioned by another identity provider and the administrators are never
+// matched, so that a directory entry with their email can't take them over:
+// NotFromDirectory is returned instead.
+func ProvisionLDAPUser(dn, email, firstName, lastName string) (*User, error) {
+	rows, err := db.Query(
+		`SELECT id
+		FROM users
+		WHERE lower(ldap_dn) = lower($1::varchar)`,
+		dn,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		user, err := GetUser(id)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil || user.IsAdmin {
+			return nil, NotFromDirectory
+		}
+		return user, nil
+	}
+
+	user, err := GetUserFromEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		return nil, NotFromDirectory
+	}
+
+	user, err = CreateUser(true, email, firstName, lastName, utils.RandomString(32), false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = SetLDAPDN(user.Id, dn)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("User %s provisioned from %s", email, dn)
+	return user, nil
+}
+
+// Whether the user is provisioned from the directory, its Windows account
+// being its directory entry
+func IsFromDirectory(id string) (bool, error) {
+	rows, err := db.Query(
+		`SELECT ldap_dn <> ''
+		FROM users
+		WHERE id = $1::varchar`,
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	var fromDirectory bool
+	if rows.Next() {
+		err = rows.Scan(&fromDirectory)
+		if err != nil {
+			return false, err
+		}
+	}
+	return fromDirectory, nil
+}
+
+// Record the directory entry a user is provisioned from
+func SetLDAPDN(id, dn string) error {
+	_, err := db.Exec(
+		`UPDATE users
+		SET ldap_dn = $1::varchar
+		WHERE id = $2::varchar`,
+		dn, id,
+	)
+	return err
+}
+
 func FindUsers() ([]*User, error) {
 	rows, err := db.Query(
 		`SELECT id, first_name, last_name, email,
@@ -122,6 +309,41 @@ func FindUsers() ([]*User, error) {
 	return users, nil
 }
 
+// Return every user with the Windows account linked to it
+func FindWindowsLinks() ([]*WindowsLink, error) {
+	rows, err := db.Query(
+		`SELECT users.id, users.email, users.activated,
+		COALESCE(windows_users.sam, '')
+		FROM users
+		LEFT JOIN users_windows_user
+			ON users_windows_user.user_id = users.id
+		LEFT JOIN windows_users
+			ON windows_users.id = users_windows_user.windows_user_id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]*WindowsLink, 0)
+	for rows.Next() {
+		link := WindowsLink{}
+		var activated sql.NullBool
+		err = rows.Scan(&link.UserId, &link.Email, &activated, &link.Sam)
+		if err != nil {
+			return nil, err
+		}
+		link.Activated = activated.Bool
+		links = append(links, &link)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
 func UserExists(id string) (bool, error) {
 	rows, err := db.Query(
 		`SELECT id
@@ -151,6 +373,26 @@ func DisableUser(id string) error {
 	return err
 }
 
+func ActivateUser(id string) error {
+	res, err := db.Exec(
+		`UPDATE users
+		SET activated = true
+		WHERE id = $1::varchar`,
+		id)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return UserNotFound
+	}
+	return nil
+}
+
 func CreateUser(
 	activated bool,
 	email string,
@@ -211,6 +453,11 @@ func CreateUser(
 }
 
 func UpdateUserAd(userID, sam, password, domain string) error {
+	password, err := secrets.Encrypt(password)
+	if err != nil {
+		return err
+	}
+
 	res, err := db.Query(
 		`INSERT INTO windows_users
 		(sam, password, domain)
@@ -232,6 +479,10 @@ func UpdateUserAd(userID, sam, password, domain string) error {
 	winUserID := 0
 	res.Scan(&winUserID)
 
+	return linkWindowsUser(userID, winUserID)
+}
+
+func linkWindowsUser(userID string, winUserID int) error {
 	insert, err := db.Exec(
 		`INSERT INTO users_windows_user
 		(user_id, windows_user_id)
@@ -255,6 +506,49 @@ func UpdateUserAd(userID, sam, password, domain string) error {
 	return nil
 }
 
+// Associate the user with the windows account sam@domain. The windows account
+// is registered if it doesn't exist yet, its password is updated otherwise.
+func LinkWindowsUser(userID, sam, password, domain string) error {
+	rows, err := db.Query(
+		`SELECT id
+		FROM windows_users
+		WHERE sam = $1::varchar
+		AND domain = $2::varchar`,
+		sam, domain,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return UpdateUserAd(userID, sam, password, domain)
+	}
+
+	winUserID := 0
+	err = rows.Scan(&winUserID)
+	if err != nil {
+		return err
+	}
+
+	password, err = secrets.Encrypt(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`UPDATE windows_users
+		SET password = $1::varchar
+		WHERE id = $2::integer`,
+		password, winUserID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return linkWindowsUser(userID, winUserID)
+}
+
 func DeleteUser(id string) error {
 	res, err := db.Exec("DELETE FROM users WHERE id = $1::varchar", id)
 	if err != nil {
@@ -270,29 +564,191 @@ func DeleteUser(id string) error {
 	return nil
 }
 
+// Number of previous passwords kept for each user, the same limit as the
+// Active Directory password history
+const PasswordHistoryMaxSize = 24
+
 func UpdateUserPassword(id string, password string) error {
 	pass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
 
-	res, err := db.Exec(
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO users_password_history
+		(user_id, password, created_at)
+		SELECT id, password, password_changed_at
+		FROM users
+		WHERE id = $1::varchar
+		AND password <> ''`,
+		id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	res, err := tx.Exec(
 		`UPDATE users
-		SET password = $1::varchar
+		SET password = $1::varchar,
+		password_changed_at = NOW()
 		WHERE id = $2::varchar`,
 		pass, id)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
 	updated, err := res.RowsAffected()
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 	if updated == 0 {
+		tx.Rollback()
 		return UserNotFound
 	}
-	return nil
+
+	_, err = tx.Exec(
+		`DELETE FROM users_password_history
+		WHERE user_id = $1::varchar
+		AND ctid NOT IN (
+			SELECT ctid FROM users_password_history
+			WHERE user_id = $1::varchar
+			ORDER BY created_at DESC
+			LIMIT $2::integer
+		)`,
+		id, PasswordHistoryMaxSize)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Check whether the password is the current password of the user or one of
+// the count - 1 previous ones.
+func PasswordReused(id string, password string, count int) (bool, error) {
+	if count < 1 {
+		return false, nil
+	}
+
+	rows, err := db.Query(
+		`(SELECT password
+			FROM users
+			WHERE id = $1::varchar)
+		UNION ALL
+		(SELECT password
+			FROM users_password_history
+			WHERE user_id = $1::varchar
+			ORDER BY created_at DESC
+			LIMIT $2::integer)`,
+		id, count-1)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		err = rows.Scan(&hash)
+		if err != nil {
+			return false, err
+		}
+
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// Return when the password of the user has been set for the last time
+func PasswordChangedAt(id string) (time.Time, error) {
+	rows, err := db.Query(
+		`SELECT password_changed_at
+		FROM users
+		WHERE id = $1::varchar`,
+		id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return time.Time{}, UserNotFound
+	}
+
+	var changedAt time.Time
+	err = rows.Scan(&changedAt)
+	return changedAt, err
+}
+
+// Update the password Nanocloud uses to open the Windows sessions of the user
+func UpdateWindowsPassword(id string, password string) error {
+	return updateWindowsPassword(id, password, 0)
+}
+
+// Update the Windows password of the user with one handed out for ttl only
+func UpdateEphemeralWindowsPassword(id string, password string, ttl time.Duration) error {
+	return updateWindowsPassword(id, password, ttl)
+}
+
+func updateWindowsPassword(id string, password string, ttl time.Duration) error {
+	password, err := secrets.Encrypt(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`UPDATE windows_users
+		SET password = $1::varchar,
+		password_expires_at = CASE
+			WHEN $3::integer > 0 THEN NOW() + $3::integer * interval '1 second'
+			ELSE NULL
+		END
+		WHERE id IN (
+			SELECT windows_user_id
+			FROM users_windows_user
+			WHERE user_id = $2::varchar
+		)`,
+		password, id, int(ttl.Seconds()))
+	return err
+}
+
+// Return the users whose ephemeral Windows password has expired. The users
+// provisioned from the directory are left out, their password isn't ours.
+func FindExpiredWindowsPasswords() ([]string, error) {
+	rows, err := db.Query(
+		`SELECT users_windows_user.user_id
+		FROM users_windows_user
+		JOIN windows_users
+			ON windows_users.id = users_windows_user.windows_user_id
+		JOIN users
+			ON users.id = users_windows_user.user_id
+		WHERE windows_users.password_expires_at < NOW()
+		AND users.ldap_dn = ''`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
 
 func UpdateUserPrivilege(id string, rank bool) error {
@@ -1,11 +1,20 @@
 package oauth2
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"html/template"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -21,6 +30,26 @@ const (
 	SERVER_ERROR              = "server_error"
 	TEMPORARILY_UNAVAILABLE   = "temporarily_unavailable"
 	UNSUPPORTED_GRANT_TYPE    = "unsupported_grant_type"
+	MFA_REQUIRED              = "mfa_required"
+)
+
+// Returned by the connectors when a client requests a scope it isn't allowed
+// to get.
+var InvalidScope = errors.New("invalid scope")
+
+// Returned by AuthenticateUser when the credentials are valid but the password
+// must be changed first
+var PasswordExpired = errors.New("password expired")
+
+// Returned by CreateAuthorizationCode for the identities that can't be
+// delegated to a client, like the impersonation and service account tokens
+var IdentityNotAllowed = errors.New("identity not allowed")
+
+// Returned by CheckSecondFactor when the user must send a second factor code
+// and when the code is wrong.
+var (
+	SecondFactorRequired = errors.New("second factor required")
+	SecondFactorInvalid  = errors.New("invalid second factor")
 )
 
 type Connector interface {
@@ -28,7 +57,39 @@ type Connector interface {
 	GetUserFromAccessToken(accessToken string) (interface{}, error)
 	AuthenticateUser(username, password string) (interface{}, error)
 	GetAccessToken(interface{}, interface{}, *http.Request) (interface{}, error)
+	RefreshAccessToken(interface{}, string, *http.Request) (interface{}, error)
 	RevokeAccessToken(interface{}, interface{}, string) error
+	// Revoke a refresh token of the client and the tokens obtained from the
+	// same grant. Unknown tokens are ignored.
+	RevokeRefreshToken(client interface{}, refreshToken string) error
+
+	// Return the client only if redirectURI is registered for it
+	GetAuthorizationClient(key, redirectURI string) (interface{}, error)
+	// Return IdentityNotAllowed if the user is not a plain user
+	CreateAuthorizationCode(client, user interface{}, redirectURI, codeChallenge string) (string, error)
+	// Return the user, redirect URI and code challenge the code has been
+	// delivered for. The code can't be used afterwards.
+	ConsumeAuthorizationCode(client interface{}, code string) (interface{}, string, string, error)
+
+	// Return a token bound to the client itself, nil if the client isn't
+	// allowed to use the client_credentials grant
+	GetClientCredentialsToken(client interface{}, scopes []string, req *http.Request) (interface{}, error)
+
+	// Whether the client is allowed to introspect tokens
+	CanIntrospect(client interface{}) bool
+	// Return the description of an active access token as specified by
+	// RFC 7662, nil if the token is unknown or expired
+	IntrospectAccessToken(accessToken string) (interface{}, error)
+
+	// Check the second factor code sent with the password grant, if the user
+	// has one
+	CheckSecondFactor(user interface{}, code string) error
+
+	// Return how long password logins of the username are still refused from
+	// the request's origin, 0 if they are allowed
+	CheckLoginAttempt(username string, req *http.Request) (time.Duration, error)
+	// Record the outcome of a password login
+	RecordLoginAttempt(username string, req *http.Request, succeeded bool) error
 }
 
 var kConnector Connector
@@ -93,7 +154,10 @@ func GetUser(res http.ResponseWriter, req *http.Request) (interface{}, *OAuthErr
 	if err != nil {
 		return nil, err
 	}
+	return userFromAccessToken(accessToken)
+}
 
+func userFromAccessToken(accessToken string) (interface{}, *OAuthError) {
 	user, fail := kConnector.GetUserFromAccessToken(accessToken)
 	if fail != nil {
 		log.Error("[OAuth] Cannot retreive user form access token: " + fail.Error())
@@ -189,25 +253,27 @@ func revokeToken(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// token_type_hint
+	// token_type_hint, the token is looked up among the access tokens then
+	// the refresh tokens when it is omitted (RFC 7009)
 	tokenTypeHint := req.FormValue("token_type_hint")
-	if tokenTypeHint == "" {
-		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "token_type_hint is missing"})
+	if tokenTypeHint != "" && tokenTypeHint != "access_token" && tokenTypeHint != "refresh_token" {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "unsupported token type"})
 		return
 	}
 
-	if tokenTypeHint != "access_token" {
-		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "unsupported token type"})
+	token := req.FormValue("token")
+	if token == "" {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "token is missing"})
 		return
 	}
 
-	// access_token
-	accessToken := req.FormValue("token")
-	if accessToken == "" {
-		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "token is missing"})
+	if tokenTypeHint == "refresh_token" {
+		revokeRefreshToken(res, client, token)
 		return
 	}
 
+	// access_token
+	accessToken := token
 	user, fail := kConnector.GetUserFromAccessToken(accessToken)
 	if fail != nil {
 		log.Error("[OAuth] Cannot retreive user form access token: " + fail.Error())
@@ -215,6 +281,11 @@ func revokeToken(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if user == nil && tokenTypeHint == "" {
+		revokeRefreshToken(res, client, token)
+		return
+	}
+
 	if user != nil {
 		fail := kConnector.RevokeAccessToken(client, user, accessToken)
 		if fail != nil {
@@ -231,7 +302,26 @@ func revokeToken(res http.ResponseWriter, req *http.Request) {
 	return
 }
 
-func createToken(res http.ResponseWriter, req *http.Request) {
+// Refresh tokens that are unknown, expired or delivered to another client are
+// answered as revoked, as RFC 7009 requires.
+func revokeRefreshToken(res http.ResponseWriter, client interface{}, refreshToken string) {
+	err := kConnector.RevokeRefreshToken(client, refreshToken)
+	if err != nil {
+		log.Error("[OAuth] Cannot revoke refresh token: " + err.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+}
+
+func introspectToken(res http.ResponseWriter, req *http.Request) {
+	fail := req.ParseForm()
+	if fail != nil {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "Unable to parse the request body"})
+		return
+	}
+
 	client, err := clientBasicAuth(req)
 	if err != nil {
 		oauthErrorReply(res, *err)
@@ -243,60 +333,281 @@ func createToken(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	fail := req.ParseForm()
-
-	if fail != nil {
-		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "Unable to parse the request body"})
+	// only the resource servers can learn about the tokens of the others
+	if !kConnector.CanIntrospect(client) {
+		oauthErrorReply(res, OAuthError{http.StatusForbidden, UNAUTHORIZED_CLIENT, "This client is not allowed to introspect tokens"})
 		return
 	}
 
-	// grant_type
-	grantType := req.FormValue("grant_type")
-	if grantType == "" {
-		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "grant_type is missing"})
+	token := req.FormValue("token")
+	if token == "" {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "token is missing"})
 		return
 	}
 
-	if grantType != "password" {
-		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "Invalid grant_type"})
+	// Only access tokens can be introspected, any other token is reported
+	// as inactive.
+	var info interface{}
+	if hint := req.FormValue("token_type_hint"); hint == "" || hint == "access_token" {
+		info, fail = kConnector.IntrospectAccessToken(token)
+		if fail != nil {
+			log.Error("[OAuth] Cannot introspect access token: " + fail.Error())
+			oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
+			return
+		}
+	}
+
+	if info == nil {
+		info = map[string]bool{"active": false}
+	}
+
+	rt, fail := json.Marshal(info)
+	if fail != nil {
+		log.Error("[OAuth] Unable to serialize token introspection: " + fail.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
 		return
 	}
 
+	res.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	res.Write(rt)
+}
+
+func passwordGrant(res http.ResponseWriter, client interface{}, req *http.Request) (interface{}, *OAuthError) {
 	// username
 	username := req.FormValue("username")
 	if username == "" {
-		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "username is missing"})
-		return
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_REQUEST, "username is missing"}
 	}
 
 	// password
 	password := req.FormValue("password")
 	if password == "" {
-		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "password is missing"})
-		return
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_REQUEST, "password is missing"}
+	}
+
+	user, err := authenticateUser(res, req, username, password, req.FormValue("mfa_code"))
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, fail := kConnector.GetAccessToken(user, client, req)
+	if fail != nil {
+		log.Error("[OAuth] Cannot Get Access Token: " + fail.Error())
+		return nil, &OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"}
+	}
+
+	if accessToken == nil {
+		return nil, &OAuthError{http.StatusUnauthorized, ACCESS_DENIED, "Access token request denied for the given client"}
+	}
+
+	return accessToken, nil
+}
+
+/*
+ * Authenticate a user with the connector's authenticators, enforcing the
+ * login attempts throttling and the second factor. Used by the password grant
+ * and by the login form of the authorization endpoint.
+ */
+func authenticateUser(res http.ResponseWriter, req *http.Request, username, password, mfaCode string) (interface{}, *OAuthError) {
+	locked, fail := kConnector.CheckLoginAttempt(username, req)
+	if fail != nil {
+		log.Error("[OAuth] Cannot Check Login Attempt: " + fail.Error())
+		return nil, &OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"}
+	}
+
+	if locked > 0 {
+		retryAfter := int((locked + time.Second - 1) / time.Second)
+		res.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		return nil, &OAuthError{
+			http.StatusTooManyRequests,
+			ACCESS_DENIED,
+			fmt.Sprintf("Too many failed login attempts, retry in %d seconds", retryAfter),
+		}
 	}
 
 	user, fail := kConnector.AuthenticateUser(username, password)
 
 	if fail != nil {
 		if fail.Error() == "invalid credentials" || fail.Error() == "user not found" {
-			oauthErrorReply(res, OAuthError{http.StatusUnauthorized, ACCESS_DENIED, "Invalid User Credentials"})
-			return
+			recordLoginAttempt(username, req, false)
+			return nil, &OAuthError{http.StatusUnauthorized, ACCESS_DENIED, "Invalid User Credentials"}
+		}
+		if fail.Error() == "user disabled" {
+			return nil, &OAuthError{http.StatusUnauthorized, ACCESS_DENIED, "The account is not activated"}
+		}
+		if fail == PasswordExpired {
+			return nil, &OAuthError{http.StatusUnauthorized, ACCESS_DENIED, "The password has expired"}
 		}
 		log.Error("[OAuth] Cannot Authenticate User: " + fail.Error())
-		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
-		return
+		return nil, &OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"}
+	}
+
+	fail = kConnector.CheckSecondFactor(user, mfaCode)
+	switch fail {
+	case nil:
+	case SecondFactorRequired:
+		return nil, &OAuthError{http.StatusUnauthorized, MFA_REQUIRED, "A second factor code is required"}
+	case SecondFactorInvalid:
+		recordLoginAttempt(username, req, false)
+		return nil, &OAuthError{http.StatusUnauthorized, ACCESS_DENIED, "Invalid second factor code"}
+	default:
+		log.Error("[OAuth] Cannot Check Second Factor: " + fail.Error())
+		return nil, &OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"}
+	}
+
+	recordLoginAttempt(username, req, true)
+	return user, nil
+}
+
+// A login must not fail because its attempt couldn't be recorded
+func recordLoginAttempt(username string, req *http.Request, succeeded bool) {
+	err := kConnector.RecordLoginAttempt(username, req, succeeded)
+	if err != nil {
+		log.Error("[OAuth] Cannot Record Login Attempt: " + err.Error())
+	}
+}
+
+func refreshTokenGrant(client interface{}, req *http.Request) (interface{}, *OAuthError) {
+	refreshToken := req.FormValue("refresh_token")
+	if refreshToken == "" {
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_REQUEST, "refresh_token is missing"}
+	}
+
+	accessToken, fail := kConnector.RefreshAccessToken(client, refreshToken, req)
+	if fail != nil {
+		log.Error("[OAuth] Cannot Refresh Access Token: " + fail.Error())
+		return nil, &OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"}
+	}
+
+	if accessToken == nil {
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_GRANT, "Invalid refresh token"}
+	}
+
+	return accessToken, nil
+}
+
+func verifyCodeChallenge(codeChallenge, codeVerifier string) bool {
+	if len(codeVerifier) < 43 || len(codeVerifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(codeVerifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(codeChallenge)) == 1
+}
+
+func authorizationCodeGrant(client interface{}, req *http.Request) (interface{}, *OAuthError) {
+	code := req.FormValue("code")
+	if code == "" {
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_REQUEST, "code is missing"}
+	}
+
+	redirectURI := req.FormValue("redirect_uri")
+	if redirectURI == "" {
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_REQUEST, "redirect_uri is missing"}
+	}
+
+	codeVerifier := req.FormValue("code_verifier")
+	if codeVerifier == "" {
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_REQUEST, "code_verifier is missing"}
+	}
+
+	user, codeRedirectURI, codeChallenge, fail := kConnector.ConsumeAuthorizationCode(client, code)
+	if fail != nil {
+		log.Error("[OAuth] Cannot Consume Authorization Code: " + fail.Error())
+		return nil, &OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"}
+	}
+
+	if user == nil {
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_GRANT, "Invalid authorization code"}
+	}
+
+	if codeRedirectURI != redirectURI {
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_GRANT, "redirect_uri doesn't match the authorization request"}
+	}
+
+	if !verifyCodeChallenge(codeChallenge, codeVerifier) {
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_GRANT, "Invalid code_verifier"}
 	}
 
 	accessToken, fail := kConnector.GetAccessToken(user, client, req)
 	if fail != nil {
 		log.Error("[OAuth] Cannot Get Access Token: " + fail.Error())
-		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
-		return
+		return nil, &OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"}
+	}
+
+	if accessToken == nil {
+		return nil, &OAuthError{http.StatusUnauthorized, ACCESS_DENIED, "Access token request denied for the given client"}
+	}
+
+	return accessToken, nil
+}
+
+func clientCredentialsGrant(client interface{}, req *http.Request) (interface{}, *OAuthError) {
+	scopes := strings.Fields(req.FormValue("scope"))
+
+	accessToken, fail := kConnector.GetClientCredentialsToken(client, scopes, req)
+	if fail == InvalidScope {
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_SCOPE, "The requested scope exceeds the scopes of the client"}
+	}
+
+	if fail != nil {
+		log.Error("[OAuth] Cannot Get Client Credentials Token: " + fail.Error())
+		return nil, &OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"}
 	}
 
 	if accessToken == nil {
-		oauthErrorReply(res, OAuthError{http.StatusUnauthorized, ACCESS_DENIED, "Access token request denied for the given client"})
+		return nil, &OAuthError{http.StatusBadRequest, UNAUTHORIZED_CLIENT, "The client is not allowed to use the client_credentials grant"}
+	}
+
+	return accessToken, nil
+}
+
+func createToken(res http.ResponseWriter, req *http.Request) {
+	client, err := clientBasicAuth(req)
+	if err != nil {
+		oauthErrorReply(res, *err)
+		return
+	}
+
+	if client == nil {
+		oauthErrorReply(res, OAuthError{http.StatusUnauthorized, INVALID_CLIENT, "Invalid OAuth Client Credentials"})
+		return
+	}
+
+	fail := req.ParseForm()
+
+	if fail != nil {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "Unable to parse the request body"})
+		return
+	}
+
+	// grant_type
+	grantType := req.FormValue("grant_type")
+	if grantType == "" {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "grant_type is missing"})
+		return
+	}
+
+	var accessToken interface{}
+	var oauthErr *OAuthError
+
+	switch grantType {
+	case "password":
+		accessToken, oauthErr = passwordGrant(res, client, req)
+	case "refresh_token":
+		accessToken, oauthErr = refreshTokenGrant(client, req)
+	case "authorization_code":
+		accessToken, oauthErr = authorizationCodeGrant(client, req)
+	case "client_credentials":
+		accessToken, oauthErr = clientCredentialsGrant(client, req)
+	default:
+		oauthErr = &OAuthError{http.StatusBadRequest, UNSUPPORTED_GRANT_TYPE, "Invalid grant_type"}
+	}
+
+	if oauthErr != nil {
+		oauthErrorReply(res, *oauthErr)
 		return
 	}
 
@@ -313,10 +624,421 @@ func createToken(res http.ResponseWriter, req *http.Request) {
 	return
 }
 
+// Redirect the user agent back to the client with the given parameters added
+// to the redirect URI query.
+func authorizeRedirect(res http.ResponseWriter, req *http.Request, redirectURI string, params url.Values) {
+	location, fail := url.Parse(redirectURI)
+	if fail != nil {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "Invalid redirect_uri"})
+		return
+	}
+
+	query := location.Query()
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	location.RawQuery = query.Encode()
+
+	http.Redirect(res, req, location.String(), http.StatusFound)
+}
+
+func authorizeErrorRedirect(res http.ResponseWriter, req *http.Request, redirectURI string, state string, oauthErr OAuthError) {
+	params := url.Values{}
+	params.Set("error", oauthErr.Err)
+	params.Set("error_description", oauthErr.Description)
+	if state != "" {
+		params.Set("state", state)
+	}
+
+	authorizeRedirect(res, req, redirectURI, params)
+}
+
+var loginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Nanocloud - Sign in</title>
+</head>
+<body>
+<h1>Sign in</h1>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="POST" action="/oauth/authorize">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<label>Email <input type="text" name="username" value="{{.Username}}" autofocus required></label>
+<label>Password <input type="password" name="password" required></label>
+<label>Second factor code <input type="text" name="mfa_code" autocomplete="off"{{if not .MFARequired}} placeholder="if enabled"{{end}}></label>
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+var consentForm = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Nanocloud - Authorize</title>
+</head>
+<body>
+<h1>Authorize {{.ClientId}}</h1>
+<p>{{.ClientId}} asks to access Nanocloud on your behalf.</p>
+<form method="POST" action="/oauth/authorize">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="hidden" name="consent" value="{{.Consent}}">
+<button type="submit" name="decision" value="allow">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+// Parameters of the authorization request carried over by the forms
+var authorizeParams = []string{
+	"client_id",
+	"redirect_uri",
+	"response_type",
+	"state",
+	"code_challenge",
+	"code_challenge_method",
+}
+
+func formParams(req *http.Request) map[string]string {
+	params := make(map[string]string)
+	for _, name := range authorizeParams {
+		if value := req.FormValue(name); value != "" {
+			params[name] = value
+		}
+	}
+	return params
+}
+
+const csrfCookie = "oauth_csrf"
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+/*
+ * Return the token the forms of the authorization endpoint must send back.
+ * It is also set in a cookie that other sites can neither read nor set, so
+ * that a form posted from elsewhere is refused by checkCSRFToken.
+ */
+func csrfToken(res http.ResponseWriter, req *http.Request) (string, error) {
+	cookie, err := req.Cookie(csrfCookie)
+	if err == nil && len(cookie.Value) >= 43 {
+		return cookie.Value, nil
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(res, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/oauth/authorize",
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+	})
+	return token, nil
+}
+
+func checkCSRFToken(req *http.Request) bool {
+	cookie, err := req.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	token := req.PostFormValue("csrf_token")
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(token)) == 1
+}
+
+func formReply(res http.ResponseWriter, status int, form *template.Template, data interface{}) {
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.Header().Set("X-Frame-Options", "DENY")
+	res.WriteHeader(status)
+
+	fail := form.Execute(res, data)
+	if fail != nil {
+		log.Error("[OAuth] Cannot Render Form: " + fail.Error())
+	}
+}
+
+func loginFormReply(res http.ResponseWriter, req *http.Request, status int, oauthErr *OAuthError) {
+	token, fail := csrfToken(res, req)
+	if fail != nil {
+		log.Error("[OAuth] Cannot Create CSRF Token: " + fail.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
+		return
+	}
+
+	data := struct {
+		Params      map[string]string
+		CSRFToken   string
+		Username    string
+		Error       string
+		MFARequired bool
+	}{
+		Params:    formParams(req),
+		CSRFToken: token,
+		Username:  req.PostFormValue("username"),
+	}
+	if oauthErr != nil {
+		data.Error = oauthErr.Description
+		data.MFARequired = oauthErr.Err == MFA_REQUIRED
+	}
+
+	formReply(res, status, loginForm, data)
+}
+
+// An authenticated user who hasn't allowed or denied the client yet
+type pendingConsent struct {
+	user          interface{}
+	clientId      string
+	redirectURI   string
+	codeChallenge string
+	expiresAt     time.Time
+}
+
+const consentTTL = 5 * time.Minute
+
+var (
+	kConsents      = make(map[string]pendingConsent)
+	kConsentsMutex sync.Mutex
+)
+
+func addConsent(consent pendingConsent) (string, error) {
+	ticket, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	kConsentsMutex.Lock()
+	defer kConsentsMutex.Unlock()
+
+	now := time.Now()
+	for t, c := range kConsents {
+		if now.After(c.expiresAt) {
+			delete(kConsents, t)
+		}
+	}
+
+	consent.expiresAt = now.Add(consentTTL)
+	kConsents[ticket] = consent
+	return ticket, nil
+}
+
+// Return the user of the consent ticket if it was delivered for the same
+// request. A ticket can only be used once.
+func takeConsent(ticket, clientId, redirectURI, codeChallenge string) interface{} {
+	kConsentsMutex.Lock()
+	defer kConsentsMutex.Unlock()
+
+	consent, ok := kConsents[ticket]
+	if !ok {
+		return nil
+	}
+	delete(kConsents, ticket)
+
+	if time.Now().After(consent.expiresAt) ||
+		consent.clientId != clientId ||
+		consent.redirectURI != redirectURI ||
+		consent.codeChallenge != codeChallenge {
+		return nil
+	}
+	return consent.user
+}
+
+func consentFormReply(res http.ResponseWriter, req *http.Request, consent pendingConsent) {
+	token, fail := csrfToken(res, req)
+	if fail != nil {
+		log.Error("[OAuth] Cannot Create CSRF Token: " + fail.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
+		return
+	}
+
+	ticket, fail := addConsent(consent)
+	if fail != nil {
+		log.Error("[OAuth] Cannot Create Consent Ticket: " + fail.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
+		return
+	}
+
+	data := struct {
+		Params    map[string]string
+		CSRFToken string
+		Consent   string
+		ClientId  string
+	}{
+		Params:    formParams(req),
+		CSRFToken: token,
+		Consent:   ticket,
+		ClientId:  consent.clientId,
+	}
+
+	formReply(res, http.StatusOK, consentForm, data)
+}
+
+/*
+ * Authorization endpoint of the authorization_code grant. Only PKCE requests
+ * (with the S256 method) are accepted. The user signs in with the login form,
+ * which goes through the same authenticators, throttling and second factor
+ * as the password grant. A user already signed in can send their access
+ * token instead, as a Bearer token only. Either way, the code is only issued
+ * once the user allows the client on the consent form. Both forms are
+ * protected by a CSRF token.
+ */
+func authorize(res http.ResponseWriter, req *http.Request) {
+	fail := req.ParseForm()
+	if fail != nil {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "Unable to parse the request"})
+		return
+	}
+
+	clientId := req.FormValue("client_id")
+	if clientId == "" {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "client_id is missing"})
+		return
+	}
+
+	redirectURI := req.FormValue("redirect_uri")
+	if redirectURI == "" {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "redirect_uri is missing"})
+		return
+	}
+
+	client, fail := kConnector.GetAuthorizationClient(clientId, redirectURI)
+	if fail != nil {
+		log.Error("[OAuth] Unable to retreive client: " + fail.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
+		return
+	}
+
+	// The user agent must never be redirected to an URI that hasn't been
+	// registered for the client.
+	if client == nil {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_CLIENT, "Unknown client or unregistered redirect_uri"})
+		return
+	}
+
+	state := req.FormValue("state")
+
+	if req.FormValue("response_type") != "code" {
+		authorizeErrorRedirect(res, req, redirectURI, state, OAuthError{http.StatusBadRequest, UNSUPPORTED_RESPONSE_TYPE, "response_type must be code"})
+		return
+	}
+
+	codeChallenge := req.FormValue("code_challenge")
+	if codeChallenge == "" {
+		authorizeErrorRedirect(res, req, redirectURI, state, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "code_challenge is missing"})
+		return
+	}
+
+	if req.FormValue("code_challenge_method") != "S256" {
+		authorizeErrorRedirect(res, req, redirectURI, state, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "code_challenge_method must be S256"})
+		return
+	}
+
+	if req.Method == "POST" && !checkCSRFToken(req) {
+		loginFormReply(res, req, http.StatusForbidden, &OAuthError{http.StatusForbidden, ACCESS_DENIED, "The form has expired, please try again"})
+		return
+	}
+
+	ticket := req.PostFormValue("consent")
+	if req.Method == "POST" && ticket != "" {
+		user := takeConsent(ticket, clientId, redirectURI, codeChallenge)
+		if user == nil {
+			loginFormReply(res, req, http.StatusForbidden, &OAuthError{http.StatusForbidden, ACCESS_DENIED, "The form has expired, please try again"})
+			return
+		}
+
+		if req.PostFormValue("decision") != "allow" {
+			authorizeErrorRedirect(res, req, redirectURI, state, OAuthError{http.StatusForbidden, ACCESS_DENIED, "The user denied the authorization"})
+			return
+		}
+
+		issueAuthorizationCode(res, req, client, user, redirectURI, codeChallenge, state)
+		return
+	}
+
+	var user interface{}
+	// credentials are only read from the body so they never end up in an URL
+	username := req.PostFormValue("username")
+	if req.Method == "POST" && username != "" {
+		var err *OAuthError
+		user, err = authenticateUser(res, req, username, req.PostFormValue("password"), req.PostFormValue("mfa_code"))
+		if err != nil {
+			if err.HTTPStatusCode == http.StatusInternalServerError {
+				authorizeErrorRedirect(res, req, redirectURI, state, *err)
+				return
+			}
+			loginFormReply(res, req, err.HTTPStatusCode, err)
+			return
+		}
+	} else {
+		// an access token in the URL would leak through the history and the
+		// Referer header
+		accessToken, err := GetAuthorizationHeaderValue(req, "Bearer")
+		if err != nil {
+			loginFormReply(res, req, http.StatusOK, nil)
+			return
+		}
+
+		user, err = userFromAccessToken(accessToken)
+		if err != nil {
+			oauthErrorReply(res, *err)
+			return
+		}
+	}
+
+	consentFormReply(res, req, pendingConsent{
+		user:          user,
+		clientId:      clientId,
+		redirectURI:   redirectURI,
+		codeChallenge: codeChallenge,
+	})
+}
+
+func issueAuthorizationCode(res http.ResponseWriter, req *http.Request, client, user interface{}, redirectURI, codeChallenge, state string) {
+	code, fail := kConnector.CreateAuthorizationCode(client, user, redirectURI, codeChallenge)
+	if fail == IdentityNotAllowed {
+		authorizeErrorRedirect(res, req, redirectURI, state, OAuthError{http.StatusForbidden, ACCESS_DENIED, "This token can't authorize a client, sign in instead"})
+		return
+	}
+	if fail != nil {
+		log.Error("[OAuth] Cannot Create Authorization Code: " + fail.Error())
+		authorizeErrorRedirect(res, req, redirectURI, state, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
+		return
+	}
+
+	params := url.Values{}
+	params.Set("code", code)
+	if state != "" {
+		params.Set("state", state)
+	}
+
+	authorizeRedirect(res, req, redirectURI, params)
+}
+
 func HandleRequest(res http.ResponseWriter, req *http.Request) {
 	res.Header().Add("Cache-Control", "no-store")
 	res.Header().Add("Pragma", "no-cache")
 
+	if req.URL.Path == "/oauth/authorize" && (req.Method == "GET" || req.Method == "POST") {
+		authorize(res, req)
+		return
+	}
+
 	if req.Method == "POST" {
 		if req.URL.Path == "/oauth/revoke" {
 			revokeToken(res, req)
@@ -327,6 +1049,11 @@ func HandleRequest(res http.ResponseWriter, req *http.Request) {
 			createToken(res, req)
 			return
 		}
+
+		if req.URL.Path == "/oauth/introspect" {
+			introspectToken(res, req)
+			return
+		}
 	}
 
 	oauthErrorReply(res, OAuthError{http.StatusNotFound, INVALID_REQUEST, "Invalid Endpoint"})
@@ -350,10 +1077,54 @@ func (c dummyConnector) GetAccessToken(user, client interface{}, req *http.Reque
 	return nil, errors.New("GetAccessToken is not implemented")
 }
 
+func (c dummyConnector) RefreshAccessToken(client interface{}, refreshToken string, req *http.Request) (interface{}, error) {
+	return nil, errors.New("RefreshAccessToken is not implemented")
+}
+
 func (c dummyConnector) RevokeAccessToken(client, user interface{}, accessToken string) error {
 	return errors.New("RevokeAccessToken is not implemented")
 }
 
+func (c dummyConnector) RevokeRefreshToken(client interface{}, refreshToken string) error {
+	return errors.New("RevokeRefreshToken is not implemented")
+}
+
+func (c dummyConnector) GetAuthorizationClient(key, redirectURI string) (interface{}, error) {
+	return nil, errors.New("GetAuthorizationClient is not implemented")
+}
+
+func (c dummyConnector) CreateAuthorizationCode(client, user interface{}, redirectURI, codeChallenge string) (string, error) {
+	return "", errors.New("CreateAuthorizationCode is not implemented")
+}
+
+func (c dummyConnector) ConsumeAuthorizationCode(client interface{}, code string) (interface{}, string, string, error) {
+	return nil, "", "", errors.New("ConsumeAuthorizationCode is not implemented")
+}
+
+func (c dummyConnector) GetClientCredentialsToken(client interface{}, scopes []string, req *http.Request) (interface{}, error) {
+	return nil, errors.New("GetClientCredentialsToken is not implemented")
+}
+
+func (c dummyConnector) CanIntrospect(client interface{}) bool {
+	return false
+}
+
+func (c dummyConnector) IntrospectAccessToken(accessToken string) (interface{}, error) {
+	return nil, errors.New("IntrospectAccessToken is not implemented")
+}
+
+func (c dummyConnector) CheckSecondFactor(user interface{}, code string) error {
+	return errors.New("CheckSecondFactor is not implemented")
+}
+
+func (c dummyConnector) CheckLoginAttempt(username string, req *http.Request) (time.Duration, error) {
+	return 0, errors.New("CheckLoginAttempt is not implemented")
+}
+
+func (c dummyConnector) RecordLoginAttempt(username string, req *http.Request, succeeded bool) error {
+	return errors.New("RecordLoginAttempt is not implemented")
+}
+
 func init() {
 	SetConnector(dummyConnector{})
 }
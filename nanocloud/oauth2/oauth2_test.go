@@ -0,0 +1,238 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package oauth2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Knows a single client, and a single user signed in with the token "alice"
+type authorizeConnector struct {
+	dummyConnector
+}
+
+func (c authorizeConnector) GetAuthorizationClient(key, redirectURI string) (interface{}, error) {
+	if key == "portal" && redirectURI == "https://portal.example.com/cb" {
+		return key, nil
+	}
+	return nil, nil
+}
+
+func (c authorizeConnector) GetUserFromAccessToken(accessToken string) (interface{}, error) {
+	if accessToken == "alice" {
+		return "alice", nil
+	}
+	return nil, nil
+}
+
+func (c authorizeConnector) CreateAuthorizationCode(client, user interface{}, redirectURI, codeChallenge string) (string, error) {
+	return "code-of-" + user.(string), nil
+}
+
+var authorizeQuery = url.Values{
+	"client_id":             {"portal"},
+	"redirect_uri":          {"https://portal.example.com/cb"},
+	"response_type":         {"code"},
+	"state":                 {"xyz"},
+	"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWjuvY2Ckr0cM"},
+	"code_challenge_method": {"S256"},
+}
+
+func authorizeRequest(method string, form url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	var req *http.Request
+	if method == "GET" {
+		req, _ = http.NewRequest("GET", "/oauth/authorize?"+form.Encode(), nil)
+	} else {
+		req, _ = http.NewRequest("POST", "/oauth/authorize", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	res := httptest.NewRecorder()
+	authorize(res, req)
+	return res
+}
+
+// Use authorizeConnector until the returned function is called
+func withAuthorizeConnector() func() {
+	previous := kConnector
+	SetConnector(authorizeConnector{})
+	return func() { SetConnector(previous) }
+}
+
+func hiddenValue(body, name string) string {
+	m := regexp.MustCompile(`name="` + name + `" value="([^"]*)"`).FindStringSubmatch(body)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+func TestAuthorizeQueryToken(t *testing.T) {
+	defer withAuthorizeConnector()()
+
+	query := url.Values{"access_token": {"alice"}}
+	for k, v := range authorizeQuery {
+		query[k] = v
+	}
+
+	res := authorizeRequest("GET", query, nil)
+	if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), `name="password"`) {
+		t.Errorf("an access token in the query should be ignored, got %d", res.Code)
+	}
+}
+
+func TestAuthorizeCSRF(t *testing.T) {
+	defer withAuthorizeConnector()()
+
+	form := url.Values{"username": {"alice@example.com"}, "password": {"secret"}}
+	for k, v := range authorizeQuery {
+		form[k] = v
+	}
+
+	res := authorizeRequest("POST", form, nil)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("a login without CSRF token should be refused, got %d", res.Code)
+	}
+
+	form.Set("csrf_token", "forged")
+	res = authorizeRequest("POST", form, []*http.Cookie{{Name: csrfCookie, Value: "another"}})
+	if res.Code != http.StatusForbidden {
+		t.Errorf("a login with a wrong CSRF token should be refused, got %d", res.Code)
+	}
+}
+
+func TestAuthorizeConsent(t *testing.T) {
+	defer withAuthorizeConnector()()
+
+	req, _ := http.NewRequest("GET", "/oauth/authorize?"+authorizeQuery.Encode(), nil)
+	req.Header.Set("Authorization", "Bearer alice")
+	res := httptest.NewRecorder()
+	authorize(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected the consent form, got %d", res.Code)
+	}
+	if res.Header().Get("Location") != "" {
+		t.Fatal("no code should be issued before the user consents")
+	}
+
+	body := res.Body.String()
+	token := hiddenValue(body, "csrf_token")
+	ticket := hiddenValue(body, "consent")
+	if token == "" || ticket == "" {
+		t.Fatal("the consent form should hold a CSRF token and a consent ticket")
+	}
+	cookies := []*http.Cookie{{Name: csrfCookie, Value: token}}
+
+	form := url.Values{"csrf_token": {token}, "consent": {ticket}, "decision": {"allow"}}
+	for k, v := range authorizeQuery {
+		form[k] = v
+	}
+
+	withoutCookie := authorizeRequest("POST", form, nil)
+	if withoutCookie.Code != http.StatusForbidden {
+		t.Errorf("a consent without the CSRF cookie should be refused, got %d", withoutCookie.Code)
+	}
+
+	res = authorizeRequest("POST", form, cookies)
+	if res.Code != http.StatusFound {
+		t.Fatalf("expected a redirection, got %d", res.Code)
+	}
+	location, _ := url.Parse(res.Header().Get("Location"))
+	if location.Query().Get("code") != "code-of-alice" || location.Query().Get("state") != "xyz" {
+		t.Errorf("unexpected redirection %s", location)
+	}
+
+	res = authorizeRequest("POST", form, cookies)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("a consent ticket should only be used once, got %d", res.Code)
+	}
+}
+
+// Knows the webapp and a resource server, with the secret "secret"
+type introspectConnector struct {
+	dummyConnector
+}
+
+func (c introspectConnector) GetClient(key, secret string) (interface{}, error) {
+	if secret != "secret" {
+		return nil, nil
+	}
+	return key, nil
+}
+
+func (c introspectConnector) CanIntrospect(client interface{}) bool {
+	return client.(string) == "proxy"
+}
+
+func (c introspectConnector) IntrospectAccessToken(accessToken string) (interface{}, error) {
+	return map[string]bool{"active": true}, nil
+}
+
+func TestIntrospectionClients(t *testing.T) {
+	previous := kConnector
+	SetConnector(introspectConnector{})
+	defer SetConnector(previous)
+
+	expected := map[string]int{
+		"webapp": http.StatusForbidden,
+		"proxy":  http.StatusOK,
+	}
+	for client, status := range expected {
+		req, _ := http.NewRequest("POST", "/oauth/introspect", strings.NewReader("token=abc"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client, "secret")
+
+		res := httptest.NewRecorder()
+		introspectToken(res, req)
+		if res.Code != status {
+			t.Errorf("expected %d for the %s client, got %d", status, client, res.Code)
+		}
+	}
+}
+
+func TestTakeConsent(t *testing.T) {
+	ticket, err := addConsent(pendingConsent{
+		user:          "alice",
+		clientId:      "portal",
+		redirectURI:   "https://portal.example.com/cb",
+		codeChallenge: "challenge",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if takeConsent(ticket, "other", "https://portal.example.com/cb", "challenge") != nil {
+		t.Error("a ticket should only be accepted for the client it was delivered to")
+	}
+	if takeConsent(ticket, "portal", "https://portal.example.com/cb", "challenge") != nil {
+		t.Error("a ticket presented for another request should be consumed")
+	}
+}
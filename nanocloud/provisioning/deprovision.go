@@ -0,0 +1,108 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package provisioning
+
+import (
+	"encoding/json"
+
+	"github.com/Nanocloud/community/nanocloud/jobs"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/sessions"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+const logoffSessionsJob = "logoff-sessions"
+
+type logoffPayload struct {
+	Sam string `json:"sam"`
+}
+
+func logoffSessions(payload []byte) error {
+	p := logoffPayload{}
+	err := json.Unmarshal(payload, &p)
+	if err != nil {
+		return err
+	}
+	return sessions.Logoff(p.Sam)
+}
+
+// Close the sessions of the user on the execution servers
+func LogoffSessions(userId string) error {
+	user, err := users.GetUser(userId)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return users.UserNotFound
+	}
+
+	winUser, err := user.WindowsCredentials()
+	if err != nil {
+		// no Windows account, no session
+		return nil
+	}
+	return jobs.Run(logoffSessionsJob, logoffPayload{winUser.Sam})
+}
+
+// Disable the user and cut all its accesses: its tokens are revoked, its
+// sessions closed and its Windows account disabled.
+func Deprovision(userId string) error {
+	err := users.DisableUser(userId)
+	if err != nil {
+		return err
+	}
+
+	err = oauth.RevokeUserTokens(userId)
+	if err != nil {
+		return err
+	}
+
+	err = LogoffSessions(userId)
+	if err != nil {
+		return err
+	}
+	return DisableAccount(userId)
+}
+
+// Delete the user after closing its sessions, then its Windows account
+func DeleteUser(userId string) error {
+	err := LogoffSessions(userId)
+	if err != nil {
+		return err
+	}
+
+	err = oauth.RevokeUserTokens(userId)
+	if err != nil {
+		return err
+	}
+
+	err = users.DeleteUser(userId)
+	if err != nil {
+		return err
+	}
+	return DeleteAccount(userId)
+}
+
+func init() {
+	jobs.Register(logoffSessionsJob, logoffSessions)
+}
@@ -0,0 +1,193 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package provisioning
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Serializes the password changes, two requests of a user must not hand out
+// different passwords
+var kIssueLock sync.Mutex
+
+// Lifetime of the Windows passwords handed out to the browsers, 0 if the
+// permanent password is used.
+func ephemeralPasswordTTL() time.Duration {
+	minutes, err := strconv.Atoi(utils.Env("WINDOWS_PASSWORD_TTL", "0"))
+	if err != nil || minutes < 0 {
+		return 0
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// Whether the password handed out can still be used for a new session. It is
+// replaced once half of its lifetime is over so that the sessions opened with
+// it have time to start.
+func reusable(expiresAt time.Time, ttl time.Duration, now time.Time) bool {
+	return expiresAt.Sub(now) > ttl/2
+}
+
+// Whether the Windows account of the user is the one Nanocloud created for it,
+// the only accounts whose password is replaced. The directory entries of the
+// users provisioned from LDAP and the accounts linked otherwise, like the
+// administrator's, keep their password.
+func managedAccount(userId string, credentials *users.WindowsUser) (bool, error) {
+	fromDirectory, err := users.IsFromDirectory(userId)
+	if err != nil || fromDirectory {
+		return false, err
+	}
+
+	sam, err := ldap.FindSam(userId)
+	if err == ldap.UnknownUser {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(sam, credentials.Sam), nil
+}
+
+// Return the Windows credentials to give to the browser of the user. When
+// WINDOWS_PASSWORD_TTL is set, the password of the accounts created by
+// Nanocloud is replaced by one that is only valid for that many minutes.
+func SessionCredentials(user *users.User) (*users.WindowsUser, error) {
+	ttl := ephemeralPasswordTTL()
+	if ttl == 0 {
+		return user.WindowsCredentials()
+	}
+
+	kIssueLock.Lock()
+	defer kIssueLock.Unlock()
+
+	credentials, err := user.WindowsCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	if reusable(credentials.PasswordExpiresAt, ttl, time.Now()) {
+		return credentials, nil
+	}
+
+	managed, err := managedAccount(user.Id, credentials)
+	if err != nil {
+		// the current password still works if Active Directory is unreachable
+		log.Warnf("Unable to issue an ephemeral Windows password for %s: %s", user.Id, err.Error())
+		return credentials, nil
+	}
+	if !managed {
+		return credentials, nil
+	}
+
+	password, err := generatePassword()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ldap.ChangePassword(user.Id, password)
+	if err != nil {
+		log.Warnf("Unable to issue an ephemeral Windows password for %s: %s", user.Id, err.Error())
+		return credentials, nil
+	}
+
+	err = users.UpdateEphemeralWindowsPassword(user.Id, password, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials.Password = password
+	credentials.PasswordExpiresAt = time.Now().Add(ttl)
+	return credentials, nil
+}
+
+// Replace an expired ephemeral password with a random one that is never
+// handed out
+func rotatePassword(id string) error {
+	kIssueLock.Lock()
+	defer kIssueLock.Unlock()
+
+	user, err := users.GetUser(id)
+	if err != nil || user == nil {
+		return err
+	}
+
+	// a new password may have been issued meanwhile
+	credentials, err := user.WindowsCredentials()
+	if err != nil {
+		return err
+	}
+	if credentials.PasswordExpiresAt.After(time.Now()) {
+		return nil
+	}
+
+	managed, err := managedAccount(id, credentials)
+	if err != nil || !managed {
+		return err
+	}
+
+	password, err := generatePassword()
+	if err != nil {
+		return err
+	}
+
+	err = ldap.ChangePassword(id, password)
+	if err != nil {
+		return err
+	}
+	return users.UpdateWindowsPassword(id, password)
+}
+
+// Rotate the expired ephemeral passwords every interval, so that a leaked
+// password stops working even if its user doesn't come back. A failed
+// rotation is retried on the next run since the password stays expired.
+func StartPasswordRotation(interval time.Duration) {
+	if ephemeralPasswordTTL() == 0 {
+		return
+	}
+
+	go func() {
+		for {
+			time.Sleep(interval)
+
+			ids, err := users.FindExpiredWindowsPasswords()
+			if err != nil {
+				log.Error("Unable to find the expired Windows passwords: ", err)
+				continue
+			}
+
+			for _, id := range ids {
+				err = rotatePassword(id)
+				if err != nil {
+					log.Errorf("Unable to rotate the Windows password of %s: %s", id, err.Error())
+				}
+			}
+		}
+	}()
+}
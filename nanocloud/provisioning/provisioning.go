@@ -0,0 +1,165 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package provisioning keeps the Active Directory accounts in line with the
+// Nanocloud users. Every operation runs as a job so that it is retried until
+// Active Directory accepts it.
+package provisioning
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"math/big"
+
+	"github.com/Nanocloud/community/nanocloud/jobs"
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/passwords"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+)
+
+const (
+	createAccountJob  = "ad-create-account"
+	disableAccountJob = "ad-disable-account"
+	enableAccountJob  = "ad-enable-account"
+	deleteAccountJob  = "ad-delete-account"
+
+	passwordLength = 16
+	passwordChars  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!#%+-=?@_"
+)
+
+type accountPayload struct {
+	UserId string `json:"user_id"`
+}
+
+// Generate a random password satisfying the Active Directory complexity rules
+func generatePassword() (string, error) {
+	max := big.NewInt(int64(len(passwordChars)))
+	for {
+		b := make([]byte, passwordLength)
+		for i := range b {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			b[i] = passwordChars[n.Int64()]
+		}
+
+		password := string(b)
+		if len(passwords.ActiveDirectory.Validate(password)) == 0 {
+			return password, nil
+		}
+	}
+}
+
+func userId(payload []byte) (string, error) {
+	p := accountPayload{}
+	err := json.Unmarshal(payload, &p)
+	return p.UserId, err
+}
+
+func createAccount(payload []byte) error {
+	id, err := userId(payload)
+	if err != nil {
+		return err
+	}
+
+	user, err := users.GetUser(id)
+	if err != nil || user == nil {
+		// the user has been deleted meanwhile
+		return err
+	}
+
+	// WindowsCredentials fails when the user has no Windows account yet
+	credentials, err := user.WindowsCredentials()
+	if err == nil && credentials.Sam != "" {
+		return nil
+	}
+
+	password, err := generatePassword()
+	if err != nil {
+		return err
+	}
+
+	sam, err := ldap.AddUser(id, password)
+	if err == ldap.AlreadyExists {
+		// a previous attempt created the account but failed to link it, the
+		// password it has been created with is lost
+		err = ldap.ChangePassword(id, password)
+		if err != nil {
+			return err
+		}
+		sam, err = ldap.FindSam(id)
+	}
+	if err != nil {
+		return err
+	}
+
+	return users.UpdateUserAd(id, sam, password, utils.Env("WINDOWS_DOMAIN", "intra.localdomain.com"))
+}
+
+// Jobs disabling, enabling or deleting an account. The accounts not created by
+// Nanocloud are left alone.
+func accountJob(apply func(id string) error) jobs.Handler {
+	return func(payload []byte) error {
+		id, err := userId(payload)
+		if err != nil {
+			return err
+		}
+
+		err = apply(id)
+		if err == ldap.UnknownUser {
+			return nil
+		}
+		return err
+	}
+}
+
+// The jobs of an account run in order, a retried disable can't undo a later
+// enable
+func accountQueue(userId string) string {
+	return "account:" + userId
+}
+
+// Create the Windows account of the user with a generated password
+func CreateAccount(userId string) error {
+	return jobs.RunInQueue(accountQueue(userId), createAccountJob, accountPayload{userId})
+}
+
+func DisableAccount(userId string) error {
+	return jobs.RunInQueue(accountQueue(userId), disableAccountJob, accountPayload{userId})
+}
+
+func EnableAccount(userId string) error {
+	return jobs.RunInQueue(accountQueue(userId), enableAccountJob, accountPayload{userId})
+}
+
+func DeleteAccount(userId string) error {
+	return jobs.RunInQueue(accountQueue(userId), deleteAccountJob, accountPayload{userId})
+}
+
+func init() {
+	jobs.Register(createAccountJob, createAccount)
+	jobs.Register(disableAccountJob, accountJob(ldap.DisableUser))
+	jobs.Register(enableAccountJob, accountJob(ldap.EnableUser))
+	jobs.Register(deleteAccountJob, accountJob(ldap.DeleteAccount))
+}
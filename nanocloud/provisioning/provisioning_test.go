@@ -0,0 +1,84 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package provisioning
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/models/passwords"
+)
+
+func TestGeneratePassword(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		password, err := generatePassword()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(password) != passwordLength {
+			t.Errorf("expected %d characters, got %q", passwordLength, password)
+		}
+		if len(passwords.ActiveDirectory.Validate(password)) != 0 {
+			t.Errorf("%q doesn't satisfy the Active Directory rules", password)
+		}
+		if seen[password] {
+			t.Errorf("%q generated twice", password)
+		}
+		seen[password] = true
+	}
+}
+
+func TestUserId(t *testing.T) {
+	id, err := userId([]byte(`{"user_id":"42"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "42" {
+		t.Errorf("expected 42, got %s", id)
+	}
+}
+
+func TestReusable(t *testing.T) {
+	now := time.Now()
+	ttl := 10 * time.Minute
+
+	cases := []struct {
+		expiresAt time.Time
+		reusable  bool
+	}{
+		{now.Add(10 * time.Minute), true},
+		{now.Add(6 * time.Minute), true},
+		{now.Add(5 * time.Minute), false},
+		{now.Add(time.Minute), false},
+		{now.Add(-time.Minute), false},
+		{time.Unix(0, 0), false},
+	}
+
+	for _, c := range cases {
+		if r := reusable(c.expiresAt, ttl, now); r != c.reusable {
+			t.Errorf("reusable(%s) = %t, expected %t", c.expiresAt.Sub(now), r, c.reusable)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package provisioning
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Kinds of drift between the Nanocloud users and the accounts of the OU
+const (
+	// AD account matching no Nanocloud user
+	OrphanedAccount = "orphaned-account"
+	// activated Nanocloud user without Windows credentials
+	MissingAccount = "missing-account"
+	// AD account enabled while the Nanocloud user is deactivated, or the
+	// other way around
+	StatusMismatch = "status-mismatch"
+)
+
+// The accounts created by Nanocloud are named after the id of their user
+var managedCN = regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$")
+
+type Discrepancy struct {
+	Kind   string `json:"kind"`
+	UserId string `json:"user-id,omitempty"`
+	Email  string `json:"email,omitempty"`
+	CN     string `json:"cn,omitempty"`
+	Sam    string `json:"sam,omitempty"`
+	Detail string `json:"detail"`
+	// Whether apply mode fixes it, unmanaged accounts are only reported
+	Fixable bool   `json:"fixable"`
+	Applied bool   `json:"applied"`
+	Error   string `json:"error,omitempty"`
+
+	fix func() error
+}
+
+type Report struct {
+	DryRun        bool           `json:"dry-run"`
+	Users         int            `json:"users"`
+	Accounts      int            `json:"accounts"`
+	Discrepancies []*Discrepancy `json:"discrepancies"`
+	StartedAt     time.Time      `json:"started-at"`
+}
+
+func (r *Report) GetID() string {
+	return "reconciliation"
+}
+
+func (r *Report) SetID(id string) error {
+	return nil
+}
+
+// Compare the Nanocloud users with the accounts of the OU, as returned by
+// ldap.GetUsers. Nanocloud is the reference: the fixes create, enable,
+// disable or delete AD accounts.
+func plan(links []*users.WindowsLink, accounts []map[string]string) []*Discrepancy {
+	byId := make(map[string]*users.WindowsLink, len(links))
+	bySam := make(map[string]*users.WindowsLink, len(links))
+	for _, link := range links {
+		byId[link.UserId] = link
+		if link.Sam != "" {
+			bySam[strings.ToLower(link.Sam)] = link
+		}
+	}
+
+	discrepancies := make([]*Discrepancy, 0)
+	found := make(map[string]bool, len(accounts))
+
+	for _, account := range accounts {
+		cn := account["cn"]
+		sam := account["samaccountname"]
+		enabled := account["status"] == "Enabled"
+
+		link, exists := byId[cn]
+		if !exists {
+			link, exists = bySam[strings.ToLower(sam)]
+		}
+
+		if !exists {
+			managed := managedCN.MatchString(cn)
+			discrepancy := &Discrepancy{
+				Kind:    OrphanedAccount,
+				CN:      cn,
+				Sam:     sam,
+				Detail:  "No Nanocloud user uses this account",
+				Fixable: managed,
+			}
+			if managed {
+				discrepancy.Detail += ", it will be deleted"
+				discrepancy.fix = func() error { return DeleteAccount(cn) }
+			}
+			discrepancies = append(discrepancies, discrepancy)
+			continue
+		}
+		found[link.UserId] = true
+
+		if link.Activated == enabled {
+			continue
+		}
+
+		// only the accounts created by Nanocloud follow the users, the
+		// other ones are managed in Active Directory
+		managed := cn == link.UserId
+		discrepancy := &Discrepancy{
+			Kind:    StatusMismatch,
+			UserId:  link.UserId,
+			Email:   link.Email,
+			CN:      cn,
+			Sam:     sam,
+			Fixable: managed,
+		}
+		userId := link.UserId
+		if link.Activated {
+			discrepancy.Detail = "The user is activated but the account is disabled"
+			discrepancy.fix = func() error { return EnableAccount(userId) }
+		} else {
+			discrepancy.Detail = "The user is deactivated but the account is enabled"
+			discrepancy.fix = func() error { return DisableAccount(userId) }
+		}
+		if !managed {
+			discrepancy.fix = nil
+		}
+		discrepancies = append(discrepancies, discrepancy)
+	}
+
+	for _, link := range links {
+		// deactivated users without account are pending registrations
+		if found[link.UserId] || link.Sam != "" || !link.Activated {
+			continue
+		}
+
+		userId := link.UserId
+		discrepancies = append(discrepancies, &Discrepancy{
+			Kind:    MissingAccount,
+			UserId:  link.UserId,
+			Email:   link.Email,
+			Detail:  "The user has no Windows credentials",
+			Fixable: true,
+			fix:     func() error { return CreateAccount(userId) },
+		})
+	}
+
+	return discrepancies
+}
+
+// Compare the Nanocloud users with the Active Directory OU. Unless dryRun is
+// set, the fixable discrepancies are fixed through the provisioning jobs.
+func Reconcile(dryRun bool) (*Report, error) {
+	report := Report{
+		DryRun:    dryRun,
+		StartedAt: time.Now(),
+	}
+
+	links, err := users.FindWindowsLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := ldap.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	report.Users = len(links)
+	report.Accounts = accounts.Count
+	report.Discrepancies = plan(links, accounts.Users)
+
+	if dryRun {
+		return &report, nil
+	}
+
+	for _, discrepancy := range report.Discrepancies {
+		if discrepancy.fix == nil {
+			continue
+		}
+
+		err = discrepancy.fix()
+		if err != nil {
+			discrepancy.Error = err.Error()
+			continue
+		}
+		discrepancy.Applied = true
+	}
+	return &report, nil
+}
+
+// Reconcile every RECONCILE_INTERVAL minutes, 0 disables it. The
+// discrepancies are only logged unless RECONCILE_APPLY is "true".
+func StartReconciler() {
+	minutes, err := strconv.Atoi(utils.Env("RECONCILE_INTERVAL", "60"))
+	if err != nil || minutes <= 0 {
+		return
+	}
+	dryRun := utils.Env("RECONCILE_APPLY", "false") != "true"
+
+	go func() {
+		for {
+			time.Sleep(time.Duration(minutes) * time.Minute)
+
+			report, err := Reconcile(dryRun)
+			if err != nil {
+				log.Error("Reconciliation with Active Directory failed: ", err)
+				continue
+			}
+
+			for _, discrepancy := range report.Discrepancies {
+				log.WithFields(log.Fields{
+					"kind":    discrepancy.Kind,
+					"user-id": discrepancy.UserId,
+					"cn":      discrepancy.CN,
+					"sam":     discrepancy.Sam,
+					"applied": discrepancy.Applied,
+					"error":   discrepancy.Error,
+				}).Warn(discrepancy.Detail)
+			}
+		}
+	}()
+}
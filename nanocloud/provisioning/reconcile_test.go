@@ -0,0 +1,115 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package provisioning
+
+import (
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+const (
+	aliceId = "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0"
+	bobId   = "1f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0"
+	carolId = "2f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0"
+	daveId  = "3f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0"
+	eveId   = "4f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0"
+	goneId  = "5f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0"
+)
+
+func account(cn, sam, status string) map[string]string {
+	return map[string]string{
+		"cn":             cn,
+		"samaccountname": sam,
+		"status":         status,
+	}
+}
+
+func find(discrepancies []*Discrepancy, kind, key string) *Discrepancy {
+	for _, discrepancy := range discrepancies {
+		if discrepancy.Kind == kind && (discrepancy.UserId == key || discrepancy.CN == key) {
+			return discrepancy
+		}
+	}
+	return nil
+}
+
+func TestPlan(t *testing.T) {
+	links := []*users.WindowsLink{
+		// in sync
+		{UserId: aliceId, Email: "alice@example.com", Activated: true, Sam: "alice"},
+		// deactivated in Nanocloud only
+		{UserId: bobId, Email: "bob@example.com", Activated: false, Sam: "bob"},
+		// no Windows credentials
+		{UserId: carolId, Email: "carol@example.com", Activated: true},
+		// pending registration
+		{UserId: daveId, Email: "dave@example.com", Activated: false},
+		// account not created by Nanocloud, linked by its sam
+		{UserId: eveId, Email: "eve@example.com", Activated: true, Sam: "EVE"},
+	}
+
+	accounts := []map[string]string{
+		account(aliceId, "alice", "Enabled"),
+		account(bobId, "bob", "Enabled"),
+		account("Eve Smith", "eve", "Disabled"),
+		account(goneId, "gone", "Enabled"),
+		account("Administrator", "administrator", "Enabled"),
+	}
+
+	discrepancies := plan(links, accounts)
+	if len(discrepancies) != 5 {
+		for _, discrepancy := range discrepancies {
+			t.Log(*discrepancy)
+		}
+		t.Fatalf("expected 5 discrepancies, got %d", len(discrepancies))
+	}
+
+	bob := find(discrepancies, StatusMismatch, bobId)
+	if bob == nil || !bob.Fixable || bob.fix == nil {
+		t.Error("the account of bob should be disabled")
+	}
+
+	eve := find(discrepancies, StatusMismatch, eveId)
+	if eve == nil || eve.Fixable || eve.fix != nil {
+		t.Error("the mismatch of eve should only be reported")
+	}
+
+	carol := find(discrepancies, MissingAccount, carolId)
+	if carol == nil || !carol.Fixable {
+		t.Error("the account of carol should be created")
+	}
+
+	if find(discrepancies, MissingAccount, daveId) != nil {
+		t.Error("pending registrations don't need an account")
+	}
+
+	gone := find(discrepancies, OrphanedAccount, goneId)
+	if gone == nil || !gone.Fixable {
+		t.Error("the account of a deleted user should be deleted")
+	}
+
+	administrator := find(discrepancies, OrphanedAccount, "Administrator")
+	if administrator == nil || administrator.Fixable {
+		t.Error("unmanaged accounts should only be reported")
+	}
+}
@@ -27,7 +27,11 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"github.com/Nanocloud/community/nanocloud/audit"
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
 	"github.com/Nanocloud/community/nanocloud/models/apps"
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
@@ -36,6 +40,19 @@ import (
 
 type hash map[string]interface{}
 
+// State of the application recorded in the audit log, without the icon
+func appState(app *apps.App) interface{} {
+	if app == nil {
+		return nil
+	}
+	return hash{
+		"collection-name": app.CollectionName,
+		"alias":           app.Alias,
+		"display-name":    app.DisplayName,
+		"file-path":       app.FilePath,
+	}
+}
+
 // ========================================================================================================================
 // Procedure: createConnections
 //
@@ -50,6 +67,9 @@ func GetConnections(c *echo.Context) error {
 			"error": "Unable to retrieve applications list",
 		})
 	}
+	if err != nil {
+		return apiErrors.WindowsNotOnline.Detail(err.Error())
+	}
 
 	var response = make([]hash, len(connections))
 	for i, val := range connections {
@@ -66,7 +86,13 @@ func GetConnections(c *echo.Context) error {
 func ListApplications(c *echo.Context) error {
 	user := c.Get("user").(*users.User)
 
-	if !user.IsAdmin {
+	allowed, err := roles.Can(user, "apps:read")
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	if !allowed {
 		applications, err := apps.GetUserApps(user.Id)
 		if err == apps.GetAppsFailed {
 			return c.JSON(http.StatusInternalServerError, hash{
@@ -115,7 +141,10 @@ func ListApplications(c *echo.Context) error {
 
 // Make an application unusable
 func UnpublishApplication(c *echo.Context) error {
-	user := c.Get("user").(*users.User)
+	user, ok := c.Get("user").(*users.User)
+	if !ok {
+		return apiErrors.Forbidden.Detail("Applications are unpublished from the session of a user")
+	}
 
 	appId := c.Param("app_id")
 	if len(appId) < 1 {
@@ -128,7 +157,13 @@ func UnpublishApplication(c *echo.Context) error {
 		})
 	}
 
-	err := apps.UnpublishApp(user, appId)
+	app, err := apps.GetApp(appId)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	err = apps.UnpublishApp(user, appId)
 	if err == apps.UnpublishFailed {
 		return c.JSON(http.StatusInternalServerError, hash{
 			"error": [1]hash{
@@ -138,6 +173,9 @@ func UnpublishApplication(c *echo.Context) error {
 			},
 		})
 	}
+	if err == nil {
+		audit.Record(c, "app.unpublish", "app", appId, appState(app), nil)
+	}
 
 	return c.JSON(http.StatusOK, hash{
 		"meta": hash{},
@@ -151,12 +189,17 @@ func PublishApplication(c *echo.Context) error {
 		return err
 	}
 
-	user := c.Get("user").(*users.User)
+	// the application is published from the Windows session of the user
+	user, ok := c.Get("user").(*users.User)
+	if !ok {
+		return apiErrors.Forbidden.Detail("Applications are published from the session of a user")
+	}
 
 	err = apps.PublishApp(user, app)
 	if err != nil {
 		return err
 	}
+	audit.Record(c, "app.publish", "app", app.Id, nil, appState(app))
 
 	return utils.JSON(c, http.StatusOK, app)
 }
@@ -197,13 +240,13 @@ func ChangeAppName(c *echo.Context) error {
 		})
 	}
 
-	exists, err := apps.AppExists(appId)
+	previous, err := apps.GetApp(appId)
 	if err != nil {
 		log.Errorf("Unable to check app existence: %s", err.Error())
 		return err
 	}
 
-	if !exists {
+	if previous == nil {
 		return c.JSON(http.StatusNotFound, hash{
 			"error": [1]hash{
 				hash{
@@ -235,6 +278,117 @@ func ChangeAppName(c *echo.Context) error {
 			},
 		})
 	}
+	audit.Record(c, "app.rename", "app", appId, appState(previous), appState(application))
 
 	return utils.JSON(c, http.StatusOK, application)
 }
+
+func ListGrants(c *echo.Context) error {
+	appId := c.Param("app_id")
+
+	exists, err := apps.AppExists(appId)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	if !exists {
+		return apiErrors.AppNotFound
+	}
+
+	grants, err := apps.GetGrants(appId)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the grant list")
+	}
+
+	return utils.JSON(c, http.StatusOK, grants)
+}
+
+// Entitle a user or a group to the application
+func AddGrant(c *echo.Context) error {
+	appId := c.Param("app_id")
+
+	grant := apps.Grant{}
+	err := utils.ParseJSONBody(c, &grant)
+	if err != nil {
+		return err
+	}
+
+	if (grant.UserId == "") == (grant.GroupId == "") {
+		return apiErrors.InvalidRequest.Detail("Either user-id or group-id must be specified")
+	}
+
+	exists, err := apps.AppExists(appId)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	if !exists {
+		return apiErrors.AppNotFound
+	}
+
+	if grant.UserId != "" {
+		exists, err = users.UserExists(grant.UserId)
+		if err != nil {
+			log.Error(err)
+			return apiErrors.InternalError
+		}
+		if !exists {
+			return apiErrors.UserNotFound
+		}
+	} else {
+		group, err := groups.GetGroup(grant.GroupId)
+		if err != nil {
+			log.Error(err)
+			return apiErrors.InternalError
+		}
+		if group == nil {
+			return apiErrors.GroupNotFound
+		}
+	}
+
+	newGrant, err := apps.CreateGrant(appId, grant.UserId, grant.GroupId)
+	if err == apps.GrantDuplicated {
+		return apiErrors.Conflict.Detail("This entitlement already exists")
+	}
+	if err != nil {
+		return apiErrors.InternalError.Detail("Unable to create the grant")
+	}
+	audit.Record(c, "app.grant", "app", appId, nil, newGrant)
+
+	return utils.JSON(c, http.StatusCreated, newGrant)
+}
+
+func RemoveGrant(c *echo.Context) error {
+	appId := c.Param("app_id")
+
+	grants, err := apps.GetGrants(appId)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	var grant *apps.Grant
+	for _, g := range grants {
+		if g.Id == c.Param("grant_id") {
+			grant = g
+		}
+	}
+	if grant == nil {
+		return apiErrors.GrantNotFound
+	}
+
+	err = apps.DeleteGrant(appId, grant.Id)
+	if err == apps.GrantNotFound {
+		return apiErrors.GrantNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to delete the grant")
+	}
+	audit.Record(c, "app.revoke", "app", appId, grant, nil)
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
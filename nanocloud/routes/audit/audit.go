@@ -0,0 +1,120 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package audit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/audit"
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+func parseTime(c *echo.Context, name string) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, apiErrors.InvalidRequest.Detail(name + " must be an RFC 3339 date")
+	}
+	return t, nil
+}
+
+/*
+ * List the audit events, the most recent first. They can be filtered with the
+ * actor, action, target-type, target, since and until query parameters. A
+ * page holds limit events, the next one is linked with the cursor parameter.
+ */
+func List(c *echo.Context) error {
+	filter := audit.Filter{
+		ActorId:    c.Query("actor"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target-type"),
+		TargetId:   c.Query("target"),
+		Limit:      defaultLimit,
+	}
+
+	var err error
+	filter.Since, err = parseTime(c, "since")
+	if err != nil {
+		return err
+	}
+	filter.Until, err = parseTime(c, "until")
+	if err != nil {
+		return err
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxLimit {
+			return apiErrors.InvalidRequest.Detail("limit must be between 1 and " + strconv.Itoa(maxLimit))
+		}
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		filter.Before, err = strconv.ParseInt(cursor, 10, 64)
+		if err != nil || filter.Before < 1 {
+			return apiErrors.InvalidRequest.Detail("Invalid cursor")
+		}
+	}
+
+	events, err := audit.Find(filter)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the audit events")
+	}
+
+	data := make([]hash, len(events))
+	for i, event := range events {
+		data[i] = hash{
+			"id":         event.GetID(),
+			"type":       "audit-events",
+			"attributes": event,
+		}
+	}
+
+	links := hash{}
+	if len(events) == filter.Limit {
+		r := c.Request()
+		query := r.URL.Query()
+		query.Set("cursor", events[len(events)-1].GetID())
+		links["next"] = r.URL.Path + "?" + query.Encode()
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"data":  data,
+		"links": links,
+	})
+}
@@ -16,6 +16,7 @@ import (
 	"fmt"
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
 	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/sessions"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/oauth2"
 	"github.com/Nanocloud/community/nanocloud/utils"
@@ -236,7 +237,11 @@ func Get(c *echo.Context) error {
 			return oauthError(c, fail)
 		}
 
-		user = u.(*users.User)
+		var ok bool
+		user, ok = u.(*users.User)
+		if !ok {
+			return errors.New("no authenticated user")
+		}
 	}
 
 	winUser, err := user.WindowsCredentials()
@@ -248,7 +253,13 @@ func Get(c *echo.Context) error {
 		System       string `json:"System"`
 		Architecture string `json:"Architecture"`
 	}
-	resp, err := http.Get("http://" + utils.Env("PLAZA_ADDRESS", "iaas-module") + ":" + utils.Env("PLAZA_PORT", "9090"))
+	server, err := sessions.FindServer(winUser.Sam)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.WindowsNotOnline.Detail(err.Error())
+	}
+
+	resp, err := http.Get("http://" + server + ":" + utils.Env("PLAZA_PORT", "9090"))
 	if err != nil {
 		log.Error(err)
 		return apiErrors.WindowsNotOnline.Detail(err.Error())
@@ -283,7 +294,7 @@ func Get(c *echo.Context) error {
 		path = filename
 	}
 
-	resp, err = http.Get("http://" + utils.Env("PLAZA_ADDRESS", "iaas-module") + ":" + utils.Env("PLAZA_PORT", "9090") + "/files?create=true&path=" + url.QueryEscape(path))
+	resp, err = http.Get("http://" + server + ":" + utils.Env("PLAZA_PORT", "9090") + "/files?create=true&path=" + url.QueryEscape(path))
 	if err != nil {
 		log.Error(err)
 		return apiErrors.WindowsNotOnline.Detail(err.Error())
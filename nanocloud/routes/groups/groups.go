@@ -0,0 +1,193 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package groups
+
+import (
+	"net/http"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+func List(c *echo.Context) error {
+	groupList, err := groups.FindAll()
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the group list")
+	}
+	return utils.JSON(c, http.StatusOK, groupList)
+}
+
+func Get(c *echo.Context) error {
+	group, err := groups.GetGroup(c.Param("id"))
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	if group == nil {
+		return apiErrors.GroupNotFound
+	}
+	return utils.JSON(c, http.StatusOK, group)
+}
+
+func Post(c *echo.Context) error {
+	group := groups.Group{}
+	err := utils.ParseJSONBody(c, &group)
+	if err != nil {
+		return err
+	}
+
+	if group.Name == "" {
+		return apiErrors.InvalidRequest.Detail("name is missing")
+	}
+
+	newGroup, err := groups.CreateGroup(group.Name)
+	if err == groups.GroupDuplicated {
+		return apiErrors.Conflict.Detail("A group with this name already exists")
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to create the group")
+	}
+
+	return utils.JSON(c, http.StatusCreated, newGroup)
+}
+
+func Patch(c *echo.Context) error {
+	group := groups.Group{}
+	err := utils.ParseJSONBody(c, &group)
+	if err != nil {
+		return err
+	}
+
+	if group.Name == "" {
+		return apiErrors.InvalidRequest.Detail("name is missing")
+	}
+
+	group.Id = c.Param("id")
+	err = groups.UpdateGroupName(group.Id, group.Name)
+	switch err {
+	case nil:
+	case groups.GroupNotFound:
+		return apiErrors.GroupNotFound
+	case groups.GroupDuplicated:
+		return apiErrors.Conflict.Detail("A group with this name already exists")
+	default:
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to update the group")
+	}
+
+	return utils.JSON(c, http.StatusOK, &group)
+}
+
+func Delete(c *echo.Context) error {
+	err := groups.DeleteGroup(c.Param("id"))
+	if err == groups.GroupNotFound {
+		return apiErrors.GroupNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to delete the group")
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
+
+func ListMembers(c *echo.Context) error {
+	group, err := groups.GetGroup(c.Param("id"))
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	if group == nil {
+		return apiErrors.GroupNotFound
+	}
+
+	members, err := groups.GetMembers(group.Id)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the member list")
+	}
+	return utils.JSON(c, http.StatusOK, members)
+}
+
+// Add a user to the group. The body is the user resource to add.
+func AddMember(c *echo.Context) error {
+	member := users.User{}
+	err := utils.ParseJSONBody(c, &member)
+	if err != nil {
+		return err
+	}
+
+	group, err := groups.GetGroup(c.Param("id"))
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	if group == nil {
+		return apiErrors.GroupNotFound
+	}
+
+	user, err := users.GetUser(member.GetID())
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	if user == nil {
+		return apiErrors.UserNotFound
+	}
+
+	err = groups.AddMember(group.Id, user.Id)
+	if err == groups.MemberDuplicated {
+		return apiErrors.Conflict.Detail("This user is already a member of the group")
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to add the member")
+	}
+
+	return utils.JSON(c, http.StatusCreated, user)
+}
+
+func RemoveMember(c *echo.Context) error {
+	err := groups.RemoveMember(c.Param("id"), c.Param("user_id"))
+	if err == groups.MemberNotFound {
+		return apiErrors.UserNotFound.Detail("This user is not a member of the group")
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to remove the member")
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
@@ -0,0 +1,61 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package jobs
+
+import (
+	"net/http"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/jobs"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+// List the jobs waiting to be retried and the ones that ran out of attempts
+func Get(c *echo.Context) error {
+	all, err := jobs.FindAll()
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	return utils.JSON(c, http.StatusOK, all)
+}
+
+// Run a job again as soon as possible
+func Retry(c *echo.Context) error {
+	err := jobs.Retry(c.Param("id"))
+	if err == jobs.JobNotFound {
+		return apiErrors.JobNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retry the job")
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
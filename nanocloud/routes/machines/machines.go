@@ -25,6 +25,7 @@ package machines
 import (
 	"net/http"
 
+	"github.com/Nanocloud/community/nanocloud/audit"
 	"github.com/Nanocloud/community/nanocloud/connectors/vms"
 	"github.com/Nanocloud/community/nanocloud/errors"
 	"github.com/Nanocloud/community/nanocloud/utils"
@@ -78,6 +79,12 @@ func PatchMachine(c *echo.Context) error {
 		return errors.UnableToUpdateMachineStatus
 	}
 
+	previous, err := getSerializableMachine(m.Id())
+	if err != nil {
+		log.Error(err)
+		return errors.UnableToUpdateMachineStatus
+	}
+
 	status, err := m.Status()
 	if err != nil {
 		log.Error(err)
@@ -122,6 +129,7 @@ func PatchMachine(c *echo.Context) error {
 		log.Error(err)
 		return errors.UnableToUpdateMachineStatus
 	}
+	audit.Record(c, "machine.update", "machine", rt.Id, previous, rt)
 
 	return utils.JSON(c, http.StatusOK, rt)
 }
@@ -247,6 +255,7 @@ func CreateMachine(c *echo.Context) error {
 	if err != nil {
 		return err
 	}
+	audit.Record(c, "machine.create", "machine", rt.Id, nil, rt)
 
 	return utils.JSON(c, http.StatusOK, rt)
 }
@@ -260,10 +269,17 @@ func DeleteMachine(c *echo.Context) error {
 		return errors.UnableToTerminateTheMachine
 	}
 
+	previous, err := getSerializableMachine(id)
+	if err != nil {
+		log.Error(err)
+		return errors.UnableToTerminateTheMachine
+	}
+
 	err = m.Terminate()
 	if err != nil {
 		log.Error(err)
 		return errors.UnableToTerminateTheMachine
 	}
+	audit.Record(c, "machine.terminate", "machine", id, previous, nil)
 	return c.JSON(http.StatusOK, hash{"meta": hash{}})
 }
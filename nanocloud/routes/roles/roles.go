@@ -0,0 +1,317 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package roles
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/Nanocloud/community/nanocloud/audit"
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+type permission struct {
+	Id          string `json:"-"`
+	Description string `json:"description"`
+}
+
+func (p *permission) GetID() string {
+	return p.Id
+}
+
+func (p *permission) SetID(id string) error {
+	p.Id = id
+	return nil
+}
+
+func ListPermissions(c *echo.Context) error {
+	names := make([]string, 0, len(roles.Permissions))
+	for name := range roles.Permissions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	permissions := make([]*permission, len(names))
+	for i, name := range names {
+		permissions[i] = &permission{name, roles.Permissions[name]}
+	}
+	return utils.JSON(c, http.StatusOK, permissions)
+}
+
+func List(c *echo.Context) error {
+	roleList, err := roles.FindAll()
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the role list")
+	}
+	return utils.JSON(c, http.StatusOK, roleList)
+}
+
+func Get(c *echo.Context) error {
+	role, err := roles.Get(c.Param("id"))
+	if err == roles.RoleNotFound {
+		return apiErrors.RoleNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	return utils.JSON(c, http.StatusOK, role)
+}
+
+var notHeld = apiErrors.Forbidden.Detail("Only the permissions you hold can be granted")
+
+// Whether the caller holds every one of permissions, see roles.Holds.
+// Service accounts are only limited by their scope.
+func holds(c *echo.Context, permissions []string) (bool, error) {
+	user, ok := c.Get("user").(*users.User)
+	if !ok {
+		return true, nil
+	}
+
+	allowed, err := roles.Holds(user, permissions)
+	if err != nil {
+		log.Error(err)
+		return false, apiErrors.InternalError
+	}
+	return allowed, nil
+}
+
+var notManageable = apiErrors.Forbidden.Detail("Administrators are only managed by administrators, and the users by holders of all their permissions")
+
+// Whether the caller can change the roles of target, see roles.CanManage.
+// Service accounts can't manage administrators.
+func canManage(c *echo.Context, target *users.User) (bool, error) {
+	user, ok := c.Get("user").(*users.User)
+	if !ok {
+		return !target.IsAdmin, nil
+	}
+
+	allowed, err := roles.CanManage(user, target)
+	if err != nil {
+		log.Error(err)
+		return false, apiErrors.InternalError
+	}
+	return allowed, nil
+}
+
+// Check that the caller can grant or revoke the role to the user
+func checkAssignment(c *echo.Context, userId, roleId string) (*users.User, error) {
+	user, err := users.GetUser(userId)
+	if err != nil {
+		log.Error(err)
+		return nil, apiErrors.InternalError
+	}
+	if user == nil {
+		return nil, apiErrors.UserNotFound
+	}
+
+	role, err := roles.Get(roleId)
+	if err == roles.RoleNotFound {
+		return nil, apiErrors.RoleNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return nil, apiErrors.InternalError
+	}
+
+	allowed, err := holds(c, role.Permissions)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, notHeld
+	}
+
+	allowed, err = canManage(c, user)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, notManageable
+	}
+	return user, nil
+}
+
+// Map the errors shared by the role creation and update
+func roleError(err error, action string) error {
+	switch err {
+	case roles.RoleNotFound:
+		return apiErrors.RoleNotFound
+	case roles.RoleDuplicated:
+		return apiErrors.Conflict.Detail("A role with this name already exists")
+	case roles.RoleBuiltin:
+		return apiErrors.RoleBuiltin
+	case roles.UnknownPermission:
+		return apiErrors.InvalidRequest.Detail("Unknown permission")
+	}
+	log.Error(err)
+	return apiErrors.InternalError.Detail("Unable to " + action + " the role")
+}
+
+func Post(c *echo.Context) error {
+	role := roles.Role{}
+	err := utils.ParseJSONBody(c, &role)
+	if err != nil {
+		return err
+	}
+
+	if role.Name == "" {
+		return apiErrors.InvalidRequest.Detail("name is missing")
+	}
+
+	allowed, err := holds(c, role.Permissions)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return notHeld
+	}
+
+	role.Builtin = false
+	err = roles.Create(&role)
+	if err != nil {
+		return roleError(err, "create")
+	}
+	audit.Record(c, "role.create", "role", role.Id, nil, &role)
+
+	return utils.JSON(c, http.StatusCreated, &role)
+}
+
+func Patch(c *echo.Context) error {
+	role := roles.Role{}
+	err := utils.ParseJSONBody(c, &role)
+	if err != nil {
+		return err
+	}
+
+	if role.Name == "" {
+		return apiErrors.InvalidRequest.Detail("name is missing")
+	}
+
+	role.Id = c.Param("id")
+	previous, err := roles.Get(role.Id)
+	if err != nil {
+		return roleError(err, "update")
+	}
+
+	// the permissions removed are taken from the holders of the role
+	allowed, err := holds(c, append(role.Permissions, previous.Permissions...))
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return notHeld
+	}
+
+	err = roles.Update(&role)
+	if err != nil {
+		return roleError(err, "update")
+	}
+	audit.Record(c, "role.update", "role", role.Id, previous, &role)
+
+	return utils.JSON(c, http.StatusOK, &role)
+}
+
+func Delete(c *echo.Context) error {
+	role, err := roles.Get(c.Param("id"))
+	if err != nil {
+		return roleError(err, "delete")
+	}
+
+	err = roles.Delete(role.Id)
+	if err != nil {
+		return roleError(err, "delete")
+	}
+	audit.Record(c, "role.delete", "role", role.Id, role, nil)
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
+
+func ListUserRoles(c *echo.Context) error {
+	user, err := users.GetUser(c.Param("id"))
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	if user == nil {
+		return apiErrors.UserNotFound
+	}
+
+	roleList, err := roles.UserRoles(user.Id)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the role list")
+	}
+	return utils.JSON(c, http.StatusOK, roleList)
+}
+
+func Assign(c *echo.Context) error {
+	user, err := checkAssignment(c, c.Param("id"), c.Param("role_id"))
+	if err != nil {
+		return err
+	}
+
+	err = roles.Assign(user.Id, c.Param("role_id"))
+	if err == roles.RoleNotFound {
+		return apiErrors.RoleNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to grant the role")
+	}
+	audit.Record(c, "role.assign", "user", user.Id, nil, hash{"role-id": c.Param("role_id")})
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
+
+func Unassign(c *echo.Context) error {
+	_, err := checkAssignment(c, c.Param("id"), c.Param("role_id"))
+	if err != nil {
+		return err
+	}
+
+	err = roles.Unassign(c.Param("id"), c.Param("role_id"))
+	if err == roles.RoleNotFound {
+		return apiErrors.RoleNotFound.Detail("This user doesn't hold the role")
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to revoke the role")
+	}
+	audit.Record(c, "role.unassign", "user", c.Param("id"), hash{"role-id": c.Param("role_id")}, nil)
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
@@ -0,0 +1,223 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package saml
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/models/lockouts"
+	"github.com/Nanocloud/community/nanocloud/models/mfa"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/saml"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+// nil if SAML isn't configured
+var kServiceProvider *saml.ServiceProvider
+
+// OAuth client the tokens of the SAML users are delivered to
+var kClientKey string
+
+// Where the user agent is sent back with the access token in the fragment
+var kRedirectURL string
+
+func init() {
+	kClientKey = utils.Env("SAML_OAUTH_CLIENT", "9405fb6b0e59d2997e3c777a22d8f0e617a9f5b36b6565c7579e5be6deb8f7ae")
+	kRedirectURL = utils.Env("SAML_REDIRECT_URL", "/")
+
+	certificatePath := utils.Env("SAML_IDP_CERTIFICATE", "")
+	if certificatePath == "" {
+		return
+	}
+
+	data, err := ioutil.ReadFile(certificatePath)
+	if err != nil {
+		log.Errorf("Unable to read the SAML IdP certificate: %s", err.Error())
+		return
+	}
+
+	certificate, err := saml.ParseCertificate(data)
+	if err != nil {
+		log.Errorf("Invalid SAML IdP certificate: %s", err.Error())
+		return
+	}
+
+	baseURL := strings.TrimRight(utils.Env("SAML_BASE_URL", ""), "/")
+	if baseURL == "" {
+		log.Error("SAML_BASE_URL must be set to enable SAML")
+		return
+	}
+
+	kServiceProvider = &saml.ServiceProvider{
+		EntityID:       baseURL + "/saml/metadata",
+		ACSURL:         baseURL + "/saml/acs",
+		IdPEntityID:    utils.Env("SAML_IDP_ENTITY_ID", ""),
+		IdPCertificate: certificate,
+	}
+}
+
+func Metadata(w http.ResponseWriter, r *http.Request) {
+	if kServiceProvider == nil {
+		http.Error(w, "SAML is not configured", http.StatusNotFound)
+		return
+	}
+
+	metadata, err := kServiceProvider.Metadata()
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(metadata)
+}
+
+// Return the Nanocloud user matching the assertion, created if needed. The
+// email is read from the usual attributes or from the NameID.
+func assertionUser(assertion *saml.Assertion) (*users.User, error) {
+	email := assertion.Attribute(
+		"email", "mail",
+		"urn:oid:0.9.2342.19200300.100.1.3",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+	)
+	if email == "" && strings.Contains(assertion.NameID, "@") {
+		email = assertion.NameID
+	}
+	if email == "" {
+		return nil, nil
+	}
+
+	firstName := assertion.Attribute(
+		"givenName", "urn:oid:2.5.4.42",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname",
+	)
+	lastName := assertion.Attribute(
+		"sn", "surname", "urn:oid:2.5.4.4",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname",
+	)
+
+	return users.ProvisionSAMLUser(assertion.Issuer, assertion.NameID, email, firstName, lastName)
+}
+
+// A transient NameID changes at each login, it can't identify a user
+const transientNameID = "urn:oasis:names:tc:SAML:2.0:nameid-format:transient"
+
+// Assertion Consumer Service, receives the responses of the IdP (HTTP-POST
+// binding) and logs the user in.
+func ACS(w http.ResponseWriter, r *http.Request) {
+	if kServiceProvider == nil {
+		http.Error(w, "SAML is not configured", http.StatusNotFound)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	assertion, err := kServiceProvider.ParseResponse(r.FormValue("SAMLResponse"))
+	if err != nil {
+		log.Warnf("SAML response rejected: %s", err.Error())
+		http.Error(w, "Invalid SAML response", http.StatusForbidden)
+		return
+	}
+
+	if assertion.NameID == "" || assertion.NameIDFormat == transientNameID {
+		log.Warnf("SAML assertion of %s rejected: no persistent NameID", assertion.Issuer)
+		http.Error(w, "A persistent NameID is required", http.StatusForbidden)
+		return
+	}
+
+	user, err := assertionUser(assertion)
+	if err == users.NotFromSAML {
+		log.Warnf("SAML login of %s refused: the account isn't provisioned by SAML", assertion.NameID)
+		http.Error(w, "This account can't sign in with SAML", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	if user == nil {
+		log.Warnf("No email in the SAML assertion of %s", assertion.NameID)
+		http.Error(w, "No email in the SAML assertion", http.StatusForbidden)
+		return
+	}
+
+	if !user.Activated {
+		http.Error(w, "User disabled", http.StatusForbidden)
+		return
+	}
+
+	// the checks of the password grant that still apply without a password
+	locked, err := lockouts.Check(user.Email, oauth.ClientIP(r))
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	if locked > 0 {
+		http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+		return
+	}
+
+	// the assertion can't carry the second factor code
+	mfaEnabled, err := mfa.IsEnabled(user.Id)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	if mfaEnabled {
+		http.Error(w, "Sign in with your password and second factor code", http.StatusForbidden)
+		return
+	}
+
+	accessToken, err := oauth.CreateAccessToken(user, kClientKey, r)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	fragment := url.Values{}
+	fragment.Set("access_token", accessToken.Token)
+	fragment.Set("token_type", accessToken.Type)
+	fragment.Set("expires_in", strconv.Itoa(int(accessToken.ExpiresIn)))
+	if accessToken.RefreshToken != "" {
+		fragment.Set("refresh_token", accessToken.RefreshToken)
+	}
+
+	http.Redirect(w, r, kRedirectURL+"#"+fragment.Encode(), http.StatusFound)
+}
@@ -0,0 +1,303 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The attributes of a resource a filter is applied to, by lowercase path
+// ("username", "name.givenname"). Booleans are "true" or "false".
+type attributes map[string][]string
+
+type filter interface {
+	match(attrs attributes) bool
+}
+
+type comparison struct {
+	path  string
+	op    string
+	value string
+}
+
+type and []filter
+type or []filter
+
+type not struct {
+	filter filter
+}
+
+// Compare the values case-insensitively, none of the attributes exposed is
+// case exact
+func (f comparison) match(attrs attributes) bool {
+	values := attrs[f.path]
+	if f.op == "pr" {
+		return len(values) > 0
+	}
+	if f.op == "ne" {
+		return !comparison{f.path, "eq", f.value}.match(attrs)
+	}
+
+	expected := strings.ToLower(f.value)
+	for _, value := range values {
+		value = strings.ToLower(value)
+
+		var matched bool
+		switch f.op {
+		case "eq":
+			matched = value == expected
+		case "co":
+			matched = strings.Contains(value, expected)
+		case "sw":
+			matched = strings.HasPrefix(value, expected)
+		case "ew":
+			matched = strings.HasSuffix(value, expected)
+		case "gt":
+			matched = value > expected
+		case "ge":
+			matched = value >= expected
+		case "lt":
+			matched = value < expected
+		case "le":
+			matched = value <= expected
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (f and) match(attrs attributes) bool {
+	for _, sub := range f {
+		if !sub.match(attrs) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f or) match(attrs attributes) bool {
+	for _, sub := range f {
+		if sub.match(attrs) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f not) match(attrs attributes) bool {
+	return !f.filter.match(attrs)
+}
+
+var InvalidFilter = errors.New("invalid filter")
+
+var operators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+// Split a filter in tokens: parentheses, quoted strings and words
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return nil, InvalidFilter
+			}
+			tokens = append(tokens, s[i:end+1])
+			i = end + 1
+		default:
+			end := i
+			for end < len(s) && strings.IndexByte(" ()\"", s[end]) < 0 {
+				end++
+			}
+			tokens = append(tokens, s[i:end])
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+// expression = term *("or" term)
+func (p *parser) expression() (filter, error) {
+	var terms or
+	for {
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+
+		if strings.ToLower(p.peek()) != "or" {
+			break
+		}
+		p.next()
+	}
+
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+// term = factor *("and" factor)
+func (p *parser) term() (filter, error) {
+	var factors and
+	for {
+		factor, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		factors = append(factors, factor)
+
+		if strings.ToLower(p.peek()) != "and" {
+			break
+		}
+		p.next()
+	}
+
+	if len(factors) == 1 {
+		return factors[0], nil
+	}
+	return factors, nil
+}
+
+// factor = "not" factor / "(" expression ")" / path "pr" / path op value
+func (p *parser) factor() (filter, error) {
+	token := p.next()
+	switch strings.ToLower(token) {
+	case "":
+		return nil, InvalidFilter
+	case "not":
+		sub, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return not{sub}, nil
+	case "(":
+		sub, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, InvalidFilter
+		}
+		return sub, nil
+	}
+
+	path := attributePath(token)
+	op := strings.ToLower(p.next())
+	if !operators[op] {
+		return nil, InvalidFilter
+	}
+	if op == "pr" {
+		return comparison{path: path, op: op}, nil
+	}
+
+	value, err := literal(p.next())
+	if err != nil {
+		return nil, err
+	}
+	return comparison{path, op, value}, nil
+}
+
+// Lowercase an attribute path and strip its schema
+// ("urn:ietf:params:scim:schemas:core:2.0:User:userName" gives "username")
+func attributePath(path string) string {
+	path = strings.ToLower(path)
+	if strings.HasPrefix(path, "urn:") {
+		path = path[strings.LastIndex(path, ":")+1:]
+	}
+	return path
+}
+
+// Decode a JSON string, boolean, number or null
+func literal(token string) (string, error) {
+	var value interface{}
+	err := json.Unmarshal([]byte(token), &value)
+	if err != nil {
+		return "", InvalidFilter
+	}
+
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case nil:
+		return "", nil
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
+
+// Parse a filter (RFC 7644 section 3.4.2.2), the complex attribute filters
+// ("emails[type eq \"work\"]") aren't supported
+func parseFilter(s string) (filter, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser{tokens: tokens}
+	f, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(tokens) {
+		return nil, InvalidFilter
+	}
+	return f, nil
+}
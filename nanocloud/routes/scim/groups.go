@@ -0,0 +1,396 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/labstack/echo"
+)
+
+type member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type groupResource struct {
+	Schemas     []string `json:"schemas"`
+	Id          string   `json:"id,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []member `json:"members"`
+	Meta        *meta    `json:"meta,omitempty"`
+}
+
+func groupResourceOf(c *echo.Context, group *groups.Group) (*groupResource, error) {
+	members, err := groups.GetMembers(group.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	res := groupResource{
+		Schemas:     []string{groupSchema},
+		Id:          group.Id,
+		DisplayName: group.Name,
+		Members:     make([]member, len(members)),
+		Meta: &meta{
+			ResourceType: "Group",
+			Location:     location(c, "Groups/"+group.Id),
+		},
+	}
+
+	for i, user := range members {
+		res.Members[i] = member{
+			Value:   user.Id,
+			Display: user.Email,
+			Ref:     location(c, "Users/"+user.Id),
+		}
+	}
+	return &res, nil
+}
+
+func groupAttributes(res *groupResource) attributes {
+	attrs := attributes{
+		"id":          {res.Id},
+		"displayname": {res.DisplayName},
+	}
+	for _, m := range res.Members {
+		attrs["members"] = append(attrs["members"], m.Value)
+		attrs["members.value"] = append(attrs["members.value"], m.Value)
+	}
+	return attrs
+}
+
+func findGroup(id string) (*groups.Group, error) {
+	group, err := groups.GetGroup(id)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, &scimError{http.StatusNotFound, "", "Group " + id + " not found"}
+	}
+	return group, nil
+}
+
+func decodeGroup(c *echo.Context) (*groupResource, error) {
+	res := groupResource{}
+	err := decode(c, &res)
+	if err != nil {
+		return nil, &scimError{http.StatusBadRequest, "invalidSyntax", "The group is not valid JSON"}
+	}
+	if res.DisplayName == "" {
+		return nil, invalidValue("displayName is missing")
+	}
+	return &res, nil
+}
+
+func ListGroups(c *echo.Context) error {
+	all, err := groups.FindAll()
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	resources := make([]*groupResource, len(all))
+	attrs := make([]attributes, len(all))
+	for i, group := range all {
+		resources[i], err = groupResourceOf(c, group)
+		if err != nil {
+			return failWith(c, err)
+		}
+		attrs[i] = groupAttributes(resources[i])
+	}
+
+	list, selected, err := page(c, attrs)
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	listed := make([]*groupResource, len(selected))
+	for i, index := range selected {
+		listed[i] = resources[index]
+	}
+	list.Resources = listed
+	return reply(c, http.StatusOK, list)
+}
+
+func replyGroup(c *echo.Context, status int, id string) error {
+	group, err := findGroup(id)
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	res, err := groupResourceOf(c, group)
+	if err != nil {
+		return failWith(c, err)
+	}
+	return reply(c, status, res)
+}
+
+func GetGroup(c *echo.Context) error {
+	return replyGroup(c, http.StatusOK, c.Param("id"))
+}
+
+// Apply the name and the members of the resource to the group
+func updateGroup(group *groups.Group, res *groupResource) error {
+	if res.DisplayName != group.Name {
+		err := groups.UpdateGroupName(group.Id, res.DisplayName)
+		if err == groups.GroupDuplicated {
+			return &scimError{http.StatusConflict, "uniqueness", "displayName is already used"}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	current, err := groups.GetMembers(group.Id)
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]bool, len(res.Members))
+	for _, m := range res.Members {
+		wanted[m.Value] = true
+	}
+
+	for _, user := range current {
+		if wanted[user.Id] {
+			delete(wanted, user.Id)
+			continue
+		}
+
+		err = groups.RemoveMember(group.Id, user.Id)
+		if err != nil && err != groups.MemberNotFound {
+			return err
+		}
+	}
+
+	for id := range wanted {
+		exists, err := users.UserExists(id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return invalidValue("User " + id + " not found")
+		}
+
+		err = groups.AddMember(group.Id, id)
+		if err != nil && err != groups.MemberDuplicated {
+			return err
+		}
+	}
+	return nil
+}
+
+func CreateGroup(c *echo.Context) error {
+	res, err := decodeGroup(c)
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	group, err := groups.CreateGroup(res.DisplayName)
+	if err == groups.GroupDuplicated {
+		return fail(c, http.StatusConflict, "uniqueness", "displayName is already used")
+	}
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	err = updateGroup(group, res)
+	if err != nil {
+		// don't leave a group with part of its members
+		groups.DeleteGroup(group.Id)
+		return failWith(c, err)
+	}
+	return replyGroup(c, http.StatusCreated, group.Id)
+}
+
+func ReplaceGroup(c *echo.Context) error {
+	group, err := findGroup(c.Param("id"))
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	res, err := decodeGroup(c)
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	err = updateGroup(group, res)
+	if err != nil {
+		return failWith(c, err)
+	}
+	return replyGroup(c, http.StatusOK, group.Id)
+}
+
+func decodeMembers(value json.RawMessage) ([]member, error) {
+	var members []member
+	err := json.Unmarshal(value, &members)
+	if err != nil {
+		return nil, invalidValue("members must be an array of objects")
+	}
+	return members, nil
+}
+
+// Remove the members matching a filter, or the ones listed
+func removeMembers(res *groupResource, f filter, removed []member) {
+	gone := make(map[string]bool, len(removed))
+	for _, m := range removed {
+		gone[m.Value] = true
+	}
+
+	kept := make([]member, 0, len(res.Members))
+	for _, m := range res.Members {
+		attrs := attributes{"value": {m.Value}, "display": {m.Display}}
+		if gone[m.Value] || (f != nil && f.match(attrs)) {
+			continue
+		}
+		kept = append(kept, m)
+	}
+	res.Members = kept
+}
+
+func setGroupAttribute(res *groupResource, op string, path string, value json.RawMessage) error {
+	switch path {
+	case "displayname":
+		name, err := stringValue(value)
+		if err != nil {
+			return err
+		}
+		res.DisplayName = name
+	case "members":
+		members, err := decodeMembers(value)
+		if err != nil {
+			return err
+		}
+		if op == "replace" {
+			res.Members = members
+		} else {
+			res.Members = append(res.Members, members...)
+		}
+	}
+	return nil
+}
+
+func applyGroupOperation(res *groupResource, operation patchOperation) error {
+	op, err := operation.kind()
+	if err != nil {
+		return err
+	}
+	path := attributePath(operation.Path)
+
+	if op == "remove" {
+		switch {
+		case path == "displayname":
+			return &scimError{http.StatusBadRequest, "mutability", "displayName is required"}
+		case path == "members":
+			var removed []member
+			if operation.hasValue() {
+				removed, err = decodeMembers(operation.Value)
+				if err != nil {
+					return err
+				}
+			} else {
+				removed = res.Members
+			}
+			removeMembers(res, nil, removed)
+		case strings.HasPrefix(path, "members[") && strings.HasSuffix(path, "]"):
+			// the values are compared case-insensitively anyway
+			f, err := parseFilter(path[len("members[") : len(path)-1])
+			if err != nil {
+				return &scimError{http.StatusBadRequest, "invalidPath", "The path is not valid"}
+			}
+			removeMembers(res, f, nil)
+		case path == "":
+			return noTarget("A remove operation needs a path")
+		}
+		return nil
+	}
+
+	if path != "" {
+		return setGroupAttribute(res, op, path, operation.Value)
+	}
+
+	values, err := operation.values()
+	if err != nil {
+		return err
+	}
+	for path, value := range values {
+		err = setGroupAttribute(res, op, path, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func PatchGroup(c *echo.Context) error {
+	group, err := findGroup(c.Param("id"))
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	req := patchRequest{}
+	err = decode(c, &req)
+	if err != nil {
+		return fail(c, http.StatusBadRequest, "invalidSyntax", "The patch request is not valid JSON")
+	}
+
+	res, err := groupResourceOf(c, group)
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	for _, operation := range req.Operations {
+		err = applyGroupOperation(res, operation)
+		if err != nil {
+			return failWith(c, err)
+		}
+	}
+
+	if res.DisplayName == "" {
+		return fail(c, http.StatusBadRequest, "invalidValue", "displayName is missing")
+	}
+
+	err = updateGroup(group, res)
+	if err != nil {
+		return failWith(c, err)
+	}
+	return replyGroup(c, http.StatusOK, group.Id)
+}
+
+func DeleteGroup(c *echo.Context) error {
+	err := groups.DeleteGroup(c.Param("id"))
+	if err == groups.GroupNotFound {
+		return fail(c, http.StatusNotFound, "", "Group "+c.Param("id")+" not found")
+	}
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	c.Response().WriteHeader(http.StatusNoContent)
+	return nil
+}
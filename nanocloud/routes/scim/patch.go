@@ -0,0 +1,102 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type patchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []patchOperation `json:"Operations"`
+}
+
+// Patch operations are "add", "replace" or "remove", whatever their case
+func (o patchOperation) kind() (string, error) {
+	op := strings.ToLower(o.Op)
+	switch op {
+	case "add", "replace", "remove":
+		return op, nil
+	}
+	return "", invalidValue("Unknown operation \"" + o.Op + "\"")
+}
+
+func (o patchOperation) hasValue() bool {
+	return len(o.Value) > 0 && string(o.Value) != "null"
+}
+
+// The attributes set by an operation without path
+func (o patchOperation) values() (map[string]json.RawMessage, error) {
+	values := make(map[string]json.RawMessage)
+	err := json.Unmarshal(o.Value, &values)
+	if err != nil {
+		return nil, invalidValue("An operation without path must have an object as value")
+	}
+
+	lower := make(map[string]json.RawMessage, len(values))
+	for path, value := range values {
+		lower[attributePath(path)] = value
+	}
+	return lower, nil
+}
+
+func noTarget(detail string) error {
+	return &scimError{http.StatusBadRequest, "noTarget", detail}
+}
+
+func stringValue(raw json.RawMessage) (string, error) {
+	var s string
+	err := json.Unmarshal(raw, &s)
+	if err != nil {
+		return "", invalidValue("A string is expected")
+	}
+	return s, nil
+}
+
+// Some identity providers send the booleans as strings ("True")
+func boolValue(raw json.RawMessage) (bool, error) {
+	var b bool
+	err := json.Unmarshal(raw, &b)
+	if err == nil {
+		return b, nil
+	}
+
+	var s string
+	err = json.Unmarshal(raw, &s)
+	if err == nil {
+		b, err = strconv.ParseBool(strings.ToLower(s))
+		if err == nil {
+			return b, nil
+		}
+	}
+	return false, invalidValue("A boolean is expected")
+}
@@ -0,0 +1,222 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package scim implements the SCIM 2.0 protocol (RFC 7643 and RFC 7644) so
+// that identity providers can provision the users and the groups.
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+const (
+	userSchema      = "urn:ietf:params:scim:schemas:core:2.0:User"
+	groupSchema     = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	listSchema      = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	patchSchema     = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	errorSchema     = "urn:ietf:params:scim:api:messages:2.0:Error"
+	providerSchema  = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+type hash map[string]interface{}
+
+type meta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	Location     string `json:"location"`
+}
+
+type listResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+func reply(c *echo.Context, status int, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	r := c.Response()
+	r.Header().Set("Content-Type", "application/scim+json")
+	r.WriteHeader(status)
+	r.Write(b)
+	return nil
+}
+
+// Reply with a SCIM error, scimType is empty or one of the types of RFC 7644
+// section 3.12
+func fail(c *echo.Context, status int, scimType string, detail string) error {
+	body := hash{
+		"schemas": []string{errorSchema},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	return reply(c, status, body)
+}
+
+// An error to send to the client
+type scimError struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e *scimError) Error() string {
+	return e.detail
+}
+
+func invalidValue(detail string) error {
+	return &scimError{http.StatusBadRequest, "invalidValue", detail}
+}
+
+// Reply with the error if it is meant for the client, the other errors are
+// logged and reported as internal errors
+func failWith(c *echo.Context, err error) error {
+	if e, ok := err.(*scimError); ok {
+		return fail(c, e.status, e.scimType, e.detail)
+	}
+	if err == InvalidFilter {
+		return fail(c, http.StatusBadRequest, "invalidFilter", "The filter is not valid")
+	}
+
+	log.Error(err)
+	return fail(c, http.StatusInternalServerError, "", "An internal error occurred")
+}
+
+func decode(c *echo.Context, dest interface{}) error {
+	return json.NewDecoder(c.Request().Body).Decode(dest)
+}
+
+// URL of a resource
+func location(c *echo.Context, path string) string {
+	r := c.Request()
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + "/scim/v2/" + path
+}
+
+// Select the resources matching the filter parameter and return the page
+// asked with startIndex and count
+func page(c *echo.Context, all []attributes) (*listResponse, []int, error) {
+	var f filter
+	if query := strings.TrimSpace(c.Query("filter")); query != "" {
+		var err error
+		f, err = parseFilter(query)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var matching []int
+	for i, attrs := range all {
+		if f == nil || f.match(attrs) {
+			matching = append(matching, i)
+		}
+	}
+
+	startIndex, err := strconv.Atoi(c.Query("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+
+	count, err := strconv.Atoi(c.Query("count"))
+	if err != nil {
+		count = defaultPageSize
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > maxPageSize {
+		count = maxPageSize
+	}
+
+	list := listResponse{
+		Schemas:      []string{listSchema},
+		TotalResults: len(matching),
+		StartIndex:   startIndex,
+	}
+
+	from := startIndex - 1
+	if from > len(matching) {
+		from = len(matching)
+	}
+	to := from + count
+	if to > len(matching) {
+		to = len(matching)
+	}
+
+	selected := matching[from:to]
+	list.ItemsPerPage = len(selected)
+	return &list, selected, nil
+}
+
+func ServiceProviderConfig(c *echo.Context) error {
+	return reply(c, http.StatusOK, hash{
+		"schemas": []string{providerSchema},
+		"patch":   hash{"supported": true},
+		"bulk": hash{
+			"supported":      false,
+			"maxOperations":  0,
+			"maxPayloadSize": 0,
+		},
+		"filter": hash{
+			"supported":  true,
+			"maxResults": maxPageSize,
+		},
+		"changePassword": hash{"supported": true},
+		"sort":           hash{"supported": false},
+		"etag":           hash{"supported": false},
+		"authenticationSchemes": []hash{
+			hash{
+				"type":        "oauthbearertoken",
+				"name":        "OAuth Bearer Token",
+				"description": "Token of a client credentials grant holding the scim scope",
+				"primary":     true,
+			},
+		},
+		"meta": meta{
+			ResourceType: "ServiceProviderConfig",
+			Location:     location(c, "ServiceProviderConfig"),
+		},
+	})
+}
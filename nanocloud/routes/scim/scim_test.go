@@ -0,0 +1,196 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package scim
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+var jdoe = attributes{
+	"id":              {"6a2c4e"},
+	"username":        {"John.Doe@example.com"},
+	"name.givenname":  {"John"},
+	"name.familyname": {"Doe"},
+	"active":          {"true"},
+}
+
+func TestFilter(t *testing.T) {
+	cases := map[string]bool{
+		`userName eq "john.doe@example.com"`:                             true,
+		`USERNAME Eq "John.Doe@example.com"`:                             true,
+		`urn:ietf:params:scim:schemas:core:2.0:User:userName sw "john."`: true,
+		`userName ew "@example.org"`:                                     false,
+		`userName co "doe"`:                                              true,
+		`userName ne "john.doe@example.com"`:                             false,
+		`active eq true`:                                                 true,
+		`name.givenName eq "John" and name.familyName eq "Smith"`:        false,
+		`name.givenName eq "Jane" or name.familyName eq "Doe"`:           true,
+		`not (active eq false)`:                                          true,
+		`(id eq "x" or id eq "6a2c4e") and title pr`:                     false,
+		`name.givenName pr and userName eq "a \"quoted\" name"`:          false,
+		`userName gt "j" and userName lt "k"`:                            true,
+	}
+
+	for query, expected := range cases {
+		f, err := parseFilter(query)
+		if err != nil {
+			t.Errorf("%s: %s", query, err)
+			continue
+		}
+		if f.match(jdoe) != expected {
+			t.Errorf("%s should match: %t", query, expected)
+		}
+	}
+}
+
+func TestInvalidFilter(t *testing.T) {
+	invalid := []string{
+		`userName`,
+		`userName eq`,
+		`userName is "john"`,
+		`userName eq "john`,
+		`(userName eq "john"`,
+		`userName eq "john" and`,
+		`userName eq john`,
+	}
+
+	for _, query := range invalid {
+		_, err := parseFilter(query)
+		if err != InvalidFilter {
+			t.Errorf("%s: expected InvalidFilter, got %v", query, err)
+		}
+	}
+}
+
+func operations(t *testing.T, s string) []patchOperation {
+	req := patchRequest{}
+	err := json.Unmarshal([]byte(s), &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req.Operations
+}
+
+func TestPatchUser(t *testing.T) {
+	res := userResource{
+		UserName: "jdoe@example.com",
+		Name:     name{"John", "Doe"},
+		Active:   true,
+	}
+
+	ops := operations(t, `{"Operations": [
+		{"op": "Replace", "path": "name.givenName", "value": "Johnny"},
+		{"op": "replace", "value": {"active": "False", "userName": "johnny@example.com"}},
+		{"op": "add", "path": "externalId", "value": "00u1"}
+	]}`)
+
+	for _, op := range ops {
+		err := applyUserOperation(&res, op)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if res.Name.GivenName != "Johnny" || res.Name.FamilyName != "Doe" {
+		t.Errorf("unexpected name: %+v", res.Name)
+	}
+	if res.Active || res.UserName != "johnny@example.com" {
+		t.Errorf("unexpected user: %+v", res)
+	}
+
+	for _, invalid := range []string{
+		`{"Operations": [{"op": "move", "path": "active", "value": true}]}`,
+		`{"Operations": [{"op": "remove", "path": "userName"}]}`,
+		`{"Operations": [{"op": "replace", "path": "active", "value": "maybe"}]}`,
+		`{"Operations": [{"op": "replace", "value": "jdoe"}]}`,
+	} {
+		err := applyUserOperation(&res, operations(t, invalid)[0])
+		if _, ok := err.(*scimError); !ok {
+			t.Errorf("%s: expected a SCIM error, got %v", invalid, err)
+		}
+	}
+}
+
+func TestAdminMutability(t *testing.T) {
+	admin := users.User{Email: "admin@nanocloud.com", IsAdmin: true, Activated: true}
+
+	renamed := userResource{Name: name{"Jane", "Admin"}, UserName: admin.Email, Active: true}
+	err := checkAdminMutability(&admin, &renamed)
+	if err != nil {
+		t.Errorf("the name of an administrator should be writable, got %v", err)
+	}
+
+	for _, res := range []userResource{
+		{UserName: "attacker@example.com", Active: true},
+		{UserName: admin.Email, Password: "Nanocloud123+", Active: true},
+		{UserName: admin.Email, Active: false},
+	} {
+		err = checkAdminMutability(&admin, &res)
+		scimErr, ok := err.(*scimError)
+		if !ok || scimErr.scimType != "mutability" {
+			t.Errorf("%+v: expected a mutability error, got %v", res, err)
+		}
+	}
+
+	user := users.User{Email: "jdoe@example.com", Activated: true}
+	err = checkAdminMutability(&user, &userResource{UserName: "john@example.com", Password: "Nanocloud123+"})
+	if err != nil {
+		t.Errorf("the users should be writable, got %v", err)
+	}
+}
+
+func TestPatchGroup(t *testing.T) {
+	res := groupResource{
+		DisplayName: "Sales",
+		Members:     []member{{Value: "a"}, {Value: "b"}, {Value: "c"}},
+	}
+
+	ops := operations(t, `{"Operations": [
+		{"op": "remove", "path": "members[value eq \"b\"]"},
+		{"op": "add", "path": "members", "value": [{"value": "d"}]},
+		{"op": "remove", "path": "members", "value": [{"value": "a"}]},
+		{"op": "replace", "value": {"displayName": "Sales EMEA"}}
+	]}`)
+
+	for _, op := range ops {
+		err := applyGroupOperation(&res, op)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if res.DisplayName != "Sales EMEA" {
+		t.Errorf("unexpected name: %s", res.DisplayName)
+	}
+	if len(res.Members) != 2 || res.Members[0].Value != "c" || res.Members[1].Value != "d" {
+		t.Errorf("unexpected members: %+v", res.Members)
+	}
+
+	err := applyGroupOperation(&res, operations(t, `{"Operations": [{"op": "remove", "path": "members"}]}`)[0])
+	if err != nil || len(res.Members) != 0 {
+		t.Errorf("every member should be removed: %v %+v", err, res.Members)
+	}
+}
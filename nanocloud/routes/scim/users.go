@@ -0,0 +1,474 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/models/passwords"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/provisioning"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	"github.com/labstack/echo"
+)
+
+type name struct {
+	GivenName  string `json:"givenName"`
+	FamilyName string `json:"familyName"`
+}
+
+type email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary"`
+}
+
+// The userName of the users is their email, the other emails are ignored.
+// The password is write only.
+type userResource struct {
+	Schemas  []string `json:"schemas"`
+	Id       string   `json:"id,omitempty"`
+	UserName string   `json:"userName"`
+	Name     name     `json:"name"`
+	Emails   []email  `json:"emails"`
+	Active   bool     `json:"active"`
+	Password string   `json:"password,omitempty"`
+	Meta     *meta    `json:"meta,omitempty"`
+}
+
+func userResourceOf(c *echo.Context, user *users.User) *userResource {
+	res := userResource{
+		Schemas:  []string{userSchema},
+		Id:       user.Id,
+		UserName: user.Email,
+		Name: name{
+			GivenName:  user.FirstName,
+			FamilyName: user.LastName,
+		},
+		Emails: []email{
+			{Value: user.Email, Type: "work", Primary: true},
+		},
+		Active: user.Activated,
+		Meta: &meta{
+			ResourceType: "User",
+			Location:     location(c, "Users/"+user.Id),
+		},
+	}
+
+	if user.SignupDate > 0 {
+		// the signup date is in milliseconds
+		created := time.Unix(int64(user.SignupDate/1000), 0)
+		res.Meta.Created = created.UTC().Format(time.RFC3339)
+	}
+	return &res
+}
+
+func userAttributes(user *users.User) attributes {
+	return attributes{
+		"id":              {user.Id},
+		"username":        {user.Email},
+		"emails":          {user.Email},
+		"emails.value":    {user.Email},
+		"name.givenname":  {user.FirstName},
+		"name.familyname": {user.LastName},
+		"active":          {strconv.FormatBool(user.Activated)},
+	}
+}
+
+// Decode the user sent, its userName defaults to its primary email
+func decodeUser(c *echo.Context) (*userResource, error) {
+	res := userResource{Active: true}
+	err := decode(c, &res)
+	if err != nil {
+		return nil, &scimError{http.StatusBadRequest, "invalidSyntax", "The user is not valid JSON"}
+	}
+
+	if res.UserName == "" {
+		for _, e := range res.Emails {
+			if res.UserName == "" || e.Primary {
+				res.UserName = e.Value
+			}
+		}
+	}
+	return &res, nil
+}
+
+func checkPassword(user *users.User, password string) error {
+	violations, err := passwords.Check(user.Id, password, passwords.PersonalWords(user)...)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+
+	details := make([]string, len(violations))
+	for i, violation := range violations {
+		details[i] = violation.Detail
+	}
+	return invalidValue(strings.Join(details, ", "))
+}
+
+func emailTaken(email string, userId string) error {
+	existing, err := users.GetUserFromEmail(email)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.Id != userId {
+		return &scimError{http.StatusConflict, "uniqueness", "userName is already used"}
+	}
+	return nil
+}
+
+func findUser(id string) (*users.User, error) {
+	user, err := users.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, &scimError{http.StatusNotFound, "", "User " + id + " not found"}
+	}
+	return user, nil
+}
+
+func ListUsers(c *echo.Context) error {
+	all, err := users.FindUsers()
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	attrs := make([]attributes, len(all))
+	for i, user := range all {
+		attrs[i] = userAttributes(user)
+	}
+
+	list, selected, err := page(c, attrs)
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	resources := make([]*userResource, len(selected))
+	for i, index := range selected {
+		resources[i] = userResourceOf(c, all[index])
+	}
+	list.Resources = resources
+	return reply(c, http.StatusOK, list)
+}
+
+func GetUser(c *echo.Context) error {
+	user, err := findUser(c.Param("id"))
+	if err != nil {
+		return failWith(c, err)
+	}
+	return reply(c, http.StatusOK, userResourceOf(c, user))
+}
+
+// Create the user and its Windows account. Without password, the user can
+// only log in through an identity provider or after resetting it.
+func CreateUser(c *echo.Context) error {
+	res, err := decodeUser(c)
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	user := users.User{
+		Email:     res.UserName,
+		FirstName: res.Name.GivenName,
+		LastName:  res.Name.FamilyName,
+	}
+
+	err = user.Validate()
+	if err != nil {
+		return failWith(c, invalidValue(err.Error()))
+	}
+
+	err = emailTaken(user.Email, "")
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	password := res.Password
+	if password == "" {
+		password = utils.RandomString(32)
+	} else {
+		err = checkPassword(&user, password)
+		if err != nil {
+			return failWith(c, err)
+		}
+	}
+
+	created, err := users.CreateUser(res.Active, user.Email, user.FirstName, user.LastName, password, false)
+	if err == users.UserDuplicated {
+		return failWith(c, &scimError{http.StatusConflict, "uniqueness", "userName is already used"})
+	}
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	err = provisioning.CreateAccount(created.Id)
+	if err == nil && !res.Active {
+		err = provisioning.DisableAccount(created.Id)
+	}
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	created, err = findUser(created.Id)
+	if err != nil {
+		return failWith(c, err)
+	}
+	return reply(c, http.StatusCreated, userResourceOf(c, created))
+}
+
+// Administrators are managed from Nanocloud only: a SCIM client can't change
+// the credentials they log in with, nor deactivate them.
+func checkAdminMutability(user *users.User, res *userResource) error {
+	if !user.IsAdmin {
+		return nil
+	}
+
+	if res.UserName != user.Email {
+		return &scimError{http.StatusBadRequest, "mutability", "The userName of administrators can't be changed"}
+	}
+	if res.Password != "" {
+		return &scimError{http.StatusBadRequest, "mutability", "The password of administrators can't be changed"}
+	}
+	if !res.Active {
+		return &scimError{http.StatusBadRequest, "mutability", "Administrators can't be deactivated"}
+	}
+	return nil
+}
+
+// Apply the state of the resource to the user. Deactivating a user
+// deprovisions it: its tokens are revoked, its sessions closed and its
+// Windows account disabled.
+func updateUser(user *users.User, res *userResource) error {
+	updated := users.User{
+		Id:        user.Id,
+		Email:     res.UserName,
+		FirstName: res.Name.GivenName,
+		LastName:  res.Name.FamilyName,
+	}
+
+	err := updated.Validate()
+	if err != nil {
+		return invalidValue(err.Error())
+	}
+
+	if res.Password != "" {
+		err = checkPassword(&updated, res.Password)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = checkAdminMutability(user, res)
+	if err != nil {
+		return err
+	}
+
+	if updated.Email != user.Email {
+		err = emailTaken(updated.Email, user.Id)
+		if err != nil {
+			return err
+		}
+
+		err = users.UpdateUserEmail(user.Id, updated.Email)
+		if err != nil {
+			return err
+		}
+	}
+
+	if updated.FirstName != user.FirstName {
+		err = users.UpdateUserFirstName(user.Id, updated.FirstName)
+		if err != nil {
+			return err
+		}
+	}
+
+	if updated.LastName != user.LastName {
+		err = users.UpdateUserLastName(user.Id, updated.LastName)
+		if err != nil {
+			return err
+		}
+	}
+
+	if res.Password != "" {
+		err = users.UpdateUserPassword(user.Id, res.Password)
+		if err != nil {
+			return err
+		}
+	}
+
+	if res.Active != user.Activated {
+		if res.Active {
+			err = users.ActivateUser(user.Id)
+			if err == nil {
+				err = provisioning.EnableAccount(user.Id)
+			}
+		} else {
+			err = provisioning.Deprovision(user.Id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replyUser(c *echo.Context, id string) error {
+	user, err := findUser(id)
+	if err != nil {
+		return failWith(c, err)
+	}
+	return reply(c, http.StatusOK, userResourceOf(c, user))
+}
+
+// Replace the user, the attributes omitted are cleared
+func ReplaceUser(c *echo.Context) error {
+	user, err := findUser(c.Param("id"))
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	res, err := decodeUser(c)
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	err = updateUser(user, res)
+	if err != nil {
+		return failWith(c, err)
+	}
+	return replyUser(c, user.Id)
+}
+
+// Set an attribute of the user. The attributes Nanocloud doesn't store are
+// ignored.
+func setUserAttribute(res *userResource, path string, value json.RawMessage) error {
+	var err error
+	switch path {
+	case "username":
+		res.UserName, err = stringValue(value)
+	case "name":
+		err = json.Unmarshal(value, &res.Name)
+		if err != nil {
+			err = invalidValue("name must be an object")
+		}
+	case "name.givenname":
+		res.Name.GivenName, err = stringValue(value)
+	case "name.familyname":
+		res.Name.FamilyName, err = stringValue(value)
+	case "active":
+		res.Active, err = boolValue(value)
+	case "password":
+		res.Password, err = stringValue(value)
+	}
+	return err
+}
+
+func applyUserOperation(res *userResource, operation patchOperation) error {
+	op, err := operation.kind()
+	if err != nil {
+		return err
+	}
+	path := attributePath(operation.Path)
+
+	if op == "remove" {
+		switch path {
+		case "":
+			return noTarget("A remove operation needs a path")
+		case "username", "name", "name.givenname", "name.familyname", "active":
+			return &scimError{http.StatusBadRequest, "mutability", operation.Path + " is required"}
+		}
+		return nil
+	}
+
+	if path != "" {
+		return setUserAttribute(res, path, operation.Value)
+	}
+
+	values, err := operation.values()
+	if err != nil {
+		return err
+	}
+	for path, value := range values {
+		err = setUserAttribute(res, path, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func PatchUser(c *echo.Context) error {
+	user, err := findUser(c.Param("id"))
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	req := patchRequest{}
+	err = decode(c, &req)
+	if err != nil {
+		return fail(c, http.StatusBadRequest, "invalidSyntax", "The patch request is not valid JSON")
+	}
+
+	res := userResourceOf(c, user)
+	for _, operation := range req.Operations {
+		err = applyUserOperation(res, operation)
+		if err != nil {
+			return failWith(c, err)
+		}
+	}
+
+	err = updateUser(user, res)
+	if err != nil {
+		return failWith(c, err)
+	}
+	return replyUser(c, user.Id)
+}
+
+// Delete the user after closing its sessions, its Windows account is then
+// deleted
+func DeleteUser(c *echo.Context) error {
+	user, err := findUser(c.Param("id"))
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	if user.IsAdmin {
+		return fail(c, http.StatusBadRequest, "mutability", "Administrators can't be deleted")
+	}
+
+	err = provisioning.DeleteUser(user.Id)
+	if err != nil {
+		return failWith(c, err)
+	}
+
+	c.Response().WriteHeader(http.StatusNoContent)
+	return nil
+}
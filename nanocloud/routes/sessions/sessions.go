@@ -1,19 +1,18 @@
 package sessions
 
 import (
-	"io/ioutil"
 	"net/http"
 
+	"github.com/Nanocloud/community/nanocloud/audit"
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
 	"github.com/Nanocloud/community/nanocloud/models/sessions"
 	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/provisioning"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
 )
 
-var kServer string
-var kPort string
-
 type hash map[string]interface{}
 
 func List(c *echo.Context) error {
@@ -26,8 +25,10 @@ func List(c *echo.Context) error {
 	}
 
 	sessionList, err := sessions.GetAll(winUser.Sam)
-
-	if err != nil {
+	if _, ok := err.(sessions.ServerErrors); ok {
+		// List what the reachable servers returned
+		log.Warn(err)
+	} else if err != nil {
 		log.Error(err)
 		return utils.JSON(c, http.StatusInternalServerError, hash{
 			"error": [1]hash{
@@ -59,7 +60,7 @@ func Logoff(c *echo.Context) error {
 		return err
 	}
 
-	req, err := http.NewRequest("DELETE", "http://"+kServer+":"+kPort+"/sessions/"+winUser.Sam, nil)
+	err = sessions.Logoff(winUser.Sam)
 	if err != nil {
 		log.Error(err)
 		return c.JSON(http.StatusInternalServerError, hash{
@@ -70,34 +71,25 @@ func Logoff(c *echo.Context) error {
 			},
 		})
 	}
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil || resp.Status != "200 OK" {
-		log.Error(err)
-		return c.JSON(http.StatusInternalServerError, hash{
-			"error": [1]hash{
-				hash{
-					"detail": resp.Status,
-				},
-			},
-		})
-	}
 
-	b, err := ioutil.ReadAll(resp.Body)
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
+
+// Close the sessions of another user
+func LogoffUser(c *echo.Context) error {
+	err := provisioning.LogoffSessions(c.Param("id"))
+	if err == users.UserNotFound {
+		return apiErrors.UserNotFound
+	}
 	if err != nil {
 		log.Error(err)
-		return c.JSON(http.StatusInternalServerError, hash{
-			"error": [1]hash{
-				hash{
-					"detail": err.Error(),
-				},
-			},
-		})
+		return apiErrors.InternalError.Detail("Unable to close the sessions")
 	}
-	return c.JSON(http.StatusOK, string(b))
-}
+	audit.Record(c, "session.logoff", "user", c.Param("id"), nil, nil)
 
-func init() {
-	kServer = utils.Env("EXECUTION_SERVERS", "iaas-module")
-	kPort = utils.Env("PLAZA_PORT", "9090")
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
 }
@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/Nanocloud/community/nanocloud/audit"
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/labstack/echo"
@@ -103,5 +104,15 @@ func Delete(c *echo.Context) error {
 		})
 	}
 
+	_, err = db.Exec(
+		`DELETE FROM oauth_refresh_tokens
+		WHERE access_token_id = $1::varchar`,
+		tokenId,
+	)
+	if err != nil {
+		return err
+	}
+	audit.Record(c, "token.revoke", "token", tokenId, nil, nil)
+
 	return c.JSON(http.StatusOK, hash{})
 }
@@ -26,6 +26,7 @@ import (
 	"net/http"
 	"net/url"
 
+	"github.com/Nanocloud/community/nanocloud/models/sessions"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/oauth2"
 	"github.com/Nanocloud/community/nanocloud/utils"
@@ -41,7 +42,11 @@ func Post(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user := rawuser.(*users.User)
+	user, ok := rawuser.(*users.User)
+	if !ok {
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
 
 	winUser, err := user.WindowsCredentials()
 	if err != nil {
@@ -50,7 +55,12 @@ func Post(w http.ResponseWriter, r *http.Request) {
 	}
 
 	sam := winUser.Sam
-	winServer := utils.Env("PLAZA_ADDRESS", "iaas-module")
+	winServer, err := sessions.FindServer(sam)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "", http.StatusServiceUnavailable)
+		return
+	}
 
 	request, err := http.NewRequest(
 		"POST",
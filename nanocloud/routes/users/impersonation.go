@@ -0,0 +1,94 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package users
+
+import (
+	"net/http"
+
+	"github.com/Nanocloud/community/nanocloud/audit"
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/impersonations"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+// Deliver to the administrator a short lived token to act as the user, to
+// reproduce an issue without asking for their password
+func Impersonate(c *echo.Context) error {
+	admin := c.Get("user").(*users.User)
+
+	user, err := users.GetUser(c.Param("id"))
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	if user == nil {
+		return apiErrors.UserNotFound
+	}
+
+	if user.Id == admin.Id {
+		return apiErrors.InvalidRequest.Detail("You cannot impersonate yourself")
+	}
+	if user.IsAdmin {
+		return apiErrors.Unauthorized.Detail("Administrators cannot be impersonated")
+	}
+	if !user.Activated {
+		return apiErrors.InvalidRequest.Detail("This account is disabled")
+	}
+
+	r := c.Request()
+	token, err := oauth.Impersonate(admin, user, r)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to create the impersonation token")
+	}
+
+	err = impersonations.Record(&impersonations.Entry{
+		ImpersonatorId: admin.Id,
+		UserId:         user.Id,
+		Method:         r.Method,
+		Path:           r.URL.Path,
+		Status:         http.StatusCreated,
+		Ip:             oauth.ClientIP(r),
+	})
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to record the impersonation")
+	}
+	audit.Record(c, "user.impersonate", "user", user.Id, nil, nil)
+
+	return c.JSON(http.StatusCreated, token)
+}
+
+// List the impersonation log, filtered with the user and impersonator
+// query parameters
+func ListImpersonations(c *echo.Context) error {
+	entries, err := impersonations.FindAll(c.Query("user"), c.Query("impersonator"))
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the impersonation log")
+	}
+	return utils.JSON(c, http.StatusOK, entries)
+}
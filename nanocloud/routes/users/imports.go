@@ -0,0 +1,105 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package users
+
+import (
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/Nanocloud/community/nanocloud/audit"
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/imports"
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+// Maximum size of the files imported
+const maxImportSize = 10 << 20
+
+// Import users in the background from the CSV (text/csv) or JSON Lines
+// (application/x-ndjson) body, or from the directory accounts matching the
+// ldap-filter parameter. With dry-run=true the rows are only checked.
+func Import(c *echo.Context) error {
+	var source string
+	var rows []imports.Row
+	var err error
+
+	filter := c.Query("ldap-filter")
+	if filter != "" {
+		source = imports.LDAPSearch
+		rows, err = imports.SearchLDAP(filter)
+		if err == ldap.SearchFailed {
+			return apiErrors.InvalidRequest.Detail("Unable to search the directory with this filter")
+		}
+	} else {
+		mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get("Content-Type"))
+		body := io.LimitReader(c.Request().Body, maxImportSize)
+
+		switch mediaType {
+		case "text/csv":
+			source = imports.CSV
+			rows, err = imports.ParseCSV(body)
+		case "application/x-ndjson", "application/jsonl":
+			source = imports.JSONLines
+			rows, err = imports.ParseJSONLines(body)
+		default:
+			return apiErrors.InvalidRequest.Detail("Send a text/csv or application/x-ndjson body, or an ldap-filter")
+		}
+	}
+	if err != nil {
+		return apiErrors.InvalidRequest.Detail(err.Error())
+	}
+
+	imp, err := imports.Start(source, rows, c.Query("dry-run") == "true")
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to start the import")
+	}
+	audit.Record(c, "user.import", "import", imp.Id, nil, imp)
+	return utils.JSON(c, http.StatusAccepted, imp)
+}
+
+func ListImports(c *echo.Context) error {
+	all, err := imports.FindAll()
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	return utils.JSON(c, http.StatusOK, all)
+}
+
+// Return the progress of an import and the rows rejected so far
+func GetImport(c *echo.Context) error {
+	imp, err := imports.Get(c.Param("id"))
+	if err == imports.ImportNotFound {
+		return apiErrors.ImportNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	return utils.JSON(c, http.StatusOK, imp)
+}
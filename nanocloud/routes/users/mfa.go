@@ -0,0 +1,230 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package users
+
+import (
+	"net/http"
+
+	"github.com/Nanocloud/community/nanocloud/audit"
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/mfa"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+// Second factor code sent to confirm an operation
+type mfaCode struct {
+	Id   string `json:"-"`
+	Code string `json:"code"`
+}
+
+func (m *mfaCode) GetID() string {
+	return m.Id
+}
+
+func (m *mfaCode) SetID(id string) error {
+	m.Id = id
+	return nil
+}
+
+// Return the user the MFA route is about. Users can only manage their own
+// second factor, the holders of users:write can see and disable the one of
+// the others.
+func mfaUser(c *echo.Context, adminAllowed bool) (*users.User, error) {
+	user := c.Get("user").(*users.User)
+
+	userId := c.Param("id")
+	if userId == user.Id {
+		return user, nil
+	}
+
+	if !adminAllowed {
+		return nil, apiErrors.Unauthorized.Detail("You can only manage the two-factor authentication of your account")
+	}
+
+	allowed, err := can(c, "users:write")
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, apiErrors.Unauthorized.Detail("You can only manage the two-factor authentication of your account")
+	}
+
+	target, err := users.GetUser(userId)
+	if err != nil {
+		log.Error(err)
+		return nil, apiErrors.InternalError
+	}
+	if target == nil {
+		return nil, apiErrors.UserNotFound
+	}
+
+	allowed, err = canManage(c, target)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, notManageable
+	}
+	return target, nil
+}
+
+func GetMFA(c *echo.Context) error {
+	user, err := mfaUser(c, true)
+	if err != nil {
+		return err
+	}
+
+	status, err := mfa.GetStatus(user.Id)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the two-factor authentication status")
+	}
+	return utils.JSON(c, http.StatusOK, status)
+}
+
+func EnrollMFA(c *echo.Context) error {
+	user, err := mfaUser(c, false)
+	if err != nil {
+		return err
+	}
+
+	enrollment, err := mfa.Enroll(user)
+	if err == mfa.AlreadyEnabled {
+		return apiErrors.Conflict.Detail("Two-factor authentication is already enabled")
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to enroll the second factor")
+	}
+	return utils.JSON(c, http.StatusCreated, enrollment)
+}
+
+func ActivateMFA(c *echo.Context) error {
+	user, err := mfaUser(c, false)
+	if err != nil {
+		return err
+	}
+
+	code := mfaCode{}
+	err = utils.ParseJSONBody(c, &code)
+	if err != nil {
+		return err
+	}
+
+	recoveryCodes, err := mfa.Activate(user.Id, code.Code)
+	switch err {
+	case nil:
+		return utils.JSON(c, http.StatusOK, recoveryCodes)
+	case mfa.NotEnrolled:
+		return apiErrors.InvalidRequest.Detail("No pending enrollment")
+	case mfa.InvalidCode:
+		return apiErrors.InvalidMFACode
+	}
+
+	log.Error(err)
+	return apiErrors.InternalError.Detail("Unable to enable the two-factor authentication")
+}
+
+func RegenerateRecoveryCodes(c *echo.Context) error {
+	user, err := mfaUser(c, false)
+	if err != nil {
+		return err
+	}
+
+	code := mfaCode{}
+	err = utils.ParseJSONBody(c, &code)
+	if err != nil {
+		return err
+	}
+
+	err = mfa.Verify(user.Id, code.Code)
+	switch err {
+	case nil:
+	case mfa.NotEnrolled:
+		return apiErrors.InvalidRequest.Detail("Two-factor authentication is not enabled")
+	case mfa.InvalidCode:
+		return apiErrors.InvalidMFACode
+	default:
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	recoveryCodes, err := mfa.GenerateRecoveryCodes(user.Id)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to generate the recovery codes")
+	}
+	return utils.JSON(c, http.StatusOK, recoveryCodes)
+}
+
+// Users must confirm with a code, administrators can disable the second
+// factor of users who lost it.
+func DisableMFA(c *echo.Context) error {
+	user, err := mfaUser(c, true)
+	if err != nil {
+		return err
+	}
+
+	if user.Id == c.Get("user").(*users.User).Id {
+		err = mfa.Verify(user.Id, c.Query("code"))
+		switch err {
+		case nil, mfa.NotEnrolled:
+		case mfa.InvalidCode:
+			return apiErrors.InvalidMFACode
+		default:
+			log.Error(err)
+			return apiErrors.InternalError
+		}
+	}
+
+	err = mfa.Disable(user.Id)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to disable the two-factor authentication")
+	}
+	audit.Record(c, "user.mfa-disable", "user", user.Id, nil, nil)
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
+
+func GetMFAPolicy(c *echo.Context) error {
+	return utils.JSON(c, http.StatusOK, mfa.GetPolicy())
+}
+
+func UpdateMFAPolicy(c *echo.Context) error {
+	policy := mfa.Policy{}
+	err := utils.ParseJSONBody(c, &policy)
+	if err != nil {
+		return err
+	}
+
+	previous := mfa.GetPolicy()
+	mfa.SetPolicy(&policy)
+	audit.Record(c, "policy.update", "policy", "mfa", previous, &policy)
+	return utils.JSON(c, http.StatusOK, &policy)
+}
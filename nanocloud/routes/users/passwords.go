@@ -0,0 +1,87 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package users
+
+import (
+	"net/http"
+
+	"github.com/Nanocloud/community/nanocloud/audit"
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/passwords"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+func violationError(violation passwords.Violation) error {
+	switch violation.Rule {
+	case passwords.TooShort:
+		return apiErrors.PasswordTooShort.Detail(violation.Detail)
+	case passwords.TooSimple:
+		return apiErrors.PasswordTooSimple.Detail(violation.Detail)
+	case passwords.Banned:
+		return apiErrors.PasswordBanned.Detail(violation.Detail)
+	case passwords.Reused:
+		return apiErrors.PasswordReused.Detail(violation.Detail)
+	}
+	return apiErrors.InvalidRequest.Detail(violation.Detail)
+}
+
+// Check the new password of the user against the password policy, the
+// returned error lists every rule it breaks. The user has no id yet when it
+// is being created.
+func checkPassword(user *users.User, password string) error {
+	violations, err := passwords.Check(user.Id, password, passwords.PersonalWords(user)...)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to check the password")
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	errs := make([]error, len(violations))
+	for i, violation := range violations {
+		errs[i] = violationError(violation)
+	}
+	return apiErrors.List(errs...)
+}
+
+func GetPasswordPolicy(c *echo.Context) error {
+	return utils.JSON(c, http.StatusOK, passwords.GetPolicy())
+}
+
+func UpdatePasswordPolicy(c *echo.Context) error {
+	policy := passwords.Policy{}
+	err := utils.ParseJSONBody(c, &policy)
+	if err != nil {
+		return err
+	}
+
+	previous := passwords.GetPolicy()
+	passwords.SetPolicy(&policy)
+	audit.Record(c, "policy.update", "policy", "passwords", previous, &policy)
+	return utils.JSON(c, http.StatusOK, &policy)
+}
@@ -0,0 +1,258 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package users
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Nanocloud/community/nanocloud/audit"
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/mailer"
+	"github.com/Nanocloud/community/nanocloud/models/registrations"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/provisioning"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+const verificationMailBody = `Hello %s,
+
+Welcome to Nanocloud! Confirm your email address with this link:
+
+%s%s
+
+The link expires in %d hours.
+`
+
+const activationMailBody = `Hello %s,
+
+Your Nanocloud account has been approved, you can now log in.
+`
+
+// Activate the account of a registered user and create its Windows account
+func activateRegistration(registration *registrations.Registration) error {
+	err := users.ActivateUser(registration.Id)
+	if err != nil {
+		return err
+	}
+
+	err = provisioning.CreateAccount(registration.Id)
+	if err != nil {
+		return err
+	}
+
+	return registrations.Delete(registration.Id)
+}
+
+// Sign up: the user is created deactivated until their email is verified and,
+// if the policy requires it, an administrator approves the registration.
+func Register(c *echo.Context) error {
+	if !registrations.GetPolicy().Enabled {
+		return apiErrors.SignupDisabled
+	}
+
+	u := users.User{}
+	err := utils.ParseJSONBody(c, &u)
+	if err != nil {
+		return err
+	}
+
+	if u.Email == "" {
+		return apiErrors.InvalidRequest.Detail("email is missing")
+	}
+	if u.FirstName == "" {
+		return apiErrors.InvalidRequest.Detail("first-name is missing")
+	}
+	if u.LastName == "" {
+		return apiErrors.InvalidRequest.Detail("last-name is missing")
+	}
+	if u.Password == "" {
+		return apiErrors.InvalidRequest.Detail("password is missing")
+	}
+
+	err = checkPassword(&u, u.Password)
+	if err != nil {
+		return err
+	}
+
+	err = registrations.DeleteExpired()
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	newUser, err := users.CreateUser(
+		false,
+		u.Email,
+		u.FirstName,
+		u.LastName,
+		u.Password,
+		false,
+	)
+	if err == users.UserDuplicated {
+		return apiErrors.Conflict.Detail("This email is already used")
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to create the user")
+	}
+
+	token, err := registrations.Create(newUser.Id)
+	if err != nil {
+		log.Error(err)
+		users.DeleteUser(newUser.Id)
+		return apiErrors.InternalError.Detail("Unable to create the registration")
+	}
+
+	err = mailer.Send(&mailer.Message{
+		To:      newUser.Email,
+		Subject: "Confirm your Nanocloud account",
+		Body: fmt.Sprintf(
+			verificationMailBody,
+			newUser.FirstName,
+			utils.Env("SIGNUP_VERIFY_URL", "http://localhost/#/verify-email/"),
+			token,
+			int(registrations.VerificationTTL.Hours()),
+		),
+	})
+	if err != nil {
+		log.Error(err)
+		users.DeleteUser(newUser.Id)
+		return apiErrors.InternalError.Detail("Unable to send the verification mail")
+	}
+
+	registration, err := registrations.Get(newUser.Id)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	return utils.JSON(c, http.StatusCreated, registration)
+}
+
+// Verify the email of a registration with the token sent by mail. The account
+// is activated right away unless the registrations must be approved.
+func VerifyEmail(c *echo.Context) error {
+	registration, err := registrations.Verify(c.Param("id"))
+	if err == registrations.RegistrationNotFound {
+		return apiErrors.EmailVerificationNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	if !registrations.GetPolicy().ApprovalRequired {
+		err = activateRegistration(registration)
+		if err != nil {
+			log.Error(err)
+			return apiErrors.InternalError.Detail("Unable to activate the account")
+		}
+	}
+
+	return utils.JSON(c, http.StatusOK, registration)
+}
+
+// List the registrations waiting for an approval
+func ListRegistrations(c *echo.Context) error {
+	pending, err := registrations.FindPending()
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	return utils.JSON(c, http.StatusOK, pending)
+}
+
+func ApproveRegistration(c *echo.Context) error {
+	registration, err := registrations.Get(c.Param("id"))
+	if err == registrations.RegistrationNotFound {
+		return apiErrors.RegistrationNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	if !registration.EmailVerified {
+		return apiErrors.InvalidRequest.Detail("The email of this registration isn't verified")
+	}
+
+	err = activateRegistration(registration)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to activate the account")
+	}
+	audit.Record(c, "registration.approve", "user", registration.Id, nil, registration)
+
+	err = mailer.Send(&mailer.Message{
+		To:      registration.Email,
+		Subject: "Your Nanocloud account is active",
+		Body:    fmt.Sprintf(activationMailBody, registration.FirstName),
+	})
+	if err != nil {
+		log.Warnf("Unable to notify %s of their activation: %s", registration.Email, err.Error())
+	}
+
+	return utils.JSON(c, http.StatusOK, registration)
+}
+
+// Reject a registration, the deactivated user is deleted
+func RejectRegistration(c *echo.Context) error {
+	registration, err := registrations.Get(c.Param("id"))
+	if err == registrations.RegistrationNotFound {
+		return apiErrors.RegistrationNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	err = users.DeleteUser(registration.Id)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to delete the registration")
+	}
+	audit.Record(c, "registration.reject", "user", registration.Id, registration, nil)
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
+
+func GetRegistrationPolicy(c *echo.Context) error {
+	return utils.JSON(c, http.StatusOK, registrations.GetPolicy())
+}
+
+func UpdateRegistrationPolicy(c *echo.Context) error {
+	policy := registrations.Policy{}
+	err := utils.ParseJSONBody(c, &policy)
+	if err != nil {
+		return err
+	}
+
+	previous := registrations.GetPolicy()
+	registrations.SetPolicy(&policy)
+	audit.Record(c, "policy.update", "policy", "registrations", previous, &policy)
+	return utils.JSON(c, http.StatusOK, &policy)
+}
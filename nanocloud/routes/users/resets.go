@@ -0,0 +1,180 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package users
+
+import (
+	"fmt"
+	"net/http"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/mailer"
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/passwords"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+const resetMailBody = `Hello %s,
+
+A password reset has been requested for your Nanocloud account. Follow this link to choose a new password:
+
+%s%s
+
+The link expires in %d minutes. If you didn't request it, you can ignore this mail.
+`
+
+type passwordReset struct {
+	Id       string `json:"-"`
+	Email    string `json:"email,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+func (p *passwordReset) GetID() string {
+	return p.Id
+}
+
+func (p *passwordReset) SetID(id string) error {
+	p.Id = id
+	return nil
+}
+
+// Send a password reset link to the user. The response is the same whether
+// the account exists or not.
+func CreatePasswordReset(c *echo.Context) error {
+	reset := passwordReset{}
+	err := utils.ParseJSONBody(c, &reset)
+	if err != nil {
+		return err
+	}
+
+	if reset.Email == "" {
+		return apiErrors.InvalidRequest.Detail("email is missing")
+	}
+
+	user, err := users.GetUserFromEmail(reset.Email)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	if user != nil && user.Activated {
+		token, err := passwords.CreateReset(user.Id)
+		if err != nil {
+			log.Error(err)
+			return apiErrors.InternalError.Detail("Unable to create the password reset")
+		}
+
+		err = mailer.Send(&mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your Nanocloud password",
+			Body: fmt.Sprintf(
+				resetMailBody,
+				user.FirstName,
+				utils.Env("PASSWORD_RESET_URL", "http://localhost/#/reset-password/"),
+				token,
+				int(passwords.ResetTokenTTL.Minutes()),
+			),
+		})
+		if err != nil {
+			log.Error(err)
+			return apiErrors.InternalError.Detail("Unable to send the password reset mail")
+		}
+	}
+
+	return c.JSON(http.StatusAccepted, hash{
+		"meta": hash{},
+	})
+}
+
+// Set the password of the user the reset token has been issued for
+func ResetPassword(c *echo.Context) error {
+	token := c.Param("id")
+
+	reset := passwordReset{}
+	err := utils.ParseJSONBody(c, &reset)
+	if err != nil {
+		return err
+	}
+
+	if reset.Password == "" {
+		return apiErrors.InvalidRequest.Detail("password is missing")
+	}
+
+	userId, err := passwords.GetResetUser(token)
+	if err == passwords.ResetNotFound {
+		return apiErrors.PasswordResetNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	user, err := users.GetUser(userId)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	if user == nil {
+		return apiErrors.PasswordResetNotFound
+	}
+
+	// the token is only consumed by a valid password
+	err = checkPassword(user, reset.Password)
+	if err != nil {
+		return err
+	}
+
+	_, err = passwords.ConsumeReset(token)
+	if err == passwords.ResetNotFound {
+		return apiErrors.PasswordResetNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	err = users.UpdateUserPassword(user.Id, reset.Password)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to update the password")
+	}
+
+	// The Windows account of the user follows the new password. Accounts not
+	// created by Nanocloud keep the password they have in Active Directory.
+	err = ldap.ChangePassword(user.Id, reset.Password)
+	if err != nil {
+		log.Warnf("Unable to change the Windows password of %s: %s", user.Email, err.Error())
+	} else {
+		err = users.UpdateWindowsPassword(user.Id, reset.Password)
+		if err != nil {
+			log.Error(err)
+			return apiErrors.InternalError.Detail("Unable to update the Windows password")
+		}
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
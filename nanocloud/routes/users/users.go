@@ -23,19 +23,58 @@
 package users
 
 import (
+	"encoding/json"
 	"errors"
+	"io/ioutil"
 	"net/http"
 
+	"github.com/Nanocloud/community/nanocloud/audit"
 	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
-	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
 	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/provisioning"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
+	"github.com/manyminds/api2go/jsonapi"
 )
 
 type hash map[string]interface{}
 
+// Whether the caller holds the permission. Service accounts have no user,
+// their scopes have already been checked by the middleware.
+func can(c *echo.Context, permission string) (bool, error) {
+	user, ok := c.Get("user").(*users.User)
+	if !ok {
+		return true, nil
+	}
+
+	allowed, err := roles.Can(user, permission)
+	if err != nil {
+		log.Error(err)
+		return false, apiErrors.InternalError
+	}
+	return allowed, nil
+}
+
+var notManageable = apiErrors.Forbidden.Detail("Administrators are only managed by administrators, and the users by holders of all their permissions")
+
+// Whether the caller can act on the account of target, see roles.CanManage.
+// Service accounts can't manage administrators.
+func canManage(c *echo.Context, target *users.User) (bool, error) {
+	user, ok := c.Get("user").(*users.User)
+	if !ok {
+		return !target.IsAdmin, nil
+	}
+
+	allowed, err := roles.CanManage(user, target)
+	if err != nil {
+		log.Error(err)
+		return false, apiErrors.InternalError
+	}
+	return allowed, nil
+}
+
 func Delete(c *echo.Context) error {
 	userId := c.Param("id")
 	if len(userId) == 0 {
@@ -73,12 +112,27 @@ func Delete(c *echo.Context) error {
 		})
 	}
 
+	allowed, err := canManage(c, user)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return notManageable
+	}
+
 	err = users.DeleteUser(user.Id)
 	if err != nil {
 		log.Errorf("Unable to delete user: ", err.Error())
 		return err
 	}
 
+	audit.Record(c, "user.delete", "user", user.Id, user, nil)
+
+	err = provisioning.DeleteAccount(user.Id)
+	if err != nil {
+		log.Errorf("Unable to delete the Windows account of %s: %s", user.Id, err.Error())
+	}
+
 	return c.JSON(http.StatusOK, hash{
 		"meta": hash{},
 	})
@@ -103,37 +157,96 @@ func Disable(userId string) (int, error) {
 		return http.StatusInternalServerError, errors.New("Unable to disable user: " + err.Error())
 	}
 
+	err = provisioning.DisableAccount(userId)
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("Unable to disable the Windows account: " + err.Error())
+	}
+
 	return 0, nil
 }
 
+// Boolean attributes of an update, nil when they aren't sent. Their zero value
+// can't tell an omitted attribute from a false one.
+type updateFlags struct {
+	Activated *bool `json:"activated"`
+	IsAdmin   *bool `json:"is-admin"`
+}
+
+func parseUpdate(c *echo.Context, user *users.User, flags *updateFlags) error {
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return apiErrors.InvalidRequest
+	}
+
+	err = jsonapi.Unmarshal(body, user)
+	if err != nil {
+		return apiErrors.InvalidRequest
+	}
+
+	document := struct {
+		Data struct {
+			Attributes *updateFlags `json:"attributes"`
+		} `json:"data"`
+	}{}
+	document.Data.Attributes = flags
+	err = json.Unmarshal(body, &document)
+	if err != nil {
+		return apiErrors.InvalidRequest
+	}
+	return nil
+}
+
 func Update(c *echo.Context) error {
 	updatedUser := users.User{}
+	flags := updateFlags{}
 	user := c.Get("user").(*users.User)
 
-	err := utils.ParseJSONBody(c, &updatedUser)
+	err := parseUpdate(c, &updatedUser, &flags)
 	if err != nil {
-		return apiErrors.InvalidRequest
+		return err
 	}
 
 	currentUser, err := users.GetUser(updatedUser.GetID())
-	if err != nil {
+	if err != nil || currentUser == nil {
 		return apiErrors.UserNotFound
 	}
 
-	if !user.IsAdmin && (updatedUser.GetID() != user.GetID()) {
+	manager, err := can(c, "users:write")
+	if err != nil {
+		return err
+	}
+
+	if !manager && (updatedUser.GetID() != user.GetID()) {
 		return apiErrors.Unauthorized.Detail("You can only update your account")
 	}
 
-	if updatedUser.IsAdmin != currentUser.IsAdmin {
-		if currentUser.Id == user.GetID() {
+	allowed, err := canManage(c, currentUser)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return notManageable
+	}
+
+	action := "user.update"
+	if flags.IsAdmin != nil && *flags.IsAdmin != currentUser.IsAdmin {
+		action = "user.privilege"
+		// roles don't allow to become administrator
+		if !user.IsAdmin || currentUser.Id == user.GetID() {
 			return apiErrors.Unauthorized.Detail("You cannot grant administration rights")
 		}
-		err = users.UpdateUserPrivilege(updatedUser.GetID(), updatedUser.IsAdmin)
+		err = users.UpdateUserPrivilege(updatedUser.GetID(), *flags.IsAdmin)
 		if err != nil {
 			log.Error(err)
 			return apiErrors.InternalError.Detail("Unable to update the rank")
 		}
 	} else if updatedUser.Password != "" {
+		action = "user.password"
+		err = checkPassword(currentUser, updatedUser.Password)
+		if err != nil {
+			return err
+		}
+
 		err = users.UpdateUserPassword(updatedUser.GetID(), updatedUser.Password)
 		if err != nil {
 			log.Error(err)
@@ -157,20 +270,52 @@ func Update(c *echo.Context) error {
 			log.Error(err)
 			return apiErrors.InternalError.Detail("Unable to update the last name")
 		}
+	} else if flags.Activated != nil && *flags.Activated != currentUser.Activated {
+		if !manager || currentUser.Id == user.GetID() {
+			return apiErrors.Unauthorized.Detail("You cannot change the activation of this account")
+		}
+		if *flags.Activated {
+			action = "user.activate"
+			err = users.ActivateUser(updatedUser.GetID())
+			if err == nil {
+				err = provisioning.EnableAccount(updatedUser.GetID())
+			}
+		} else {
+			action = "user.disable"
+			_, err = Disable(updatedUser.GetID())
+		}
+		if err != nil {
+			log.Error(err)
+			return apiErrors.InternalError.Detail("Unable to update the activation")
+		}
 	} else {
 		return apiErrors.InvalidRequest.Detail("No field sent")
 	}
 
+	updated, err := users.GetUser(currentUser.Id)
+	if err != nil {
+		log.Error(err)
+	}
+	audit.Record(c, action, "user", currentUser.Id, currentUser, updated)
+
+	// the attributes that weren't sent are zero in updatedUser
+	if updated != nil {
+		return utils.JSON(c, http.StatusOK, updated)
+	}
 	return utils.JSON(c, http.StatusOK, &updatedUser)
 }
 
 func Get(c *echo.Context) error {
-	user := c.Get("user").(*users.User)
-	if c.Query("me") == "true" {
+	user, ok := c.Get("user").(*users.User)
+	if ok && c.Query("me") == "true" {
 		return utils.JSON(c, http.StatusOK, user)
 	}
 
-	if !user.IsAdmin {
+	allowed, err := can(c, "users:read")
+	if err != nil {
+		return err
+	}
+	if !allowed {
 		return apiErrors.AdminLevelRequired
 	}
 
@@ -191,31 +336,12 @@ func Post(c *echo.Context) error {
 		return err
 	}
 
-	if u.Email == "" {
-		return c.JSON(http.StatusBadRequest, hash{
-			"error": [1]hash{
-				hash{
-					"detail": "email is missing",
-				},
-			},
-		})
-	}
-
-	if u.FirstName == "" {
-		return c.JSON(http.StatusBadRequest, hash{
-			"error": [1]hash{
-				hash{
-					"detail": "first-name is missing",
-				},
-			},
-		})
-	}
-
-	if u.LastName == "" {
+	err = u.Validate()
+	if err != nil {
 		return c.JSON(http.StatusBadRequest, hash{
 			"error": [1]hash{
 				hash{
-					"detail": "last-name is missing",
+					"detail": err.Error(),
 				},
 			},
 		})
@@ -231,6 +357,11 @@ func Post(c *echo.Context) error {
 		})
 	}
 
+	err = checkPassword(&u, u.Password)
+	if err != nil {
+		return err
+	}
+
 	newUser, err := users.CreateUser(
 		true,
 		u.Email,
@@ -252,16 +383,12 @@ func Post(c *echo.Context) error {
 		return err
 	}
 
-	winpass := utils.RandomString(8) + "s4D+"
-	sam, err := ldap.AddUser(newUser.Id, winpass)
+	err = provisioning.CreateAccount(newUser.Id)
 	if err != nil {
-		return err
-	}
-
-	err = users.UpdateUserAd(newUser.Id, sam, winpass, "intra.localdomain.com")
-	if err != nil {
-		return err
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to create the Windows account")
 	}
+	audit.Record(c, "user.create", "user", newUser.Id, nil, newUser)
 
 	return utils.JSON(c, http.StatusCreated, newUser)
 }
@@ -289,13 +416,13 @@ func UpdatePassword(c *echo.Context) error {
 		return nil
 	}
 
-	exists, err := users.UserExists(userId)
+	target, err := users.GetUser(userId)
 	if err != nil {
 		log.Errorf("Unable to check user existance: %s", err.Error())
 		return err
 	}
 
-	if !exists {
+	if target == nil {
 		return c.JSON(http.StatusNotFound, hash{
 			"error": [1]hash{
 				hash{
@@ -305,11 +432,25 @@ func UpdatePassword(c *echo.Context) error {
 		})
 	}
 
+	allowed, err := canManage(c, target)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return notManageable
+	}
+
+	err = checkPassword(target, user.Data.Password)
+	if err != nil {
+		return err
+	}
+
 	err = users.UpdateUserPassword(userId, user.Data.Password)
 	if err != nil {
 		log.Errorf("Unable to update user password: %s", err.Error())
 		return err
 	}
+	audit.Record(c, "user.password", "user", userId, nil, nil)
 
 	return c.JSON(http.StatusOK, hash{
 		"data": hash{
@@ -330,6 +471,17 @@ func GetUser(c *echo.Context) error {
 		})
 	}
 
+	caller, ok := c.Get("user").(*users.User)
+	if !ok || caller.Id != userId {
+		allowed, err := can(c, "users:read")
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return apiErrors.AdminLevelRequired
+		}
+	}
+
 	user, err := users.GetUser(userId)
 	if err != nil {
 		return err
@@ -0,0 +1,206 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package saml
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+const (
+	dsigNamespace = "http://www.w3.org/2000/09/xmldsig#"
+
+	excC14N            = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	envelopedSignature = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+
+	digestSHA1   = "http://www.w3.org/2000/09/xmldsig#sha1"
+	digestSHA256 = "http://www.w3.org/2001/04/xmlenc#sha256"
+
+	signatureRSASHA1   = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
+	signatureRSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+)
+
+var (
+	SignatureMissing = errors.New("saml: signature missing")
+	SignatureInvalid = errors.New("saml: invalid signature")
+)
+
+func digestAlgorithm(uri string) (crypto.Hash, error) {
+	switch uri {
+	case digestSHA1:
+		return crypto.SHA1, nil
+	case digestSHA256:
+		return crypto.SHA256, nil
+	}
+	return 0, errors.New("saml: unsupported digest algorithm " + uri)
+}
+
+func signatureAlgorithm(uri string) (crypto.Hash, error) {
+	switch uri {
+	case signatureRSASHA1:
+		return crypto.SHA1, nil
+	case signatureRSASHA256:
+		return crypto.SHA256, nil
+	}
+	return 0, errors.New("saml: unsupported signature algorithm " + uri)
+}
+
+func hash(h crypto.Hash, data []byte) []byte {
+	if h == crypto.SHA1 {
+		sum := sha1.Sum(data)
+		return sum[:]
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func decodeBase64(value string) ([]byte, error) {
+	// base64 values are often wrapped over several lines
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+}
+
+// Return the PrefixList of the InclusiveNamespaces child of a transform or
+// canonicalization method.
+func inclusivePrefixes(method *element) []string {
+	inclusive := method.element(excC14N, "InclusiveNamespaces")
+	if inclusive == nil {
+		return nil
+	}
+	return strings.Fields(inclusive.attr("PrefixList"))
+}
+
+func hasSignature(el *element) bool {
+	return len(el.elements(dsigNamespace, "Signature")) > 0
+}
+
+/*
+ * Check the enveloped signature of el against the certificate of the
+ * identity provider. The signature must be a direct child of el and its
+ * single reference must point to el itself, so that the content read from el
+ * afterwards is the signed content.
+ */
+func verifySignature(el *element, cert *x509.Certificate) error {
+	signatures := el.elements(dsigNamespace, "Signature")
+	if len(signatures) == 0 {
+		return SignatureMissing
+	}
+	if len(signatures) > 1 {
+		return SignatureInvalid
+	}
+	signature := signatures[0]
+
+	signedInfo := signature.element(dsigNamespace, "SignedInfo")
+	if signedInfo == nil {
+		return SignatureInvalid
+	}
+
+	c14nMethod := signedInfo.element(dsigNamespace, "CanonicalizationMethod")
+	if c14nMethod == nil || c14nMethod.attr("Algorithm") != excC14N {
+		return errors.New("saml: unsupported canonicalization method")
+	}
+
+	signatureMethod := signedInfo.element(dsigNamespace, "SignatureMethod")
+	if signatureMethod == nil {
+		return SignatureInvalid
+	}
+	signatureHash, err := signatureAlgorithm(signatureMethod.attr("Algorithm"))
+	if err != nil {
+		return err
+	}
+
+	reference := signedInfo.element(dsigNamespace, "Reference")
+	id := el.attr("ID")
+	if reference == nil || id == "" || reference.attr("URI") != "#"+id {
+		return SignatureInvalid
+	}
+
+	// Only the transforms of the SAML signature profile are accepted
+	var prefixes []string
+	enveloped, canonicalized := false, false
+	if transforms := reference.element(dsigNamespace, "Transforms"); transforms != nil {
+		for _, transform := range transforms.elements(dsigNamespace, "Transform") {
+			switch transform.attr("Algorithm") {
+			case envelopedSignature:
+				enveloped = true
+			case excC14N:
+				canonicalized = true
+				prefixes = inclusivePrefixes(transform)
+			default:
+				return errors.New("saml: unsupported transform " + transform.attr("Algorithm"))
+			}
+		}
+	}
+	if !enveloped || !canonicalized {
+		return errors.New("saml: the signature must be an enveloped exclusive canonicalized signature")
+	}
+
+	digestMethod := reference.element(dsigNamespace, "DigestMethod")
+	digestValue := reference.element(dsigNamespace, "DigestValue")
+	if digestMethod == nil || digestValue == nil {
+		return SignatureInvalid
+	}
+
+	digestHash, err := digestAlgorithm(digestMethod.attr("Algorithm"))
+	if err != nil {
+		return err
+	}
+
+	expectedDigest, err := decodeBase64(digestValue.text())
+	if err != nil {
+		return SignatureInvalid
+	}
+
+	digest := hash(digestHash, canonicalize(el, signature, prefixes))
+	if subtle.ConstantTimeCompare(digest, expectedDigest) != 1 {
+		return SignatureInvalid
+	}
+
+	signatureValue := signature.element(dsigNamespace, "SignatureValue")
+	if signatureValue == nil {
+		return SignatureInvalid
+	}
+
+	decodedSignature, err := decodeBase64(signatureValue.text())
+	if err != nil {
+		return SignatureInvalid
+	}
+
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("saml: the identity provider certificate must hold a RSA key")
+	}
+
+	signed := hash(signatureHash, canonicalize(signedInfo, nil, inclusivePrefixes(c14nMethod)))
+	err = rsa.VerifyPKCS1v15(publicKey, signatureHash, signed, decodedSignature)
+	if err != nil {
+		return SignatureInvalid
+	}
+	return nil
+}
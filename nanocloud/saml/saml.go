@@ -0,0 +1,360 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	protocolNamespace  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	assertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+	metadataNamespace  = "urn:oasis:names:tc:SAML:2.0:metadata"
+
+	statusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	bearer        = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	httpPost      = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	// tolerated difference between the clocks of the IdP and ours
+	clockSkew = 3 * time.Minute
+)
+
+var InvalidResponse = errors.New("saml: invalid response")
+
+// What the identity provider asserts about the authenticated user
+type Assertion struct {
+	ID           string
+	Issuer       string
+	NameID       string
+	NameIDFormat string
+	// Attribute values indexed by the attribute names and friendly names
+	Attributes   map[string][]string
+	NotOnOrAfter time.Time
+}
+
+// Return the first value of the first attribute found among names.
+func (a *Assertion) Attribute(names ...string) string {
+	for _, name := range names {
+		if values := a.Attributes[name]; len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+type ServiceProvider struct {
+	EntityID string
+	// URL of the Assertion Consumer Service, where the IdP posts responses
+	ACSURL string
+	// Expected issuer of the assertions, not checked if empty
+	IdPEntityID    string
+	IdPCertificate *x509.Certificate
+
+	// Used instead of time.Now if set
+	Now func() time.Time
+
+	// IDs of the assertions already consumed, until they expire
+	consumed map[string]time.Time
+	mutex    sync.Mutex
+}
+
+func ParseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block != nil {
+		data = block.Bytes
+	}
+	return x509.ParseCertificate(data)
+}
+
+func (sp *ServiceProvider) now() time.Time {
+	if sp.Now != nil {
+		return sp.Now()
+	}
+	return time.Now()
+}
+
+type indexedEndpoint struct {
+	Binding   string `xml:"Binding,attr"`
+	Location  string `xml:"Location,attr"`
+	Index     int    `xml:"index,attr"`
+	IsDefault bool   `xml:"isDefault,attr"`
+}
+
+type entityDescriptor struct {
+	XMLName         xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string   `xml:"entityID,attr"`
+	SPSSODescriptor struct {
+		AuthnRequestsSigned       bool              `xml:"AuthnRequestsSigned,attr"`
+		WantAssertionsSigned      bool              `xml:"WantAssertionsSigned,attr"`
+		ProtocolSupport           string            `xml:"protocolSupportEnumeration,attr"`
+		NameIDFormats             []string          `xml:"NameIDFormat"`
+		AssertionConsumerServices []indexedEndpoint `xml:"AssertionConsumerService"`
+	} `xml:"SPSSODescriptor"`
+}
+
+// Return the SAML metadata describing the service provider to the IdP.
+func (sp *ServiceProvider) Metadata() ([]byte, error) {
+	descriptor := entityDescriptor{EntityID: sp.EntityID}
+	descriptor.SPSSODescriptor.WantAssertionsSigned = true
+	descriptor.SPSSODescriptor.ProtocolSupport = protocolNamespace
+	descriptor.SPSSODescriptor.NameIDFormats = []string{
+		"urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress",
+		"urn:oasis:names:tc:SAML:2.0:nameid-format:persistent",
+	}
+	descriptor.SPSSODescriptor.AssertionConsumerServices = []indexedEndpoint{
+		{Binding: httpPost, Location: sp.ACSURL, Index: 0, IsDefault: true},
+	}
+
+	data, err := xml.MarshalIndent(descriptor, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// Check that now is in the [notBefore, notOnOrAfter[ interval of el
+func (sp *ServiceProvider) checkValidity(el *element) error {
+	now := sp.now()
+
+	if value := el.attr("NotBefore"); value != "" {
+		notBefore, err := parseTime(value)
+		if err != nil || now.Add(clockSkew).Before(notBefore) {
+			return errors.New("saml: assertion not yet valid")
+		}
+	}
+
+	if value := el.attr("NotOnOrAfter"); value != "" {
+		notOnOrAfter, err := parseTime(value)
+		if err != nil || !now.Add(-clockSkew).Before(notOnOrAfter) {
+			return errors.New("saml: assertion expired")
+		}
+	}
+	return nil
+}
+
+func (sp *ServiceProvider) checkConditions(assertion *element) error {
+	conditions := assertion.element(assertionNamespace, "Conditions")
+	if conditions == nil {
+		return nil
+	}
+
+	err := sp.checkValidity(conditions)
+	if err != nil {
+		return err
+	}
+
+	// every AudienceRestriction must include us
+	for _, restriction := range conditions.elements(assertionNamespace, "AudienceRestriction") {
+		allowed := false
+		for _, audience := range restriction.elements(assertionNamespace, "Audience") {
+			if audience.text() == sp.EntityID {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return errors.New("saml: assertion not intended for this service provider")
+		}
+	}
+	return nil
+}
+
+func (sp *ServiceProvider) checkSubject(subject *element) error {
+	for _, confirmation := range subject.elements(assertionNamespace, "SubjectConfirmation") {
+		if confirmation.attr("Method") != bearer {
+			continue
+		}
+
+		data := confirmation.element(assertionNamespace, "SubjectConfirmationData")
+		if data == nil {
+			return nil
+		}
+
+		if recipient := data.attr("Recipient"); recipient != "" && recipient != sp.ACSURL {
+			return errors.New("saml: assertion not intended for this endpoint")
+		}
+		return sp.checkValidity(data)
+	}
+	return errors.New("saml: no bearer subject confirmation")
+}
+
+// Remember the assertion so that it can't be replayed.
+func (sp *ServiceProvider) consume(id string, expiresAt time.Time) error {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	now := sp.now()
+	if sp.consumed == nil {
+		sp.consumed = make(map[string]time.Time)
+	}
+	for consumedId, expiration := range sp.consumed {
+		if now.After(expiration) {
+			delete(sp.consumed, consumedId)
+		}
+	}
+
+	if _, exists := sp.consumed[id]; exists {
+		return errors.New("saml: assertion already used")
+	}
+
+	if expiresAt.IsZero() {
+		expiresAt = now.Add(time.Hour)
+	}
+	sp.consumed[id] = expiresAt.Add(clockSkew)
+	return nil
+}
+
+/*
+ * Validate the base64 encoded response posted to the ACS by the IdP
+ * (HTTP-POST binding) and return the assertion it contains. Either the
+ * response or the assertion must be signed with the IdP certificate.
+ * Encrypted assertions aren't supported.
+ */
+func (sp *ServiceProvider) ParseResponse(encoded string) (*Assertion, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, InvalidResponse
+	}
+
+	response, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !response.is(protocolNamespace, "Response") {
+		return nil, InvalidResponse
+	}
+
+	if destination := response.attr("Destination"); destination != "" && destination != sp.ACSURL {
+		return nil, errors.New("saml: response not intended for this endpoint")
+	}
+
+	responseSigned := hasSignature(response)
+	if responseSigned {
+		err = verifySignature(response, sp.IdPCertificate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	status := response.element(protocolNamespace, "Status")
+	if status == nil {
+		return nil, InvalidResponse
+	}
+	statusCode := status.element(protocolNamespace, "StatusCode")
+	if statusCode == nil || statusCode.attr("Value") != statusSuccess {
+		return nil, errors.New("saml: authentication failed on the identity provider")
+	}
+
+	if len(response.elements(assertionNamespace, "EncryptedAssertion")) > 0 {
+		return nil, errors.New("saml: encrypted assertions are not supported")
+	}
+
+	assertionElement := response.element(assertionNamespace, "Assertion")
+	if assertionElement == nil {
+		return nil, errors.New("saml: the response must contain exactly one assertion")
+	}
+
+	if hasSignature(assertionElement) {
+		err = verifySignature(assertionElement, sp.IdPCertificate)
+		if err != nil {
+			return nil, err
+		}
+	} else if !responseSigned {
+		return nil, SignatureMissing
+	}
+
+	assertion := Assertion{
+		ID:         assertionElement.attr("ID"),
+		Attributes: make(map[string][]string),
+	}
+	if assertion.ID == "" {
+		return nil, InvalidResponse
+	}
+
+	issuer := assertionElement.element(assertionNamespace, "Issuer")
+	if issuer != nil {
+		assertion.Issuer = issuer.text()
+	}
+	if sp.IdPEntityID != "" && assertion.Issuer != sp.IdPEntityID {
+		return nil, errors.New("saml: unexpected issuer " + assertion.Issuer)
+	}
+
+	err = sp.checkConditions(assertionElement)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := assertionElement.element(assertionNamespace, "Subject")
+	if subject == nil {
+		return nil, InvalidResponse
+	}
+
+	err = sp.checkSubject(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	nameID := subject.element(assertionNamespace, "NameID")
+	if nameID == nil {
+		return nil, InvalidResponse
+	}
+	assertion.NameID = nameID.text()
+	assertion.NameIDFormat = nameID.attr("Format")
+
+	if conditions := assertionElement.element(assertionNamespace, "Conditions"); conditions != nil {
+		if value := conditions.attr("NotOnOrAfter"); value != "" {
+			assertion.NotOnOrAfter, _ = parseTime(value)
+		}
+	}
+
+	for _, statement := range assertionElement.elements(assertionNamespace, "AttributeStatement") {
+		for _, attribute := range statement.elements(assertionNamespace, "Attribute") {
+			var values []string
+			for _, value := range attribute.elements(assertionNamespace, "AttributeValue") {
+				values = append(values, value.text())
+			}
+
+			for _, name := range []string{attribute.attr("Name"), attribute.attr("FriendlyName")} {
+				if name != "" {
+					assertion.Attributes[name] = append(assertion.Attributes[name], values...)
+				}
+			}
+		}
+	}
+
+	err = sp.consume(assertion.ID, assertion.NotOnOrAfter)
+	if err != nil {
+		return nil, err
+	}
+	return &assertion, nil
+}
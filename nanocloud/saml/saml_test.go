@@ -0,0 +1,328 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package saml
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A local stand-in for an identity provider signing its assertions
+type testIdP struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testIdP{key: key, cert: cert}
+}
+
+const (
+	testEntityID = "https://nanocloud.example.com/saml/metadata"
+	testACSURL   = "https://nanocloud.example.com/saml/acs"
+	testIssuer   = "https://idp.example.com"
+	dsigDecl     = ` xmlns:ds="http://www.w3.org/2000/09/xmldsig#"`
+	samlDecl     = ` xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion"`
+)
+
+type assertionOptions struct {
+	id           string
+	nameID       string
+	audience     string
+	notOnOrAfter time.Time
+}
+
+func defaultOptions() assertionOptions {
+	return assertionOptions{
+		id:           "_a1",
+		nameID:       "john@example.com",
+		audience:     testEntityID,
+		notOnOrAfter: time.Now().Add(5 * time.Minute),
+	}
+}
+
+func (idp *testIdP) assertion(opts assertionOptions) string {
+	notOnOrAfter := opts.notOnOrAfter.UTC().Format(time.RFC3339)
+	return fmt.Sprintf(`<saml:Assertion`+samlDecl+` ID="%s" Version="2.0" IssueInstant="%s">
+  <saml:Issuer>%s</saml:Issuer>
+  <saml:Subject>
+    <saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">%s</saml:NameID>
+    <saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+      <saml:SubjectConfirmationData Recipient="%s" NotOnOrAfter="%s"/>
+    </saml:SubjectConfirmation>
+  </saml:Subject>
+  <saml:Conditions NotOnOrAfter="%s">
+    <saml:AudienceRestriction><saml:Audience>%s</saml:Audience></saml:AudienceRestriction>
+  </saml:Conditions>
+  <saml:AttributeStatement>
+    <saml:Attribute Name="urn:oid:2.5.4.42" FriendlyName="givenName"><saml:AttributeValue>John &amp; Co</saml:AttributeValue></saml:Attribute>
+  </saml:AttributeStatement>
+</saml:Assertion>`,
+		opts.id, time.Now().UTC().Format(time.RFC3339),
+		testIssuer, opts.nameID,
+		testACSURL, notOnOrAfter,
+		notOnOrAfter, opts.audience,
+	)
+}
+
+// Add an enveloped signature, after the Issuer, to the element with the given ID
+func (idp *testIdP) sign(t *testing.T, document string, id string) string {
+	root, err := parseXML([]byte(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256(canonicalize(root, nil, nil))
+
+	signedInfo := `<ds:SignedInfo>` +
+		`<ds:CanonicalizationMethod Algorithm="` + excC14N + `"/>` +
+		`<ds:SignatureMethod Algorithm="` + signatureRSASHA256 + `"/>` +
+		`<ds:Reference URI="#` + id + `">` +
+		`<ds:Transforms>` +
+		`<ds:Transform Algorithm="` + envelopedSignature + `"/>` +
+		`<ds:Transform Algorithm="` + excC14N + `"/>` +
+		`</ds:Transforms>` +
+		`<ds:DigestMethod Algorithm="` + digestSHA256 + `"/>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue>` +
+		`</ds:Reference>` +
+		`</ds:SignedInfo>`
+
+	signedInfoElement, err := parseXML([]byte(strings.Replace(signedInfo, "<ds:SignedInfo>", "<ds:SignedInfo"+dsigDecl+">", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := sha256.Sum256(canonicalize(signedInfoElement, nil, nil))
+	signatureValue, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, signed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := `<ds:Signature` + dsigDecl + `>` + signedInfo +
+		`<ds:SignatureValue>` + base64.StdEncoding.EncodeToString(signatureValue) + `</ds:SignatureValue>` +
+		`</ds:Signature>`
+
+	return strings.Replace(document, "</saml:Issuer>", "</saml:Issuer>"+signature, 1)
+}
+
+// Wrap the assertions in a response. The saml namespace is declared by the
+// response, as most IdPs do.
+func response(assertions ...string) string {
+	var content string
+	for _, assertion := range assertions {
+		content += strings.Replace(assertion, samlDecl, "", 1)
+	}
+
+	document := `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol"` + samlDecl +
+		` ID="_r1" Version="2.0" Destination="` + testACSURL + `">` +
+		`<saml:Issuer>` + testIssuer + `</saml:Issuer>` +
+		`<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>` +
+		content +
+		`</samlp:Response>`
+
+	return base64.StdEncoding.EncodeToString([]byte(document))
+}
+
+func newServiceProvider(cert *x509.Certificate) *ServiceProvider {
+	return &ServiceProvider{
+		EntityID:       testEntityID,
+		ACSURL:         testACSURL,
+		IdPEntityID:    testIssuer,
+		IdPCertificate: cert,
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	// example of the section 2.2 of the Exclusive XML Canonicalization spec
+	root, err := parseXML([]byte(`<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"/></n1:elem2></n0:local>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"></n3:stuff></n1:elem2>`
+	actual := string(canonicalize(root.children[0].(*element), nil, nil))
+	if actual != expected {
+		t.Errorf("Expected %s, got %s", expected, actual)
+	}
+
+	root, err = parseXML([]byte(`<a xmlns="urn:a" xmlns:b="urn:b" z="1" b:y="&quot;2&quot;" a="&lt;3>"><!-- comment --><c xmlns="">x &gt; y</c></a>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = `<a xmlns="urn:a" xmlns:b="urn:b" a="&lt;3>" z="1" b:y="&quot;2&quot;"><c xmlns="">x &gt; y</c></a>`
+	actual = string(canonicalize(root, nil, nil))
+	if actual != expected {
+		t.Errorf("Expected %s, got %s", expected, actual)
+	}
+}
+
+func TestParseXMLRejectsDoctype(t *testing.T) {
+	_, err := parseXML([]byte(`<!DOCTYPE a [<!ENTITY b "c">]><a>&b;</a>`))
+	if err == nil {
+		t.Errorf("Documents with a DTD should be rejected")
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := newServiceProvider(idp.cert)
+
+	assertion, err := sp.ParseResponse(response(idp.sign(t, idp.assertion(defaultOptions()), "_a1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if assertion.NameID != "john@example.com" {
+		t.Errorf("Expected NameID john@example.com, got %s", assertion.NameID)
+	}
+
+	if assertion.Attribute("givenName") != "John & Co" {
+		t.Errorf("Expected givenName John & Co, got %s", assertion.Attribute("givenName"))
+	}
+
+	metadata, err := sp.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(metadata), testACSURL) {
+		t.Errorf("The metadata should contain the ACS URL")
+	}
+}
+
+func TestParseResponseTampered(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := newServiceProvider(idp.cert)
+
+	signed := idp.sign(t, idp.assertion(defaultOptions()), "_a1")
+	tampered := strings.Replace(signed, "john@example.com", "admin@example.com", 1)
+
+	_, err := sp.ParseResponse(response(tampered))
+	if err != SignatureInvalid {
+		t.Errorf("Expected SignatureInvalid, got %v", err)
+	}
+}
+
+func TestParseResponseUntrustedIdP(t *testing.T) {
+	idp := newTestIdP(t)
+	other := newTestIdP(t)
+	sp := newServiceProvider(idp.cert)
+
+	_, err := sp.ParseResponse(response(other.sign(t, other.assertion(defaultOptions()), "_a1")))
+	if err != SignatureInvalid {
+		t.Errorf("Expected SignatureInvalid, got %v", err)
+	}
+}
+
+func TestParseResponseUnsigned(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := newServiceProvider(idp.cert)
+
+	_, err := sp.ParseResponse(response(idp.assertion(defaultOptions())))
+	if err != SignatureMissing {
+		t.Errorf("Expected SignatureMissing, got %v", err)
+	}
+}
+
+func TestParseResponseWrapped(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := newServiceProvider(idp.cert)
+
+	forged := defaultOptions()
+	forged.id = "_a2"
+	forged.nameID = "admin@example.com"
+
+	_, err := sp.ParseResponse(response(
+		idp.sign(t, idp.assertion(defaultOptions()), "_a1"),
+		idp.assertion(forged),
+	))
+	if err == nil {
+		t.Errorf("An unsigned assertion next to a signed one should be rejected")
+	}
+}
+
+func TestParseResponseConditions(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := newServiceProvider(idp.cert)
+
+	expired := defaultOptions()
+	expired.notOnOrAfter = time.Now().Add(-time.Hour)
+	_, err := sp.ParseResponse(response(idp.sign(t, idp.assertion(expired), "_a1")))
+	if err == nil {
+		t.Errorf("Expired assertions should be rejected")
+	}
+
+	otherAudience := defaultOptions()
+	otherAudience.audience = "https://other.example.com"
+	_, err = sp.ParseResponse(response(idp.sign(t, idp.assertion(otherAudience), "_a1")))
+	if err == nil {
+		t.Errorf("Assertions for another audience should be rejected")
+	}
+}
+
+func TestParseResponseReplay(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := newServiceProvider(idp.cert)
+
+	encoded := response(idp.sign(t, idp.assertion(defaultOptions()), "_a1"))
+
+	_, err := sp.ParseResponse(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sp.ParseResponse(encoded)
+	if err == nil {
+		t.Errorf("An assertion should be accepted only once")
+	}
+}
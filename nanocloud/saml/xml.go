@@ -0,0 +1,323 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+type attribute struct {
+	prefix string
+	local  string
+	value  string
+}
+
+/*
+ * A minimal DOM keeping the namespace prefixes as written in the document,
+ * which the exclusive canonicalization needs. Comments are dropped as they
+ * are never part of a canonicalized SAML document.
+ */
+type element struct {
+	prefix   string
+	local    string
+	attrs    []attribute
+	nsDecls  map[string]string
+	parent   *element
+	children []interface{}
+}
+
+type text string
+
+// Return the namespace bound to prefix in the scope of e.
+func (e *element) lookup(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlNamespace, true
+	}
+
+	for el := e; el != nil; el = el.parent {
+		if uri, ok := el.nsDecls[prefix]; ok {
+			return uri, true
+		}
+	}
+	return "", prefix == ""
+}
+
+func (e *element) namespace() string {
+	uri, _ := e.lookup(e.prefix)
+	return uri
+}
+
+func (e *element) is(namespace, local string) bool {
+	return e.local == local && e.namespace() == namespace
+}
+
+// Return the value of an unqualified attribute.
+func (e *element) attr(local string) string {
+	for _, a := range e.attrs {
+		if a.prefix == "" && a.local == local {
+			return a.value
+		}
+	}
+	return ""
+}
+
+func (e *element) elements(namespace, local string) []*element {
+	var found []*element
+	for _, child := range e.children {
+		if el, ok := child.(*element); ok && el.is(namespace, local) {
+			found = append(found, el)
+		}
+	}
+	return found
+}
+
+// Return the single child matching the name, nil if there is none or several.
+func (e *element) element(namespace, local string) *element {
+	found := e.elements(namespace, local)
+	if len(found) != 1 {
+		return nil
+	}
+	return found[0]
+}
+
+func (e *element) text() string {
+	var buf bytes.Buffer
+	for _, child := range e.children {
+		if t, ok := child.(text); ok {
+			buf.WriteString(string(t))
+		}
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+func parseXML(data []byte) (*element, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+
+	var root, current *element
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if current == nil && root != nil {
+				return nil, errors.New("xml: multiple root elements")
+			}
+
+			el := &element{
+				prefix:  t.Name.Space,
+				local:   t.Name.Local,
+				nsDecls: make(map[string]string),
+				parent:  current,
+			}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "xmlns":
+					el.nsDecls[a.Name.Local] = a.Value
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					el.nsDecls[""] = a.Value
+				default:
+					el.attrs = append(el.attrs, attribute{a.Name.Space, a.Name.Local, a.Value})
+				}
+			}
+
+			if current == nil {
+				root = el
+			} else {
+				current.children = append(current.children, el)
+			}
+			current = el
+
+		case xml.EndElement:
+			if current == nil || t.Name.Space != current.prefix || t.Name.Local != current.local {
+				return nil, errors.New("xml: unexpected end element " + t.Name.Local)
+			}
+			current = current.parent
+
+		case xml.CharData:
+			if current != nil {
+				current.children = append(current.children, text(t))
+			}
+
+		case xml.Directive:
+			// DTDs could declare entities altering the signed content
+			return nil, errors.New("xml: directives are not allowed")
+		}
+	}
+
+	if root == nil || current != nil {
+		return nil, errors.New("xml: unexpected end of document")
+	}
+	return root, nil
+}
+
+type attributesByName struct {
+	attrs []attribute
+	ns    []string
+}
+
+func (a attributesByName) Len() int { return len(a.attrs) }
+
+func (a attributesByName) Swap(i, j int) {
+	a.attrs[i], a.attrs[j] = a.attrs[j], a.attrs[i]
+	a.ns[i], a.ns[j] = a.ns[j], a.ns[i]
+}
+
+func (a attributesByName) Less(i, j int) bool {
+	if a.ns[i] != a.ns[j] {
+		return a.ns[i] < a.ns[j]
+	}
+	return a.attrs[i].local < a.attrs[j].local
+}
+
+var textEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	"\r", "&#xD;",
+)
+
+var attrEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	"\"", "&quot;",
+	"\t", "&#x9;",
+	"\n", "&#xA;",
+	"\r", "&#xD;",
+)
+
+func qualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+/*
+ * Exclusive XML Canonicalization 1.0, without comments
+ * (http://www.w3.org/2001/10/xml-exc-c14n#).
+ * The excluded element, if any, is omitted from the output as done by the
+ * enveloped signature transform. inclusivePrefixes is the PrefixList of the
+ * InclusiveNamespaces parameter ("#default" stands for the default namespace).
+ */
+func canonicalize(e *element, excluded *element, inclusivePrefixes []string) []byte {
+	inclusive := make(map[string]bool)
+	for _, prefix := range inclusivePrefixes {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		inclusive[prefix] = true
+	}
+
+	var buf bytes.Buffer
+	writeCanonical(&buf, e, excluded, inclusive, map[string]string{})
+	return buf.Bytes()
+}
+
+func writeCanonical(buf *bytes.Buffer, e *element, excluded *element, inclusive map[string]bool, rendered map[string]string) {
+	utilized := map[string]bool{e.prefix: true}
+	for _, a := range e.attrs {
+		if a.prefix != "" && a.prefix != "xml" {
+			utilized[a.prefix] = true
+		}
+	}
+	for prefix := range inclusive {
+		if _, inScope := e.lookup(prefix); inScope {
+			utilized[prefix] = true
+		}
+	}
+
+	var prefixes []string
+	for prefix := range utilized {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	scope := make(map[string]string, len(rendered))
+	for prefix, uri := range rendered {
+		scope[prefix] = uri
+	}
+
+	buf.WriteString("<" + qualifiedName(e.prefix, e.local))
+
+	for _, prefix := range prefixes {
+		uri, _ := e.lookup(prefix)
+		current, isRendered := rendered[prefix]
+
+		if prefix == "" {
+			// xmlns="" is only needed to undeclare a rendered default namespace
+			if uri == current {
+				continue
+			}
+			buf.WriteString(` xmlns="` + attrEscaper.Replace(uri) + `"`)
+		} else {
+			if isRendered && uri == current {
+				continue
+			}
+			buf.WriteString(" xmlns:" + prefix + `="` + attrEscaper.Replace(uri) + `"`)
+		}
+		scope[prefix] = uri
+	}
+
+	sorted := attributesByName{
+		attrs: make([]attribute, len(e.attrs)),
+		ns:    make([]string, len(e.attrs)),
+	}
+	copy(sorted.attrs, e.attrs)
+	for i, a := range sorted.attrs {
+		if a.prefix != "" {
+			sorted.ns[i], _ = e.lookup(a.prefix)
+		}
+	}
+	sort.Sort(sorted)
+
+	for _, a := range sorted.attrs {
+		buf.WriteString(" " + qualifiedName(a.prefix, a.local) + `="` + attrEscaper.Replace(a.value) + `"`)
+	}
+	buf.WriteString(">")
+
+	for _, child := range e.children {
+		switch c := child.(type) {
+		case *element:
+			if c != excluded {
+				writeCanonical(buf, c, excluded, inclusive, scope)
+			}
+		case text:
+			buf.WriteString(textEscaper.Replace(string(c)))
+		}
+	}
+
+	buf.WriteString("</" + qualifiedName(e.prefix, e.local) + ">")
+}
@@ -0,0 +1,371 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package secrets encrypts the credentials stored in the database.
+//
+// Every value is encrypted with its own random data key, and the data key is
+// encrypted with a master key (envelope encryption). The encrypted values are
+// tagged with the version of their master key:
+//
+//	enc:<version>:<encrypted data key>:<encrypted value>
+//
+// New master keys get a higher version. The values encrypted with an older
+// key can still be decrypted, and Rotate re-encrypts their data key with the
+// current one.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+const prefix = "enc:"
+
+var (
+	InvalidMasterKey = errors.New("invalid master key")
+	UnknownKey       = errors.New("unknown master key version")
+	InvalidSecret    = errors.New("invalid encrypted value")
+)
+
+type keyring struct {
+	keys    map[int][]byte
+	current int
+}
+
+var (
+	kKeyring *keyring
+	kErr     error
+	kOnce    sync.Once
+)
+
+// Parse the master keys, one "<version>:<base64 32 bytes key>" per line or
+// separated by commas.
+func parseKeys(s string) (*keyring, error) {
+	k := keyring{keys: make(map[int][]byte)}
+
+	entries := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, InvalidMasterKey
+		}
+
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || version < 1 {
+			return nil, InvalidMasterKey
+		}
+
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(key) != 32 {
+			return nil, InvalidMasterKey
+		}
+
+		k.keys[version] = key
+		if version > k.current {
+			k.current = version
+		}
+	}
+	return &k, nil
+}
+
+// Read the master keys from value or, if empty, from the file at path. Keys
+// that are configured but can't be used are an error: the credentials must
+// never be stored in plain text because of a broken configuration.
+func readKeyring(value, path string) (*keyring, error) {
+	if value == "" && path == "" {
+		log.Warn("No master key configured, the credentials are stored in plain text")
+		return &keyring{keys: make(map[int][]byte)}, nil
+	}
+
+	if value == "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the master keys: %s", err)
+		}
+		value = string(b)
+	}
+
+	k, err := parseKeys(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.current == 0 {
+		return nil, InvalidMasterKey
+	}
+	return k, nil
+}
+
+// Load the master keys from SECRETS_MASTER_KEYS or from the file named by
+// SECRETS_MASTER_KEYS_FILE.
+func loadKeyring() (*keyring, error) {
+	kOnce.Do(func() {
+		kKeyring, kErr = readKeyring(
+			utils.Env("SECRETS_MASTER_KEYS", ""),
+			utils.Env("SECRETS_MASTER_KEYS_FILE", ""),
+		)
+		if kErr != nil {
+			log.Errorf("Unable to load the master keys: %s", kErr)
+		}
+	})
+	return kKeyring, kErr
+}
+
+// Load the master keys, the error is returned if they are configured but
+// can't be used. Nanocloud must not start in that case.
+func Load() error {
+	_, err := loadKeyring()
+	return err
+}
+
+// Whether a master key is configured
+func Enabled() bool {
+	k, err := loadKeyring()
+	return err == nil && k.current != 0
+}
+
+func seal(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, InvalidSecret
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, InvalidSecret
+	}
+	return plaintext, nil
+}
+
+func (k *keyring) encrypt(plaintext string) (string, error) {
+	if k.current == 0 {
+		return plaintext, nil
+	}
+
+	dataKey := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := seal(k.keys[k.current], dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := seal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(
+		"%s%d:%s:%s",
+		prefix,
+		k.current,
+		base64.RawStdEncoding.EncodeToString(wrappedKey),
+		base64.RawStdEncoding.EncodeToString(ciphertext),
+	), nil
+}
+
+type envelope struct {
+	version    int
+	wrappedKey []byte
+	ciphertext []byte
+}
+
+func parseEnvelope(value string) (*envelope, error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return nil, InvalidSecret
+	}
+
+	version, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, InvalidSecret
+	}
+
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, InvalidSecret
+	}
+
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, InvalidSecret
+	}
+	return &envelope{version, wrappedKey, ciphertext}, nil
+}
+
+func (k *keyring) dataKey(e *envelope) ([]byte, error) {
+	masterKey, exists := k.keys[e.version]
+	if !exists {
+		return nil, UnknownKey
+	}
+	return open(masterKey, e.wrappedKey)
+}
+
+func (k *keyring) decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, prefix) {
+		// stored before the encryption was enabled
+		return value, nil
+	}
+
+	e, err := parseEnvelope(value)
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := k.dataKey(e)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := open(dataKey, e.ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func (k *keyring) needsRotation(value string) bool {
+	if k.current == 0 {
+		return false
+	}
+	if !strings.HasPrefix(value, prefix) {
+		return true
+	}
+
+	e, err := parseEnvelope(value)
+	return err == nil && e.version != k.current
+}
+
+func (k *keyring) rotate(value string) (string, error) {
+	if k.current == 0 {
+		return value, nil
+	}
+	if !strings.HasPrefix(value, prefix) {
+		return k.encrypt(value)
+	}
+
+	e, err := parseEnvelope(value)
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := k.dataKey(e)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := seal(k.keys[k.current], dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(
+		"%s%d:%s:%s",
+		prefix,
+		k.current,
+		base64.RawStdEncoding.EncodeToString(wrappedKey),
+		base64.RawStdEncoding.EncodeToString(e.ciphertext),
+	), nil
+}
+
+// Encrypt the value with the current master key. The value is returned as is
+// if no master key is configured.
+func Encrypt(plaintext string) (string, error) {
+	k, err := loadKeyring()
+	if err != nil {
+		return "", err
+	}
+	return k.encrypt(plaintext)
+}
+
+// Decrypt a value returned by Encrypt. Values stored in plain text are
+// returned as is.
+func Decrypt(value string) (string, error) {
+	k, err := loadKeyring()
+	if err != nil {
+		return "", err
+	}
+	return k.decrypt(value)
+}
+
+// Whether the value is in plain text or encrypted with an older master key
+func NeedsRotation(value string) bool {
+	k, err := loadKeyring()
+	return err == nil && k.needsRotation(value)
+}
+
+// Encrypt the data key of the value with the current master key, the value
+// itself isn't decrypted. Values in plain text are encrypted.
+func Rotate(value string) (string, error) {
+	k, err := loadKeyring()
+	if err != nil {
+		return "", err
+	}
+	return k.rotate(value)
+}
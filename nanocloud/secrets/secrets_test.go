@@ -0,0 +1,172 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package secrets
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	key1 = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	key2 = "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+)
+
+func keys(t *testing.T, s string) *keyring {
+	k, err := parseKeys(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestParseKeys(t *testing.T) {
+	k := keys(t, "1:"+key1+"\n# rotated on 2016-06-01\n2:"+key2+"\n")
+	if k.current != 2 || len(k.keys) != 2 {
+		t.Errorf("expected 2 keys with version 2 current, got %d keys and version %d", len(k.keys), k.current)
+	}
+
+	k = keys(t, "")
+	if k.current != 0 {
+		t.Error("no key should be current")
+	}
+
+	for _, invalid := range []string{"1", "x:" + key1, "0:" + key1, "1:c2hvcnQ="} {
+		_, err := parseKeys(invalid)
+		if err != InvalidMasterKey {
+			t.Errorf("%q: expected InvalidMasterKey, got %v", invalid, err)
+		}
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	k := keys(t, "1:"+key1)
+
+	encrypted, err := k.encrypt("Nanocloud123+")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, "enc:1:") {
+		t.Errorf("unexpected format %q", encrypted)
+	}
+
+	again, err := k.encrypt("Nanocloud123+")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == encrypted {
+		t.Error("every value must have its own data key")
+	}
+
+	decrypted, err := k.decrypt(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != "Nanocloud123+" {
+		t.Errorf("expected Nanocloud123+, got %q", decrypted)
+	}
+
+	plain, err := k.decrypt("legacy")
+	if err != nil || plain != "legacy" {
+		t.Error("values in plain text must be returned as is")
+	}
+
+	tampered := encrypted[:len(encrypted)-2] + "AA"
+	_, err = k.decrypt(tampered)
+	if err != InvalidSecret {
+		t.Errorf("expected InvalidSecret, got %v", err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	old := keys(t, "1:"+key1)
+	encrypted, err := old.encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := keys(t, "1:"+key1+",2:"+key2)
+	if !k.needsRotation(encrypted) || !k.needsRotation("plain") {
+		t.Error("values of older keys and in plain text need a rotation")
+	}
+
+	rotated, err := k.rotate(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(rotated, "enc:2:") || k.needsRotation(rotated) {
+		t.Errorf("expected a value of the key 2, got %q", rotated)
+	}
+
+	decrypted, err := k.decrypt(rotated)
+	if err != nil || decrypted != "secret" {
+		t.Errorf("unable to decrypt the rotated value: %v", err)
+	}
+
+	// the old key is retired once every value is rotated
+	retired := keys(t, "2:"+key2)
+	_, err = retired.decrypt(encrypted)
+	if err != UnknownKey {
+		t.Errorf("expected UnknownKey, got %v", err)
+	}
+	decrypted, err = retired.decrypt(rotated)
+	if err != nil || decrypted != "secret" {
+		t.Errorf("unable to decrypt the rotated value: %v", err)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	k := keys(t, "")
+
+	value, err := k.encrypt("secret")
+	if err != nil || value != "secret" {
+		t.Error("values must be stored as is without master key")
+	}
+	if k.needsRotation("secret") {
+		t.Error("nothing can be rotated without master key")
+	}
+}
+
+func TestReadKeyring(t *testing.T) {
+	k, err := readKeyring("", "")
+	if err != nil || k.current != 0 {
+		t.Error("no master key should be loaded when none is configured")
+	}
+
+	k, err = readKeyring("1:"+key1, "")
+	if err != nil || k.current != 1 {
+		t.Errorf("expected the configured key, got %v", err)
+	}
+
+	for _, invalid := range []string{"1:c2hvcnQ=", "# no key yet"} {
+		_, err = readKeyring(invalid, "")
+		if err == nil {
+			t.Errorf("%q: configured keys that can't be used must be an error", invalid)
+		}
+	}
+
+	_, err = readKeyring("", "/nonexistent/master-keys")
+	if err == nil {
+		t.Error("an unreadable keys file must be an error")
+	}
+}
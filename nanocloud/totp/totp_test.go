@@ -0,0 +1,99 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// SHA1 test vectors of RFC 6238, appendix B
+var vectors = []struct {
+	time int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	for _, vector := range vectors {
+		actual := code(key, vector.time/Period, 8)
+		if actual != vector.code {
+			t.Errorf("At %d, expected %s, got %s", vector.time, vector.code, actual)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+
+	passcode, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if passcode != "050471" {
+		t.Errorf("Expected 050471, got %s", passcode)
+	}
+
+	step, _ := Validate(secret, passcode, now.Add(Period*time.Second))
+	if step != Step(now) {
+		t.Errorf("The code of the previous period should be accepted")
+	}
+
+	step, _ = Validate(secret, passcode, now.Add(3*Period*time.Second))
+	if step != 0 {
+		t.Errorf("Old codes should be refused")
+	}
+
+	step, _ = Validate(secret, "123", now)
+	if step != 0 {
+		t.Errorf("Codes with a wrong length should be refused")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateSecret()
+
+	if a == b {
+		t.Errorf("Secrets should be random")
+	}
+
+	uri := ProvisioningURI("Nanocloud", "john@example.com", a)
+	if !strings.HasPrefix(uri, "otpauth://totp/Nanocloud:john%40example.com?") {
+		t.Errorf("Unexpected provisioning URI %s", uri)
+	}
+}
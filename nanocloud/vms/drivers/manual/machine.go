@@ -28,6 +28,7 @@ import (
 	"net/http"
 
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/secrets"
 	"github.com/Nanocloud/community/nanocloud/vms"
 	"github.com/labstack/gommon/log"
 )
@@ -56,7 +57,21 @@ func (m *machine) Status() (vms.MachineStatus, error) {
 }
 
 func (m *machine) IP() (net.IP, error) {
-	return net.ParseIP(m.server), nil
+	ip := net.ParseIP(m.server)
+	if ip != nil {
+		return ip, nil
+	}
+
+	// the machine may have been registered with its hostname
+	ips, err := net.LookupIP(m.server)
+	if err != nil {
+		log.Error(err)
+		return nil, nil
+	}
+	if len(ips) == 0 {
+		return nil, nil
+	}
+	return ips[0], nil
 }
 
 func (m *machine) Type() (vms.MachineType, error) {
@@ -102,6 +117,11 @@ func (m *machine) Name() (string, error) {
 	return m.name, nil
 }
 
+// The password is stored encrypted and only decrypted here
 func (m *machine) Credentials() (string, string, error) {
-	return m.user, m.password, nil
+	password, err := secrets.Decrypt(m.password)
+	if err != nil {
+		return "", "", err
+	}
+	return m.user, password, nil
 }
@@ -26,6 +26,7 @@ import (
 	"fmt"
 
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/secrets"
 	"github.com/Nanocloud/community/nanocloud/vms"
 	"github.com/labstack/gommon/log"
 	uuid "github.com/satori/go.uuid"
@@ -36,12 +37,18 @@ type vm struct {
 
 func (v *vm) Create(attr vms.MachineAttributes) (vms.Machine, error) {
 	machine := &machine{
-		id:       uuid.NewV4().String(),
-		name:     attr.Name,
-		server:   attr.Ip,
-		user:     attr.Username,
-		password: attr.Password,
+		id:     uuid.NewV4().String(),
+		name:   attr.Name,
+		server: attr.Ip,
+		user:   attr.Username,
 	}
+
+	password, err := secrets.Encrypt(attr.Password)
+	if err != nil {
+		return nil, err
+	}
+	machine.password = password
+
 	rows, err := db.Query(
 		`INSERT INTO machines
 		(id, name, type, ip, username, password)